@@ -41,10 +41,7 @@ var builtinFuncs = []*BuiltinFunction{
 		Name:  "char",
 		Value: builtinChar,
 	},
-	{
-		Name:  "bytes",
-		Value: builtinBytes,
-	},
+	contextBuiltin("bytes", builtinBytes),
 	{
 		Name:  "time",
 		Value: builtinTime,
@@ -127,6 +124,29 @@ var builtinFuncs = []*BuiltinFunction{
 	},
 }
 
+// contextBuiltin returns a builtin function that receives the CallContext of
+// the VM calling it.
+func contextBuiltin(name string, fn CallableContextFunc) *BuiltinFunction {
+	return &BuiltinFunction{
+		Name: name,
+		Value: func(args ...Object) (Object, error) {
+			return fn(&CallContext{}, args...)
+		},
+		ctxValue: fn,
+	}
+}
+
+// typeNameBuiltinIndex is the index of 'type_name' builtin function, which is
+// used by type switches.
+var typeNameBuiltinIndex = func() int {
+	for idx, fn := range builtinFuncs {
+		if fn.Name == "type_name" {
+			return idx
+		}
+	}
+	panic("builtin function 'type_name' not found")
+}()
+
 // GetAllBuiltinFunctions returns all builtin function objects.
 func GetAllBuiltinFunctions() []*BuiltinFunction {
 	return append([]*BuiltinFunction{}, builtinFuncs...)
@@ -515,7 +535,7 @@ func builtinChar(args ...Object) (Object, error) {
 	return UndefinedValue, nil
 }
 
-func builtinBytes(args ...Object) (Object, error) {
+func builtinBytes(ctx *CallContext, args ...Object) (Object, error) {
 	argsLen := len(args)
 	if !(argsLen == 1 || argsLen == 2) {
 		return nil, ErrWrongNumArguments
@@ -523,14 +543,17 @@ func builtinBytes(args ...Object) (Object, error) {
 
 	// bytes(N) => create a new bytes with given size N
 	if n, ok := args[0].(*Int); ok {
-		if n.Value > int64(MaxBytesLen) {
+		if n.Value > int64(ctx.MaxBytesLen()) {
 			return nil, ErrBytesLimit
 		}
+		if err := ctx.CheckMemory(n.Value); err != nil {
+			return nil, err
+		}
 		return &Bytes{Value: make([]byte, int(n.Value))}, nil
 	}
 	v, ok := ToByteSlice(args[0])
 	if ok {
-		if len(v) > MaxBytesLen {
+		if len(v) > ctx.MaxBytesLen() {
 			return nil, ErrBytesLimit
 		}
 		return &Bytes{Value: v}, nil
@@ -162,6 +162,10 @@ func (b *Bytecode) RemoveDuplicates() {
 				indexMap[curIdx] = newIdx
 				deduped = append(deduped, c)
 			}
+		case *switchTable:
+			newIdx := len(deduped)
+			indexMap[curIdx] = newIdx
+			deduped = append(deduped, c)
 		default:
 			panic(fmt.Errorf("unsupported top-level constant type: %s",
 				c.TypeName()))
@@ -244,29 +248,34 @@ func fixDecodedObject(
 func updateConstIndexes(insts []byte, indexMap map[int]int) {
 	i := 0
 	for i < len(insts) {
-		op := insts[i]
-		numOperands := parser.OpcodeOperands[op]
-		_, read := parser.ReadOperands(numOperands, insts[i+1:])
+		op, operands, read, wide := parser.ReadInstruction(insts[i:])
 
+		// n is the operand of the constant index
+		n := -1
 		switch op {
-		case parser.OpConstant:
-			curIdx := int(insts[i+2]) | int(insts[i+1])<<8
+		case parser.OpConstant, parser.OpSwitch, parser.OpClosure,
+			parser.OpSelector:
+			n = 0
+		case parser.OpBinaryOpConst:
+			n = 1
+		}
+		if n >= 0 {
+			curIdx := operands[n]
 			newIdx, ok := indexMap[curIdx]
 			if !ok {
 				panic(fmt.Errorf("constant index not found: %d", curIdx))
 			}
-			copy(insts[i:], MakeInstruction(op, newIdx))
-		case parser.OpClosure:
-			curIdx := int(insts[i+2]) | int(insts[i+1])<<8
-			numFree := int(insts[i+3])
-			newIdx, ok := indexMap[curIdx]
-			if !ok {
-				panic(fmt.Errorf("constant index not found: %d", curIdx))
+			operands[n] = newIdx
+			inst := MakeInstruction(op, operands...)
+			if wide && inst[0] != parser.OpWide {
+				// keep the width of the instruction
+				inst = makeInstruction([]byte{parser.OpWide, op},
+					parser.WideOperands(op), operands)
 			}
-			copy(insts[i:], MakeInstruction(op, newIdx, numFree))
+			copy(insts[i:], inst)
 		}
 
-		i += 1 + read
+		i += read
 	}
 }
 
@@ -285,6 +294,7 @@ func init() {
 	gob.Register(&Bytes{})
 	gob.Register(&Char{})
 	gob.Register(&CompiledFunction{})
+	gob.Register(&ContextFunction{})
 	gob.Register(&Error{})
 	gob.Register(&Float{})
 	gob.Register(&ImmutableArray{})
@@ -295,4 +305,5 @@ func init() {
 	gob.Register(&Time{})
 	gob.Register(&Undefined{})
 	gob.Register(&UserFunction{})
+	gob.Register(&switchTable{})
 }
@@ -230,6 +230,60 @@ func TestBytecode_RemoveDuplicates(t *testing.T) {
 				&slim.Int{Value: 1},
 				&slim.Int{Value: 2},
 				&slim.Int{Value: 3})))
+
+	testBytecodeRemoveDuplicates(t,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 11, 1),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 38, 2)),
+			objectsArray(
+				&slim.Int{Value: 1},
+				&slim.Int{Value: 2},
+				&slim.Int{Value: 1})),
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 11, 1),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 38, 0)),
+			objectsArray(
+				&slim.Int{Value: 1},
+				&slim.Int{Value: 2})))
+}
+
+func TestBytecode_SwitchTable(t *testing.T) {
+	src := []byte(`
+f := func(x) {
+	switch x {
+	case 1: return "one"
+	case "b": return "two"
+	}
+	return "none"
+}
+a := [f(1), f("b"), f(3)]`)
+	fileSet := parser.NewFileSet()
+	file := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(file, src, nil)
+	f, err := p.ParseFile()
+	require.NoError(t, err)
+	c := slim.NewCompiler(file, nil, nil, nil, nil)
+	require.NoError(t, c.Compile(f))
+
+	b := c.Bytecode()
+	b.RemoveDuplicates()
+
+	var buf bytes.Buffer
+	require.NoError(t, b.Encode(&buf))
+	r := &slim.Bytecode{}
+	require.NoError(t, r.Decode(bytes.NewReader(buf.Bytes()), nil))
+
+	globals := make([]slim.Object, slim.GlobalsSize)
+	require.NoError(t, slim.NewVM(r, globals, -1).Run())
+	require.Equal(t, &slim.Array{Value: []slim.Object{
+		&slim.String{Value: "one"},
+		&slim.String{Value: "two"},
+		&slim.String{Value: "none"},
+	}}, globals[1])
 }
 
 func TestBytecode_CountObjects(t *testing.T) {
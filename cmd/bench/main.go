@@ -12,6 +12,8 @@ func main() {
 	runFib(35)
 	runFibTC1(35)
 	runFibTC2(35)
+	runLoop(10000000)
+	runExpr(100000)
 }
 
 func runFib(n int) {
@@ -41,6 +43,11 @@ fib := func(x) {
 			int(result.(*slim.Int).Value)))
 	}
 
+	unoptimizedTime, err := runUnoptimized([]byte(input))
+	if err != nil {
+		panic(err)
+	}
+
 	fmt.Println("-------------------------------------")
 	fmt.Printf("fibonacci(%d)\n", n)
 	fmt.Println("-------------------------------------")
@@ -49,6 +56,7 @@ fib := func(x) {
 	fmt.Printf("Parser:  %s\n", parseTime)
 	fmt.Printf("Compile: %s\n", compileTime)
 	fmt.Printf("VM:      %s\n", runTime)
+	fmt.Printf("VM (unoptimized): %s\n", unoptimizedTime)
 }
 
 func runFibTC1(n int) {
@@ -125,6 +133,70 @@ fib := func(x, a, b) {
 	fmt.Printf("VM:      %s\n", runTime)
 }
 
+func runLoop(n int) {
+	start := time.Now()
+	nativeResult := loop(n)
+	nativeTime := time.Since(start)
+
+	input := `
+loop := func(n) {
+	a := [1, 2, 3, 4]
+	s := 0
+	for i := 0; i < n; i++ {
+		j := i % 4
+		s += a[j] * 2
+	}
+	return s
+}
+` + fmt.Sprintf("out = loop(%d)", n)
+
+	parseTime, compileTime, runTime, result, err := runBench([]byte(input))
+	if err != nil {
+		panic(err)
+	}
+
+	if nativeResult != int(result.(*slim.Int).Value) {
+		panic(fmt.Errorf("wrong result: %d != %d", nativeResult,
+			int(result.(*slim.Int).Value)))
+	}
+
+	unoptimizedTime, err := runUnoptimized([]byte(input))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("-------------------------------------")
+	fmt.Printf("loop(%d)\n", n)
+	fmt.Println("-------------------------------------")
+	fmt.Printf("Result:  %d\n", nativeResult)
+	fmt.Printf("Go:      %s\n", nativeTime)
+	fmt.Printf("Parser:  %s\n", parseTime)
+	fmt.Printf("Compile: %s\n", compileTime)
+	fmt.Printf("VM:      %s\n", runTime)
+	fmt.Printf("VM (unoptimized): %s\n", unoptimizedTime)
+}
+
+func runExpr(n int) {
+	input := `out := a * 2 + b > 10 && c != "x"`
+
+	unpooledTime, err := runCompiled([]byte(input), n, false)
+	if err != nil {
+		panic(err)
+	}
+	pooledTime, err := runCompiled([]byte(input), n, true)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("-------------------------------------")
+	fmt.Printf("expression (%d runs)\n", n)
+	fmt.Println("-------------------------------------")
+	fmt.Printf("Unpooled: %s (%s/run)\n", unpooledTime,
+		unpooledTime/time.Duration(n))
+	fmt.Printf("Pooled:   %s (%s/run)\n", pooledTime,
+		pooledTime/time.Duration(n))
+}
+
 func fib(n int) int {
 	if n == 0 {
 		return 0
@@ -154,6 +226,16 @@ func fibTC2(n, a, b int) int {
 	}
 }
 
+func loop(n int) int {
+	a := []int{1, 2, 3, 4}
+	s := 0
+	for i := 0; i < n; i++ {
+		j := i % 4
+		s += a[j] * 2
+	}
+	return s
+}
+
 func runBench(
 	input []byte,
 ) (
@@ -170,7 +252,7 @@ func runBench(
 	}
 
 	var bytecode *slim.Bytecode
-	compileTime, bytecode, err = compileFile(astFile)
+	compileTime, bytecode, err = compileFile(astFile, true)
 	if err != nil {
 		return
 	}
@@ -180,6 +262,21 @@ func runBench(
 	return
 }
 
+// runUnoptimized runs the input compiled without the optimizations, e.g. the
+// specialised instructions, and returns the run time.
+func runUnoptimized(input []byte) (time.Duration, error) {
+	_, file, err := parse(input)
+	if err != nil {
+		return 0, err
+	}
+	_, bytecode, err := compileFile(file, false)
+	if err != nil {
+		return 0, err
+	}
+	runTime, _, err := runVM(bytecode)
+	return runTime, err
+}
+
 func parse(input []byte) (time.Duration, *parser.File, error) {
 	fileSet := parser.NewFileSet()
 	inputFile := fileSet.AddFile("bench", -1, len(input))
@@ -195,13 +292,17 @@ func parse(input []byte) (time.Duration, *parser.File, error) {
 	return time.Since(start), file, nil
 }
 
-func compileFile(file *parser.File) (time.Duration, *slim.Bytecode, error) {
+func compileFile(
+	file *parser.File,
+	optimize bool,
+) (time.Duration, *slim.Bytecode, error) {
 	symTable := slim.NewSymbolTable()
 	symTable.Define("out")
 
 	start := time.Now()
 
 	c := slim.NewCompiler(file.InputFile, symTable, nil, nil, nil)
+	c.EnableOptimization(optimize)
 	if err := c.Compile(file); err != nil {
 		return time.Since(start), nil, err
 	}
@@ -226,3 +327,25 @@ func runVM(
 
 	return time.Since(start), globals[0], nil
 }
+
+func runCompiled(input []byte, n int, pooled bool) (time.Duration, error) {
+	s := slim.NewScript(input)
+	_ = s.Add("a", 3)
+	_ = s.Add("b", 5)
+	_ = s.Add("c", "y")
+	s.EnableVMPool(pooled)
+	compiled, err := s.Compile()
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	for i := 0; i < n; i++ {
+		if err := compiled.Run(); err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	return time.Since(start), nil
+}
@@ -20,6 +20,7 @@ type compilationScope struct {
 	Instructions []byte
 	SymbolInit   map[string]bool
 	SourceMap    map[int]parser.Pos
+	Generator    bool // the scope contains a yield statement
 }
 
 // loop represents a loop construct that the compiler uses to track the current
@@ -27,6 +28,82 @@ type compilationScope struct {
 type loop struct {
 	Continues []int
 	Breaks    []int
+	Tries     int  // number of enclosing try blocks at loop entry
+	Switch    bool // switch statement: break only
+}
+
+// switchTable is a jump table of a switch statement whose case values are
+// all constants. It maps the case values to the positions of the case bodies.
+type switchTable struct {
+	ObjectImpl
+	Ints    map[int64]int
+	Strings map[string]int
+	Chars   map[rune]int
+	Default int
+}
+
+func (t *switchTable) TypeName() string {
+	return "switch-table"
+}
+
+func (t *switchTable) String() string {
+	return "<switch-table>"
+}
+
+// lookup returns the position of the case body matching the value o, or the
+// position of the default case.
+func (t *switchTable) lookup(o Object) int {
+	var pos int
+	var ok bool
+	switch o := o.(type) {
+	case *Int:
+		pos, ok = t.Ints[o.Value]
+	case *String:
+		pos, ok = t.Strings[o.Value]
+	case *Char:
+		pos, ok = t.Chars[o.Value]
+	}
+	if !ok {
+		return t.Default
+	}
+	return pos
+}
+
+// add adds the case value lit to the table. It returns false if lit is not a
+// constant or the value is already in the table.
+func (t *switchTable) add(lit parser.Expr, pos int) bool {
+	var exists bool
+	switch lit := lit.(type) {
+	case *parser.IntLit:
+		if _, exists = t.Ints[lit.Value]; !exists {
+			t.Ints[lit.Value] = pos
+		}
+	case *parser.StringLit:
+		if _, exists = t.Strings[lit.Value]; !exists {
+			t.Strings[lit.Value] = pos
+		}
+	case *parser.CharLit:
+		if _, exists = t.Chars[lit.Value]; !exists {
+			t.Chars[lit.Value] = pos
+		}
+	default:
+		return false
+	}
+	return !exists
+}
+
+// remap updates the positions in the table using the function f.
+func (t *switchTable) remap(f func(pos int) int) {
+	for k, pos := range t.Ints {
+		t.Ints[k] = f(pos)
+	}
+	for k, pos := range t.Strings {
+		t.Strings[k] = f(pos)
+	}
+	for k, pos := range t.Chars {
+		t.Chars[k] = f(pos)
+	}
+	t.Default = f(t.Default)
 }
 
 // CompilerError represents a compiler error.
@@ -54,9 +131,13 @@ type Compiler struct {
 	scopeIndex      int
 	modules         ModuleGetter
 	compiledModules map[string]*CompiledFunction
+	numCaches       int
 	allowFileImport bool
+	noOptimize      bool
 	loops           []*loop
 	loopIndex       int
+	tryDepth        int
+	chainJumps      []int
 	trace           io.Writer
 	indent          int
 }
@@ -121,6 +202,12 @@ func (c *Compiler) Compile(node parser.Node) error {
 				return err
 			}
 		}
+
+		// the main function ends with OpSuspend instead of a "return", and
+		// the functions of the modules are optimized by compileModule
+		if c.parent == nil && !c.noOptimize {
+			c.optimizeInsts()
+		}
 	case *parser.ExprStmt:
 		if err := c.Compile(node.Expr); err != nil {
 			return err
@@ -138,13 +225,20 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 	case *parser.BinaryExpr:
-		if node.Token == token.LAnd || node.Token == token.LOr {
+		if c.emitFolded(node) {
+			return nil
+		}
+		if node.Token == token.LAnd || node.Token == token.LOr ||
+			node.Token == token.Coalesce {
 			return c.compileLogical(node)
 		}
 
 		if err := c.Compile(node.LHS); err != nil {
 			return err
 		}
+		if c.emitBinaryOpConst(node) {
+			return nil
+		}
 		if err := c.Compile(node.RHS); err != nil {
 			return err
 		}
@@ -206,12 +300,34 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&String{Value: node.Value}))
+	case *parser.InterpolatedStringLit:
+		// "a${x}b" compiles to ("a" + x) + "b", where the left operand is
+		// always a string so that String.BinaryOp does the conversion.
+		parts := node.Parts
+		if _, ok := parts[0].(*parser.StringLit); !ok {
+			c.emit(node, parser.OpConstant,
+				c.addConstant(&String{Value: ""}))
+		} else {
+			if err := c.Compile(parts[0]); err != nil {
+				return err
+			}
+			parts = parts[1:]
+		}
+		for _, part := range parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+			c.emit(part, parser.OpBinaryOp, int(token.Add))
+		}
 	case *parser.CharLit:
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&Char{Value: node.Value}))
 	case *parser.UndefinedLit:
 		c.emit(node, parser.OpNull)
 	case *parser.UnaryExpr:
+		if c.emitFolded(node) {
+			return nil
+		}
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
@@ -273,19 +389,27 @@ func (c *Compiler) Compile(node parser.Node) error {
 		return c.compileForStmt(node)
 	case *parser.ForInStmt:
 		return c.compileForInStmt(node)
+	case *parser.TryStmt:
+		return c.compileTryStmt(node)
+	case *parser.SwitchStmt:
+		return c.compileSwitchStmt(node)
+	case *parser.TypeGuardExpr:
+		return c.errorf(node, "use of .(type) outside type switch")
 	case *parser.BranchStmt:
 		if node.Token == token.Break {
 			curLoop := c.currentLoop()
 			if curLoop == nil {
 				return c.errorf(node, "break not allowed outside loop")
 			}
+			c.emitTryEnds(node, curLoop)
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Breaks = append(curLoop.Breaks, pos)
 		} else if node.Token == token.Continue {
-			curLoop := c.currentLoop()
+			curLoop := c.continueLoop()
 			if curLoop == nil {
 				return c.errorf(node, "continue not allowed outside loop")
 			}
+			c.emitTryEnds(node, curLoop)
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Continues = append(curLoop.Continues, pos)
 		} else {
@@ -351,10 +475,27 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpMap, len(node.Elements)*2)
 
+	case *parser.ChainExpr:
+		jumps := c.chainJumps
+		c.chainJumps = nil
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		for _, pos := range c.chainJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+		c.chainJumps = jumps
 	case *parser.SelectorExpr: // selector on RHS side
+		if c.emitModuleMember(node) {
+			return nil
+		}
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		c.compileOptional(node, node.Optional)
+		if c.emitSelector(node) {
+			return nil
+		}
 		if err := c.Compile(node.Sel); err != nil {
 			return err
 		}
@@ -363,6 +504,10 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		c.compileOptional(node, node.Optional)
+		if c.emitIndexLocal(node) {
+			return nil
+		}
 		if err := c.Compile(node.Index); err != nil {
 			return err
 		}
@@ -371,6 +516,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		c.compileOptional(node, node.Optional)
 		if node.Low != nil {
 			if err := c.Compile(node.Low); err != nil {
 				return err
@@ -405,6 +551,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols()
 		numLocals := c.symbolTable.MaxSymbols()
+		generator := c.scopes[c.scopeIndex].Generator
 		instructions, sourceMap := c.leaveScope()
 
 		for _, s := range freeSymbols {
@@ -465,6 +612,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 			NumLocals:     numLocals,
 			NumParameters: len(node.Type.Params.List),
 			VarArgs:       node.Type.Params.VarArgs,
+			Generator:     generator,
 			SourceMap:     sourceMap,
 		}
 		if len(freeSymbols) > 0 {
@@ -473,6 +621,21 @@ func (c *Compiler) Compile(node parser.Node) error {
 		} else {
 			c.emit(node, parser.OpConstant, c.addConstant(compiledFunction))
 		}
+	case *parser.YieldStmt:
+		if c.symbolTable.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node, "yield not allowed outside function")
+		}
+
+		if node.Result == nil {
+			c.emit(node, parser.OpNull)
+		} else {
+			if err := c.Compile(node.Result); err != nil {
+				return err
+			}
+		}
+		c.emit(node, parser.OpYield)
+		c.scopes[c.scopeIndex].Generator = true
 	case *parser.ReturnStmt:
 		if c.symbolTable.Parent(true) == nil {
 			// outside the function
@@ -487,10 +650,26 @@ func (c *Compiler) Compile(node parser.Node) error {
 			}
 			c.emit(node, parser.OpReturn, 1)
 		}
+	case *parser.PropagateExpr:
+		if c.symbolTable.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node,
+				"error propagation not allowed outside function")
+		}
+
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+
+		// return the value if it's an error
+		jumpPos := c.emit(node, parser.OpJumpNotError, 0)
+		c.emit(node, parser.OpReturn, 1)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
 	case *parser.CallExpr:
 		if err := c.Compile(node.Func); err != nil {
 			return err
 		}
+		c.compileOptional(node, node.Optional)
 		for _, arg := range node.Args {
 			if err := c.Compile(arg); err != nil {
 				return err
@@ -621,6 +800,13 @@ func (c *Compiler) EnableFileImport(enable bool) {
 	c.allowFileImport = enable
 }
 
+// EnableOptimization enables or disables the optimizations of the compiled
+// code: folding of the constant expressions, and removal of the jumps to
+// jumps and of the values pushed only to be popped. It's enabled by default.
+func (c *Compiler) EnableOptimization(enable bool) {
+	c.noOptimize = !enable
+}
+
 // SetImportDir sets the initial import directory path for file imports.
 func (c *Compiler) SetImportDir(dir string) {
 	c.importDir = dir
@@ -665,8 +851,12 @@ func (c *Compiler) compileAssign(
 	op token.Token,
 ) error {
 	numLHS, numRHS := len(lhs), len(rhs)
-	if numLHS > 1 || numRHS > 1 {
-		return c.errorf(node, "tuple assignment not allowed")
+	if numLHS > 1 || numRHS > 1 || isPattern(lhs[0]) {
+		return c.compileDestructuring(node, lhs, rhs, op)
+	}
+
+	if _, ok := lhs[0].(*parser.ChainExpr); ok {
+		return c.errorf(node, "cannot assign to %s", lhs[0])
 	}
 
 	// resolve and compile left-hand side
@@ -695,6 +885,9 @@ func (c *Compiler) compileAssign(
 
 	// +=, -=, *=, /=
 	if op != token.Assign && op != token.Define {
+		if c.emitIncLocal(node, symbol, numSel, op, rhs[0]) {
+			return nil
+		}
 		if err := c.Compile(lhs[0]); err != nil {
 			return err
 		}
@@ -736,6 +929,141 @@ func (c *Compiler) compileAssign(
 		c.emit(node, parser.OpBinaryOp, int(token.Shr))
 	}
 
+	return c.compileStore(node, symbol, selectors, op)
+}
+
+// compileDestructuring compiles the assignments of multiple values: "a, b :=
+// x, y", "a, b := arr" and the destructuring patterns "[a, b] := arr" and
+// "{a, b: c} := m".
+func (c *Compiler) compileDestructuring(
+	node parser.Node,
+	lhs, rhs []parser.Expr,
+	op token.Token,
+) error {
+	if op != token.Assign && op != token.Define {
+		return c.errorf(node,
+			"operator '%s' not allowed with multiple assignment", op)
+	}
+
+	targets := lhs
+	if len(rhs) > 1 {
+		if len(lhs) != len(rhs) {
+			return c.errorf(node,
+				"assignment mismatch: %d variables but %d values",
+				len(lhs), len(rhs))
+		}
+		for _, expr := range rhs {
+			if err := c.Compile(expr); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := c.Compile(rhs[0]); err != nil {
+			return err
+		}
+
+		// values are unpacked by the indexes or keys
+		var keys []Object
+		switch pattern := lhs[0].(type) {
+		case *parser.ArrayLit:
+			targets = pattern.Elements
+		case *parser.MapLit:
+			targets = nil
+			for _, elt := range pattern.Elements {
+				targets = append(targets, elt.Value)
+				keys = append(keys, &String{Value: elt.Key})
+			}
+		}
+		if keys == nil {
+			for i := range targets {
+				keys = append(keys, &Int{Value: int64(i)})
+			}
+		}
+		if len(keys) > 255 {
+			return c.errorf(node, "too many variables to unpack")
+		}
+		for _, key := range keys {
+			c.emit(node, parser.OpConstant, c.addConstant(key))
+		}
+		c.emit(node, parser.OpUnpack, len(keys))
+	}
+
+	// at least one new variable is required for ':='
+	if op == token.Define {
+		var hasNew bool
+		names := make(map[string]bool)
+		for _, target := range targets {
+			ident, selectors := resolveAssignLHS(target)
+			if len(selectors) > 0 {
+				return c.errorf(node,
+					"operator ':=' not allowed with selector")
+			}
+			if ident == "_" {
+				continue
+			}
+			if names[ident] {
+				return c.errorf(target,
+					"'%s' repeated on left side of :=", ident)
+			}
+			names[ident] = true
+			if _, depth, exists := c.symbolTable.Resolve(
+				ident, false); !exists || depth > 0 {
+				hasNew = true
+			}
+		}
+		if !hasNew {
+			return c.errorf(node, "no new variables on left side of :=")
+		}
+	}
+
+	// resolve or define variables (left to right)
+	symbols := make([]*Symbol, len(targets))
+	targetOps := make([]token.Token, len(targets))
+	for i, target := range targets {
+		ident, _ := resolveAssignLHS(target)
+		if ident == "" {
+			return c.errorf(target, "cannot assign to %s", target)
+		}
+		if ident == "_" {
+			continue
+		}
+
+		symbol, depth, exists := c.symbolTable.Resolve(ident, false)
+		targetOps[i] = token.Assign
+		if op == token.Define && (!exists || depth > 0) {
+			symbol = c.symbolTable.Define(ident)
+			targetOps[i] = token.Define
+		} else if !exists {
+			return c.errorf(node, "unresolved reference '%s'", ident)
+		}
+		symbols[i] = symbol
+	}
+
+	// store values (right to left)
+	for i := len(targets) - 1; i >= 0; i-- {
+		if symbols[i] == nil {
+			c.emit(node, parser.OpPop)
+			continue
+		}
+		_, selectors := resolveAssignLHS(targets[i])
+		if err := c.compileStore(node, symbols[i], selectors,
+			targetOps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileStore stores the value on top of the stack to the variable of symbol
+// using the selectors.
+func (c *Compiler) compileStore(
+	node parser.Node,
+	symbol *Symbol,
+	selectors []parser.Expr,
+	op token.Token,
+) error {
+	numSel := len(selectors)
+
 	// compile selector expressions (right to left)
 	for i := numSel - 1; i >= 0; i-- {
 		if err := c.Compile(selectors[i]); err != nil {
@@ -784,10 +1112,13 @@ func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
 
 	// jump position
 	var jumpPos int
-	if node.Token == token.LAnd {
+	switch node.Token {
+	case token.LAnd:
 		jumpPos = c.emit(node, parser.OpAndJump, 0)
-	} else {
+	case token.LOr:
 		jumpPos = c.emit(node, parser.OpOrJump, 0)
+	default:
+		jumpPos = c.emit(node, parser.OpCoalesceJump, 0)
 	}
 
 	// right side term
@@ -799,6 +1130,16 @@ func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
 	return nil
 }
 
+// compileOptional emits the jump of an optional chaining link, which ends
+// the evaluation of the enclosing chain if the value on the stack is
+// undefined. The jumps are patched when compiling the chain completes.
+func (c *Compiler) compileOptional(node parser.Node, optional bool) {
+	if optional {
+		c.chainJumps = append(c.chainJumps,
+			c.emit(node, parser.OpJumpUndefined, 0))
+	}
+}
+
 func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
 	c.symbolTable = c.symbolTable.Fork(true)
 	defer func() {
@@ -865,6 +1206,305 @@ func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
 	return nil
 }
 
+func (c *Compiler) compileSwitchStmt(stmt *parser.SwitchStmt) error {
+	c.symbolTable = c.symbolTable.Fork(true)
+	defer func() {
+		c.symbolTable = c.symbolTable.Parent(false)
+	}()
+
+	if stmt.Init != nil {
+		if err := c.Compile(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	var clauses []*parser.CaseClause
+	var defaultClause *parser.CaseClause
+	for _, s := range stmt.Body.Stmts {
+		clause := s.(*parser.CaseClause)
+		if clause.List != nil {
+			clauses = append(clauses, clause)
+			continue
+		}
+		if defaultClause != nil {
+			return c.errorf(clause, "multiple defaults in switch")
+		}
+		defaultClause = clause
+	}
+
+	// type switch compares the type names
+	if guard, ok := stmt.Tag.(*parser.TypeGuardExpr); ok {
+		for _, clause := range clauses {
+			for i, e := range clause.List {
+				lit, err := c.typeCaseLit(e)
+				if err != nil {
+					return err
+				}
+				clause.List[i] = lit
+			}
+		}
+		c.emit(guard, parser.OpGetBuiltin, typeNameBuiltinIndex)
+		if err := c.Compile(guard.Expr); err != nil {
+			return err
+		}
+		c.emit(guard, parser.OpCall, 1, 0)
+	} else if stmt.Tag != nil {
+		if err := c.Compile(stmt.Tag); err != nil {
+			return err
+		}
+	}
+
+	loop := c.enterLoop()
+	loop.Switch = true
+
+	var err error
+	if stmt.Tag != nil && isConstantSwitch(clauses) {
+		err = c.compileSwitchTable(stmt, clauses, defaultClause)
+	} else {
+		err = c.compileSwitchChain(stmt, clauses, defaultClause)
+	}
+	c.leaveLoop()
+	if err != nil {
+		return err
+	}
+
+	// break jumps to the end of switch
+	endPos := len(c.currentInstructions())
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// compileSwitchTable compiles a switch statement whose case values are all
+// constants into a jump table. The tag value must be on the stack.
+func (c *Compiler) compileSwitchTable(
+	stmt *parser.SwitchStmt,
+	clauses []*parser.CaseClause,
+	defaultClause *parser.CaseClause,
+) error {
+	// switch statement is compiled like following:
+	//
+	//   SWITCH table     // jump to the matching case or default
+	//   ... case 1 body ...
+	//   JMP end
+	//   ... case 2 body ...
+	//   JMP end
+	//   ... default body ...
+	// end:
+
+	table := &switchTable{
+		Ints:    make(map[int64]int),
+		Strings: make(map[string]int),
+		Chars:   make(map[rune]int),
+	}
+	c.emit(stmt, parser.OpSwitch, c.addConstant(table))
+
+	var endJumps []int
+	for _, clause := range clauses {
+		bodyPos := len(c.currentInstructions())
+		for _, e := range clause.List {
+			if !table.add(e, bodyPos) {
+				return c.errorf(e, "duplicate case %s in switch", e)
+			}
+		}
+		if err := c.compileCaseBody(clause); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(clause, parser.OpJump, 0))
+	}
+
+	table.Default = len(c.currentInstructions())
+	if defaultClause != nil {
+		if err := c.compileCaseBody(defaultClause); err != nil {
+			return err
+		}
+	}
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// compileSwitchChain compiles a switch statement into a chain of conditional
+// jumps. If the switch has a tag, its value must be on the stack.
+func (c *Compiler) compileSwitchChain(
+	stmt *parser.SwitchStmt,
+	clauses []*parser.CaseClause,
+	defaultClause *parser.CaseClause,
+) error {
+	// switch statement is compiled like following:
+	//
+	//   :switch := tag
+	//   :switch == v1 || :switch == v2   // c1 || c2 for tagless switch
+	//   JMPF next
+	//   ... case 1 body ...
+	//   JMP end
+	// next:
+	//   ...
+	//   ... default body ...
+	// end:
+	//
+	// ":switch" is a local variable but it will not conflict with other user
+	// variables because character ":" is not allowed in the variable names.
+
+	var tagSymbol *Symbol
+	if stmt.Tag != nil {
+		tagSymbol = c.symbolTable.Define(":switch")
+		if tagSymbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpSetGlobal, tagSymbol.Index)
+		} else {
+			tagSymbol.LocalAssigned = true
+			c.emit(stmt, parser.OpDefineLocal, tagSymbol.Index)
+		}
+	}
+
+	var endJumps []int
+	for _, clause := range clauses {
+		var orJumps []int
+		for i, e := range clause.List {
+			if tagSymbol != nil {
+				if tagSymbol.Scope == ScopeGlobal {
+					c.emit(e, parser.OpGetGlobal, tagSymbol.Index)
+				} else {
+					c.emit(e, parser.OpGetLocal, tagSymbol.Index)
+				}
+			}
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+			if tagSymbol != nil {
+				c.emit(e, parser.OpEqual)
+			}
+			if i < len(clause.List)-1 {
+				orJumps = append(orJumps, c.emit(e, parser.OpOrJump, 0))
+			}
+		}
+		condPos := len(c.currentInstructions())
+		for _, pos := range orJumps {
+			c.changeOperand(pos, condPos)
+		}
+
+		nextJump := c.emit(clause, parser.OpJumpFalsy, 0)
+		if err := c.compileCaseBody(clause); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(clause, parser.OpJump, 0))
+		c.changeOperand(nextJump, len(c.currentInstructions()))
+	}
+
+	if defaultClause != nil {
+		if err := c.compileCaseBody(defaultClause); err != nil {
+			return err
+		}
+	}
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileCaseBody(clause *parser.CaseClause) error {
+	c.symbolTable = c.symbolTable.Fork(true)
+	defer func() {
+		c.symbolTable = c.symbolTable.Parent(false)
+	}()
+
+	for _, stmt := range clause.Body {
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeCaseLit converts a type name in a type switch case to a string
+// literal.
+func (c *Compiler) typeCaseLit(e parser.Expr) (*parser.StringLit, error) {
+	switch e := e.(type) {
+	case *parser.Ident:
+		return &parser.StringLit{Value: e.Name, ValuePos: e.NamePos}, nil
+	case *parser.UndefinedLit:
+		return &parser.StringLit{
+			Value:    "undefined",
+			ValuePos: e.TokenPos,
+		}, nil
+	case *parser.StringLit:
+		return e, nil
+	}
+	return nil, c.errorf(e, "invalid type in type switch case: %s", e)
+}
+
+// isConstantSwitch returns true if all case values are constants that can be
+// used in a switch table.
+func isConstantSwitch(clauses []*parser.CaseClause) bool {
+	for _, clause := range clauses {
+		for _, e := range clause.List {
+			switch e.(type) {
+			case *parser.IntLit, *parser.StringLit, *parser.CharLit:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *Compiler) compileTryStmt(stmt *parser.TryStmt) error {
+	// try statement is compiled like following:
+	//
+	//   TRY catch      // push error handler
+	//   ... body ...
+	//   TRYEND         // pop error handler
+	//   JUMP end
+	// catch:
+	//   e := <error>   // pushed by the VM
+	//   ... catch body ...
+	// end:
+
+	tryPos := c.emit(stmt, parser.OpTry, 0)
+
+	c.tryDepth++
+	err := c.Compile(stmt.Body)
+	c.tryDepth--
+	if err != nil {
+		return err
+	}
+
+	c.emit(stmt, parser.OpTryEnd)
+	jumpPos := c.emit(stmt, parser.OpJump, 0)
+
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	c.symbolTable = c.symbolTable.Fork(true)
+	defer func() {
+		c.symbolTable = c.symbolTable.Parent(false)
+	}()
+
+	if stmt.Ident != nil && stmt.Ident.Name != "_" {
+		symbol := c.symbolTable.Define(stmt.Ident.Name)
+		if symbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpSetGlobal, symbol.Index)
+		} else {
+			symbol.LocalAssigned = true
+			c.emit(stmt, parser.OpDefineLocal, symbol.Index)
+		}
+	} else {
+		c.emit(stmt, parser.OpPop)
+	}
+
+	if err := c.Compile(stmt.Catch); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
 func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 	c.symbolTable = c.symbolTable.Fork(true)
 	defer func() {
@@ -1053,7 +1693,7 @@ func (c *Compiler) storeCompiledModule(
 }
 
 func (c *Compiler) enterLoop() *loop {
-	loop := &loop{}
+	loop := &loop{Tries: c.tryDepth}
 	c.loops = append(c.loops, loop)
 	c.loopIndex++
 	if c.trace != nil {
@@ -1070,6 +1710,16 @@ func (c *Compiler) leaveLoop() {
 	c.loopIndex--
 }
 
+// continueLoop returns the innermost loop that is not a switch statement.
+func (c *Compiler) continueLoop() *loop {
+	for i := c.loopIndex; i >= 0; i-- {
+		if !c.loops[i].Switch {
+			return c.loops[i]
+		}
+	}
+	return nil
+}
+
 func (c *Compiler) currentLoop() *loop {
 	if c.loopIndex >= 0 {
 		return c.loops[c.loopIndex]
@@ -1077,6 +1727,14 @@ func (c *Compiler) currentLoop() *loop {
 	return nil
 }
 
+// emitTryEnds pops the error handlers of the try blocks that a break or
+// continue statement jumps out of.
+func (c *Compiler) emitTryEnds(node parser.Node, curLoop *loop) {
+	for i := curLoop.Tries; i < c.tryDepth; i++ {
+		c.emit(node, parser.OpTryEnd)
+	}
+}
+
 func (c *Compiler) currentInstructions() []byte {
 	return c.scopes[c.scopeIndex].Instructions
 }
@@ -1123,6 +1781,7 @@ func (c *Compiler) fork(
 	child.modulePath = modulePath // module file path
 	child.parent = c              // parent to set to current compiler
 	child.allowFileImport = c.allowFileImport
+	child.noOptimize = c.noOptimize
 	child.importDir = c.importDir
 	child.importFileExt = c.importFileExt
 	if isFile && c.importDir != "" {
@@ -1163,6 +1822,36 @@ func (c *Compiler) addConstant(o Object) int {
 	return len(c.constants) - 1
 }
 
+func (c *Compiler) constant(idx int) Object {
+	if c.parent != nil {
+		return c.parent.constant(idx)
+	}
+	return c.constants[idx]
+}
+
+func (c *Compiler) numConstants() int {
+	if c.parent != nil {
+		return c.parent.numConstants()
+	}
+	return len(c.constants)
+}
+
+func (c *Compiler) cacheCount() int {
+	if c.parent != nil {
+		return c.parent.cacheCount()
+	}
+	return c.numCaches
+}
+
+// addCache returns the index of a new inline cache of the VM.
+func (c *Compiler) addCache() int {
+	if c.parent != nil {
+		return c.parent.addCache()
+	}
+	c.numCaches++
+	return c.numCaches - 1
+}
+
 func (c *Compiler) addInstruction(b []byte) int {
 	posNewIns := len(c.currentInstructions())
 	c.scopes[c.scopeIndex].Instructions = append(
@@ -1189,6 +1878,14 @@ func (c *Compiler) changeOperand(opPos int, operand ...int) {
 // instructions. It also removes unreachable (dead code) instructions and adds
 // "returns" instruction if needed.
 func (c *Compiler) optimizeFunc(node parser.Node) {
+	if c.optimizeInsts() {
+		c.emit(node, parser.OpReturn, 0)
+	}
+}
+
+// optimizeInsts optimizes the current instructions and returns true if a
+// "return" instruction must be appended to them.
+func (c *Compiler) optimizeInsts() bool {
 	// any instructions between RETURN and the function end
 	// or instructions between RETURN and jump target position
 	// are considered as unreachable.
@@ -1199,17 +1896,27 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy,
-				parser.OpAndJump, parser.OpOrJump:
+				parser.OpAndJump, parser.OpOrJump, parser.OpTry,
+				parser.OpJumpNotError, parser.OpCoalesceJump,
+				parser.OpJumpUndefined:
 				dsts[operands[0]] = true
+			case parser.OpSwitch:
+				c.constant(operands[0]).(*switchTable).remap(
+					func(pos int) int {
+						dsts[pos] = true
+						return pos
+					})
 			}
 			return true
 		})
 
-	// pass 2. eliminate dead code
+	// pass 2. eliminate dead code, and the values pushed only to be popped
 	var newInsts []byte
-	posMap := make(map[int]int) // old position to new position
+	posMap := make(map[int]int)   // old position to new position
+	removed := make(map[int]bool) // old positions of removed instructions
 	var dstIdx int
 	var deadCode bool
+	lastPos, lastOp := -1, parser.Opcode(0)
 	iterateInstructions(c.scopes[c.scopeIndex].Instructions,
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch {
@@ -1223,29 +1930,44 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 				deadCode = true
 			case deadCode:
 				return true
+			case opcode == parser.OpPop && lastPos >= 0 &&
+				!c.noOptimize && isPurePush(lastOp):
+				// jumps to the removed push go to the next instruction
+				newInsts = newInsts[:posMap[lastPos]]
+				removed[lastPos], removed[pos] = true, true
+				lastPos = -1
+				return true
 			}
 			posMap[pos] = len(newInsts)
 			newInsts = append(newInsts,
 				MakeInstruction(opcode, operands...)...)
+			lastPos, lastOp = pos, opcode
 			return true
 		})
 
-	// pass 3. update jump positions
-	var lastOp parser.Opcode
+	// pass 3. update jump positions, and redirect the jumps to jumps to the
+	// final targets
 	var appendReturn bool
-	endPos := len(c.scopes[c.scopeIndex].Instructions)
+	insts := c.scopes[c.scopeIndex].Instructions
+	endPos := len(insts)
+	lastOp = 0
 	newEndPost := len(newInsts)
 
 	iterateInstructions(newInsts,
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
-				parser.OpOrJump:
-				newDst, ok := posMap[operands[0]]
+				parser.OpOrJump, parser.OpTry, parser.OpJumpNotError,
+				parser.OpCoalesceJump, parser.OpJumpUndefined:
+				dst := operands[0]
+				if !c.noOptimize {
+					dst = jumpTarget(insts, dst)
+				}
+				newDst, ok := posMap[dst]
 				if ok {
 					copy(newInsts[pos:],
 						MakeInstruction(opcode, newDst))
-				} else if endPos == operands[0] {
+				} else if endPos == dst {
 					// there's a jump instruction that jumps to the end of
 					// function compiler should append "return".
 					copy(newInsts[pos:],
@@ -1254,6 +1976,18 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 				} else {
 					panic(fmt.Errorf("invalid jump position: %d", newDst))
 				}
+			case parser.OpSwitch:
+				c.constant(operands[0]).(*switchTable).remap(
+					func(pos int) int {
+						newDst, ok := posMap[pos]
+						if ok {
+							return newDst
+						} else if endPos == pos {
+							appendReturn = true
+							return newEndPost
+						}
+						panic(fmt.Errorf("invalid jump position: %d", pos))
+					})
 			}
 			lastOp = opcode
 			return true
@@ -1266,17 +2000,309 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 	newSourceMap := make(map[int]parser.Pos)
 	for pos, srcPos := range c.scopes[c.scopeIndex].SourceMap {
 		newPos, ok := posMap[pos]
-		if ok {
+		if ok && !removed[pos] {
 			newSourceMap[newPos] = srcPos
 		}
 	}
 	c.scopes[c.scopeIndex].Instructions = newInsts
 	c.scopes[c.scopeIndex].SourceMap = newSourceMap
+	return appendReturn
+}
 
-	// append "return"
-	if appendReturn {
-		c.emit(node, parser.OpReturn, 0)
+// emitFolded emits the value of the expression if it's a constant expression,
+// i.e. an expression of literals only, and returns true. Expressions whose
+// evaluation fails are left to fail at run time.
+func (c *Compiler) emitFolded(node parser.Expr) bool {
+	if c.noOptimize {
+		return false
+	}
+	val, ok := foldConstant(node)
+	if !ok {
+		return false
+	}
+	switch val := val.(type) {
+	case *Bool:
+		if val == TrueValue {
+			c.emit(node, parser.OpTrue)
+		} else {
+			c.emit(node, parser.OpFalse)
+		}
+	default:
+		c.emit(node, parser.OpConstant, c.addConstant(val))
+	}
+	return true
+}
+
+// emitBinaryOpConst emits OpBinaryOpConst if the right operand of the binary
+// expression is a constant integer, and returns true. The left operand must be
+// already compiled.
+func (c *Compiler) emitBinaryOpConst(node *parser.BinaryExpr) bool {
+	if c.noOptimize || c.numConstants() > 0xFFFF {
+		return false
+	}
+	switch node.Token {
+	case token.Add, token.Sub, token.Mul, token.Quo, token.Rem,
+		token.Greater, token.GreaterEq, token.Less, token.LessEq,
+		token.Equal, token.NotEqual, token.And, token.Or, token.Xor,
+		token.AndNot, token.Shl, token.Shr:
+	default:
+		return false
+	}
+	val, ok := foldConstant(node.RHS)
+	if !ok {
+		return false
 	}
+	if _, ok := val.(*Int); !ok {
+		return false
+	}
+	c.emit(node, parser.OpBinaryOpConst, int(node.Token), c.addConstant(val))
+	return true
+}
+
+// emitModuleMember emits the member of a builtin module selected from its
+// import expression, e.g. import("math").pi, as a constant, and returns true.
+// Only the members of the types that can be constants of the bytecode are
+// resolved at compile time; the functions are selected at run time.
+func (c *Compiler) emitModuleMember(node *parser.SelectorExpr) bool {
+	if c.noOptimize {
+		return false
+	}
+	imp, ok := node.Expr.(*parser.ImportExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := node.Sel.(*parser.StringLit)
+	if !ok {
+		return false
+	}
+	mod, ok := c.modules.Get(imp.ModuleName).(*BuiltinModule)
+	if !ok {
+		return false
+	}
+
+	switch val := mod.Attrs[sel.Value].(type) {
+	case nil:
+		c.emit(node, parser.OpNull)
+	case *Bool:
+		if val.IsFalsy() {
+			c.emit(node, parser.OpFalse)
+		} else {
+			c.emit(node, parser.OpTrue)
+		}
+	case *Int, *Float, *String, *Char:
+		c.emit(node, parser.OpConstant, c.addConstant(val.Copy()))
+	default:
+		return false
+	}
+	return true
+}
+
+// emitSelector emits OpSelector for the selector of a name, so that the
+// member of a builtin module is looked up by the inline cache of the
+// instruction, and returns true. The selected expression must be
+// already compiled.
+func (c *Compiler) emitSelector(node *parser.SelectorExpr) bool {
+	if c.noOptimize || c.numConstants() > 0xFFFF ||
+		c.cacheCount() > 0xFFFF {
+		return false
+	}
+	sel, ok := node.Sel.(*parser.StringLit)
+	if !ok || len(sel.Value) > MaxStringLen {
+		return false
+	}
+	c.emit(node, parser.OpSelector,
+		c.addConstant(&String{Value: sel.Value}), c.addCache())
+	return true
+}
+
+// emitIndexLocal emits OpIndexLocal if the index of the index expression is a
+// local variable, and returns true. The indexed expression must be already
+// compiled.
+func (c *Compiler) emitIndexLocal(node *parser.IndexExpr) bool {
+	if c.noOptimize {
+		return false
+	}
+	ident, ok := node.Index.(*parser.Ident)
+	if !ok {
+		return false
+	}
+	symbol, _, ok := c.symbolTable.Resolve(ident.Name, false)
+	if !ok || symbol.Scope != ScopeLocal || symbol.Index > 0xFF {
+		return false
+	}
+	c.emit(node, parser.OpIndexLocal, symbol.Index)
+	return true
+}
+
+// emitIncLocal emits OpIncLocal for "i++", "i--", "i += k" and "i -= k" on a
+// local variable, where k is a constant integer between 1 and 127, and
+// returns true.
+func (c *Compiler) emitIncLocal(
+	node parser.Node,
+	symbol *Symbol,
+	numSel int,
+	op token.Token,
+	rhs parser.Expr,
+) bool {
+	if c.noOptimize || numSel > 0 || symbol.Scope != ScopeLocal ||
+		symbol.Index > 0xFF {
+		return false
+	}
+	if op != token.AddAssign && op != token.SubAssign {
+		return false
+	}
+	val, ok := foldConstant(rhs)
+	if !ok {
+		return false
+	}
+	k, ok := val.(*Int)
+	if !ok || k.Value < 1 || k.Value > 127 {
+		return false
+	}
+
+	// the delta is a signed byte
+	delta := int(k.Value)
+	if op == token.SubAssign {
+		delta = -delta
+	}
+	c.emit(node, parser.OpIncLocal, symbol.Index, delta&0xFF)
+	symbol.LocalAssigned = true
+	return true
+}
+
+// foldConstant evaluates the constant expression and returns its value, or
+// false if the expression is not constant.
+func foldConstant(expr parser.Expr) (Object, bool) {
+	switch expr := expr.(type) {
+	case *parser.IntLit:
+		return &Int{Value: expr.Value}, true
+	case *parser.FloatLit:
+		return &Float{Value: expr.Value}, true
+	case *parser.CharLit:
+		return &Char{Value: expr.Value}, true
+	case *parser.StringLit:
+		if len(expr.Value) > MaxStringLen {
+			return nil, false
+		}
+		return &String{Value: expr.Value}, true
+	case *parser.BoolLit:
+		if expr.Value {
+			return TrueValue, true
+		}
+		return FalseValue, true
+	case *parser.ParenExpr:
+		return foldConstant(expr.Expr)
+	case *parser.UnaryExpr:
+		x, ok := foldConstant(expr.Expr)
+		if !ok {
+			return nil, false
+		}
+		switch expr.Token {
+		case token.Not:
+			if x.IsFalsy() {
+				return TrueValue, true
+			}
+			return FalseValue, true
+		case token.Sub:
+			switch x := x.(type) {
+			case *Int:
+				return &Int{Value: -x.Value}, true
+			case *Float:
+				return &Float{Value: -x.Value}, true
+			}
+		case token.Xor:
+			if x, ok := x.(*Int); ok {
+				return &Int{Value: ^x.Value}, true
+			}
+		case token.Add:
+			return x, true
+		}
+		return nil, false
+	case *parser.BinaryExpr:
+		if expr.Token == token.Coalesce {
+			return nil, false
+		}
+		lhs, ok := foldConstant(expr.LHS)
+		if !ok {
+			return nil, false
+		}
+		rhs, ok := foldConstant(expr.RHS)
+		if !ok {
+			return nil, false
+		}
+		switch expr.Token {
+		case token.LAnd:
+			if lhs.IsFalsy() {
+				return lhs, true
+			}
+			return rhs, true
+		case token.LOr:
+			if !lhs.IsFalsy() {
+				return lhs, true
+			}
+			return rhs, true
+		case token.Equal:
+			if lhs.Equals(rhs) {
+				return TrueValue, true
+			}
+			return FalseValue, true
+		case token.NotEqual:
+			if lhs.Equals(rhs) {
+				return FalseValue, true
+			}
+			return TrueValue, true
+		}
+		res, ok := foldBinaryOp(lhs, expr.Token, rhs)
+		if !ok {
+			return nil, false
+		}
+		switch res := res.(type) {
+		case *Int, *Float, *Char, *Bool:
+			return res, true
+		case *String:
+			if len(res.Value) <= MaxStringLen {
+				return res, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// isPurePush returns true if the opcode only pushes a value without any side
+// effect.
+func isPurePush(op parser.Opcode) bool {
+	switch op {
+	case parser.OpConstant, parser.OpNull, parser.OpTrue, parser.OpFalse,
+		parser.OpGetLocal, parser.OpGetGlobal, parser.OpGetFree,
+		parser.OpGetBuiltin:
+		return true
+	}
+	return false
+}
+
+// jumpTarget follows the unconditional jumps starting at pos and returns the
+// position of the first instruction that is not a jump.
+func jumpTarget(insts []byte, pos int) int {
+	for i := 0; i < len(insts) && pos < len(insts); i++ {
+		op, operands, _, _ := parser.ReadInstruction(insts[pos:])
+		if op != parser.OpJump || operands[0] == pos {
+			break
+		}
+		pos = operands[0]
+	}
+	return pos
+}
+
+// foldBinaryOp returns the result of the binary operation, or false if it
+// fails, e.g. by dividing by zero.
+func foldBinaryOp(lhs Object, op token.Token, rhs Object) (res Object, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, ok = nil, false
+		}
+	}()
+	res, err := lhs.BinaryOp(op, rhs)
+	return res, err == nil
 }
 
 func (c *Compiler) emit(
@@ -1337,6 +2363,15 @@ func (c *Compiler) getPathModule(moduleName string) (pathFile string, err error)
 	return "", fmt.Errorf("module '%s' not found at: %s", moduleName, pathFile)
 }
 
+// isPattern returns true if expr is a destructuring pattern of an assignment.
+func isPattern(expr parser.Expr) bool {
+	switch expr.(type) {
+	case *parser.ArrayLit, *parser.MapLit:
+		return true
+	}
+	return false
+}
+
 func resolveAssignLHS(
 	expr parser.Expr,
 ) (name string, selectors []parser.Expr) {
@@ -1358,10 +2393,9 @@ func iterateInstructions(
 	b []byte,
 	fn func(pos int, opcode parser.Opcode, operands []int) bool,
 ) {
-	for i := 0; i < len(b); i++ {
-		numOperands := parser.OpcodeOperands[b[i]]
-		operands, read := parser.ReadOperands(numOperands, b[i+1:])
-		if !fn(i, b[i], operands) {
+	for i := 0; i < len(b); {
+		op, operands, read, _ := parser.ReadInstruction(b[i:])
+		if !fn(i, op, operands) {
 			break
 		}
 		i += read
@@ -987,6 +987,38 @@ func() {
 				intObject(0),
 				intObject(1))))
 
+	expectCompile(t, `a := {}; a?.b ?? 1`,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpMap, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpJumpUndefined, 18),
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpIndex),
+				slim.MakeInstruction(parser.OpCoalesceJump, 26),
+				slim.MakeInstruction(parser.OpConstant, 1),
+				slim.MakeInstruction(parser.OpPop),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				stringObject("b"),
+				intObject(1))))
+
+	expectCompile(t, `try { a := 1 } catch e { b := e }`,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpTry, 17),
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpTryEnd),
+				slim.MakeInstruction(parser.OpJump, 26),
+				slim.MakeInstruction(parser.OpSetGlobal, 1),
+				slim.MakeInstruction(parser.OpGetGlobal, 1),
+				slim.MakeInstruction(parser.OpSetGlobal, 2),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1))))
+
 	// unknown module name
 	expectCompileError(t, `import("user1")`, "module 'user1' not found")
 
@@ -1025,8 +1057,10 @@ func TestCompilerErrorReport(t *testing.T) {
 		"Compile Error: unresolved reference 'a'\n\tat test:1:1")
 	expectCompileError(t, `a := a`,
 		"Compile Error: unresolved reference 'a'\n\tat test:1:6")
-	expectCompileError(t, `a, b := 1, 2`,
-		"Compile Error: tuple assignment not allowed\n\tat test:1:1")
+	expectCompileError(t, `a, b := 1, 2, 3`,
+		"Compile Error: assignment mismatch: 2 variables but 3 values\n\tat test:1:1")
+	expectCompileError(t, `a := 1; a, _ := 1, 2`,
+		"Compile Error: no new variables on left side of :=\n\tat test:1:9")
 	expectCompileError(t, `a.b := 1`,
 		"not allowed with selector")
 	expectCompileError(t, `a:=1; a:=3`,
@@ -1187,6 +1221,132 @@ func() {
 				slim.MakeInstruction(parser.OpReturn, 1)))))
 }
 
+func TestCompilerOptimization(t *testing.T) {
+	// constant expressions are folded
+	expectCompileOpts(t, `a := 60 * 60 * 24; b := "a" + "b" + 'c'`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpConstant, 1),
+				slim.MakeInstruction(parser.OpSetGlobal, 1),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(86400),
+				stringObject("abc"))))
+	expectCompileOpts(t, `a := !(1 < 2) || -3 == ^2; b := (1.5 + 1) * -2`,
+		true, bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpTrue),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 1),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				&slim.Float{Value: -5})))
+
+	// expressions that fail or use variables are evaluated at run time
+	expectCompileOpts(t, `a := 1 / 0; b := a + 2 * 3`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 14, 1),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpBinaryOpConst, 11, 2),
+				slim.MakeInstruction(parser.OpSetGlobal, 1),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(0),
+				intObject(6))))
+
+	// values pushed only to be popped are removed
+	expectCompileOpts(t, `a := 1; 2; a; "x"; func() { a; 3 }`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2),
+				stringObject("x"),
+				intObject(3),
+				compiledFunction(0, 0,
+					slim.MakeInstruction(parser.OpReturn, 0)))))
+
+	// jumps to jumps go to the final target
+	expectCompileOpts(t, `
+a := 1
+if a { if a { a = 2 } else { a = 3 } } else { a = 4 }`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpJumpFalsy, 44),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpJumpFalsy, 33),
+				slim.MakeInstruction(parser.OpConstant, 1),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpJump, 50),
+				slim.MakeInstruction(parser.OpConstant, 2),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpJump, 50),
+				slim.MakeInstruction(parser.OpConstant, 3),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2),
+				intObject(3),
+				intObject(4))))
+
+	// specialised instructions for the operations on locals and constants
+	expectCompileOpts(t, `
+func(a, i) { i++; i -= 2; i += 200; a[i] = a[i] < 10 }`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(200),
+				intObject(10),
+				compiledFunction(2, 2,
+					slim.MakeInstruction(parser.OpIncLocal, 1, 1),
+					slim.MakeInstruction(parser.OpIncLocal, 1, 0xFE),
+					slim.MakeInstruction(parser.OpGetLocal, 1),
+					slim.MakeInstruction(parser.OpConstant, 0),
+					slim.MakeInstruction(parser.OpBinaryOp, 11),
+					slim.MakeInstruction(parser.OpSetLocal, 1),
+					slim.MakeInstruction(parser.OpGetLocal, 0),
+					slim.MakeInstruction(parser.OpIndexLocal, 1),
+					slim.MakeInstruction(parser.OpBinaryOpConst, 38, 1),
+					slim.MakeInstruction(parser.OpGetLocal, 1),
+					slim.MakeInstruction(parser.OpSetSelLocal, 0, 1),
+					slim.MakeInstruction(parser.OpReturn, 0)))))
+
+	// selectors of names have their inline caches
+	expectCompileOpts(t, `a := {b: 1}; c := a.b; d := a?.b`, true,
+		bytecode(
+			concatInsts(
+				slim.MakeInstruction(parser.OpConstant, 0),
+				slim.MakeInstruction(parser.OpConstant, 1),
+				slim.MakeInstruction(parser.OpMap, 2),
+				slim.MakeInstruction(parser.OpSetGlobal, 0),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpSelector, 0, 0),
+				slim.MakeInstruction(parser.OpSetGlobal, 1),
+				slim.MakeInstruction(parser.OpGetGlobal, 0),
+				slim.MakeInstruction(parser.OpJumpUndefined, 36),
+				slim.MakeInstruction(parser.OpSelector, 0, 1),
+				slim.MakeInstruction(parser.OpSetGlobal, 2),
+				slim.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				stringObject("b"),
+				intObject(1))))
+}
+
 func TestCompilerScopes(t *testing.T) {
 	expectCompile(t, `
 if a := 1; a {
@@ -1360,7 +1520,16 @@ func expectCompile(
 	input string,
 	expected *slim.Bytecode,
 ) {
-	actual, trace, err := traceCompile(input, nil)
+	expectCompileOpts(t, input, false, expected)
+}
+
+func expectCompileOpts(
+	t *testing.T,
+	input string,
+	optimize bool,
+	expected *slim.Bytecode,
+) {
+	actual, trace, err := traceCompile(input, nil, optimize)
 
 	var ok bool
 	defer func() {
@@ -1377,7 +1546,7 @@ func expectCompile(
 }
 
 func expectCompileError(t *testing.T, input, expected string) {
-	_, trace, err := traceCompile(input, nil)
+	_, trace, err := traceCompile(input, nil, true)
 
 	var ok bool
 	defer func() {
@@ -1418,6 +1587,7 @@ func (o *compileTracer) Write(p []byte) (n int, err error) {
 func traceCompile(
 	input string,
 	symbols map[string]slim.Object,
+	optimize bool,
 ) (res *slim.Bytecode, trace []string, err error) {
 	fileSet := parser.NewFileSet()
 	file := fileSet.AddFile("test", -1, len(input))
@@ -1434,6 +1604,7 @@ func traceCompile(
 
 	tr := &compileTracer{}
 	c := slim.NewCompiler(file, symTable, nil, nil, tr)
+	c.EnableOptimization(optimize)
 	parsed, err := p.ParseFile()
 	if err != nil {
 		return
@@ -12,6 +12,12 @@ var (
 	// ErrObjectAllocLimit is an objects allocation limit error.
 	ErrObjectAllocLimit = errors.New("object allocation limit exceeded")
 
+	// ErrInstructionLimit is an instructions limit error.
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+	// ErrMemoryLimit is a memory limit error.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
 	// ErrIndexOutOfBounds is an error where a given index is out of the
 	// bounds.
 	ErrIndexOutOfBounds = errors.New("index out of bounds")
@@ -50,6 +56,26 @@ var (
 	// required method.
 	ErrNotImplemented = errors.New("not implemented")
 
+	// ErrNoRunningVM is an error where a compiled function is invoked without
+	// a running VM.
+	ErrNoRunningVM = errors.New("no running VM to invoke compiled function")
+
+	// ErrVMAborted is an error where a callback invoked by a function was
+	// interrupted because the VM has been aborted.
+	ErrVMAborted = errors.New("virtual machine aborted")
+
+	// ErrSuspended is returned when the execution of a VM is suspended by a
+	// function calling CallContext.Suspend.
+	ErrSuspended = errors.New("virtual machine suspended")
+
+	// ErrNotSuspended is an error where a VM that is not suspended is
+	// resumed.
+	ErrNotSuspended = errors.New("virtual machine not suspended")
+
+	// ErrCannotSuspend is an error where a function tries to suspend the VM
+	// while it's running a callback.
+	ErrCannotSuspend = errors.New("cannot suspend virtual machine in callback")
+
 	// ErrInvalidRangeStep is an error where the step parameter is less than or equal to 0 when using builtin range function.
 	ErrInvalidRangeStep = errors.New("range step must be greater than 0")
 )
@@ -65,3 +91,19 @@ func (e ErrInvalidArgumentType) Error() string {
 	return fmt.Sprintf("invalid type for argument '%s': expected %s, found %s",
 		e.Name, e.Expected, e.Found)
 }
+
+// DecodeError is an error where an object cannot be decoded into a Go value
+// by Decode.
+type DecodeError struct {
+	Path     string // path of the object, e.g. "items[3].price"
+	Expected string
+	Found    string
+}
+
+func (e DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("expected %s, found %s", e.Expected, e.Found)
+	}
+	return fmt.Sprintf("%s: expected %s, found %s",
+		e.Path, e.Expected, e.Found)
+}
@@ -6,19 +6,28 @@ import (
 	"github.com/snple/slim/parser"
 )
 
-// MakeInstruction returns a bytecode for an opcode and the operands.
+// MakeInstruction returns a bytecode for an opcode and the operands. If an
+// operand does not fit in the width of the opcode operands, the instruction is
+// prefixed by OpWide and its operands are encoded in the wider width.
 func MakeInstruction(opcode parser.Opcode, operands ...int) []byte {
 	numOperands := parser.OpcodeOperands[opcode]
+	if wide := parser.WideOperands(opcode); wide != nil &&
+		!fitOperands(numOperands, operands) {
+		return makeInstruction([]byte{parser.OpWide, opcode}, wide, operands)
+	}
+	return makeInstruction([]byte{opcode}, numOperands, operands)
+}
 
-	totalLen := 1
+func makeInstruction(prefix []byte, numOperands []int, operands []int) []byte {
+	totalLen := len(prefix)
 	for _, w := range numOperands {
 		totalLen += w
 	}
 
 	instruction := make([]byte, totalLen)
-	instruction[0] = opcode
+	copy(instruction, prefix)
 
-	offset := 1
+	offset := len(prefix)
 	for i, o := range operands {
 		width := numOperands[i]
 		switch width {
@@ -40,28 +49,42 @@ func MakeInstruction(opcode parser.Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// fitOperands returns true if the operands fit in their widths.
+func fitOperands(numOperands []int, operands []int) bool {
+	for i, o := range operands {
+		if i < len(numOperands) && numOperands[i] < 4 &&
+			o >= 1<<(8*numOperands[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // FormatInstructions returns string representation of bytecode instructions.
+// The instructions prefixed by OpWide are suffixed by ".W".
 func FormatInstructions(b []byte, posOffset int) []string {
 	var out []string
 
 	i := 0
 	for i < len(b) {
-		numOperands := parser.OpcodeOperands[b[i]]
-		operands, read := parser.ReadOperands(numOperands, b[i+1:])
+		op, operands, read, wide := parser.ReadInstruction(b[i:])
+		name := parser.OpcodeNames[op]
+		if wide {
+			name += ".W"
+		}
 
-		switch len(numOperands) {
+		switch len(operands) {
 		case 0:
 			out = append(out, fmt.Sprintf("%04d %-7s",
-				posOffset+i, parser.OpcodeNames[b[i]]))
+				posOffset+i, name))
 		case 1:
 			out = append(out, fmt.Sprintf("%04d %-7s %-5d",
-				posOffset+i, parser.OpcodeNames[b[i]], operands[0]))
+				posOffset+i, name, operands[0]))
 		case 2:
 			out = append(out, fmt.Sprintf("%04d %-7s %-5d %-5d",
-				posOffset+i, parser.OpcodeNames[b[i]],
-				operands[0], operands[1]))
+				posOffset+i, name, operands[0], operands[1]))
 		}
-		i += 1 + read
+		i += read
 	}
 	return out
 }
@@ -107,6 +107,86 @@ func (i *BytesIterator) Value() Object {
 	return &Int{Value: int64(i.v[i.i-1])}
 }
 
+// Generator is returned by calling a generator function, i.e. a function
+// containing a yield statement. It's an iterator that runs the function
+// lazily: each iteration resumes the function until it yields the next value
+// or returns. The saved frame can only be resumed by a VM, so calling Next
+// outside of a for-in loop always returns false.
+type Generator struct {
+	ObjectImpl
+	fn       *CompiledFunction
+	stack    []Object  // locals and operands of the suspended frame
+	handlers []handler // error handlers of the suspended frame
+	ip       int
+	running  bool
+	done     bool
+	i        int
+	value    Object
+}
+
+func newGenerator(fn *CompiledFunction, args []Object) *Generator {
+	stack := make([]Object, fn.NumLocals)
+	copy(stack, args)
+	return &Generator{fn: fn, stack: stack, ip: -1}
+}
+
+// TypeName returns the name of the type.
+func (i *Generator) TypeName() string {
+	return "generator"
+}
+
+func (i *Generator) String() string {
+	return "<generator>"
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *Generator) Equals(x Object) bool {
+	return i == x
+}
+
+// Copy returns the generator itself as its state cannot be duplicated.
+func (i *Generator) Copy() Object {
+	return i
+}
+
+// Iterate returns the generator itself.
+func (i *Generator) Iterate() Iterator {
+	return i
+}
+
+// CanIterate returns true.
+func (i *Generator) CanIterate() bool {
+	return true
+}
+
+// Next returns false. The VM resumes generators iterated by for-in loops
+// instead of calling Next.
+func (i *Generator) Next() bool {
+	return false
+}
+
+// Key returns the index of the current value.
+func (i *Generator) Key() Object {
+	return &Int{Value: int64(i.i - 1)}
+}
+
+// Value returns the last yielded value.
+func (i *Generator) Value() Object {
+	if i.value == nil {
+		return UndefinedValue
+	}
+	return i.value
+}
+
+// close ends the generator; it cannot be resumed anymore.
+func (i *Generator) close() {
+	i.running = false
+	i.done = true
+	i.stack = nil
+	i.handlers = nil
+}
+
 // MapIterator represents an iterator for the map.
 type MapIterator struct {
 	ObjectImpl
@@ -79,6 +79,15 @@ type Object interface {
 	CanCall() bool
 }
 
+// ContextCallable is implemented by callable objects that need access to the
+// running VM. When called by the VM, CallWithContext is used instead of Call.
+type ContextCallable interface {
+	// CallWithContext should take a CallContext and an arbitrary number of
+	// arguments and returns a return value and/or an error, which the VM will
+	// consider as a run-time error.
+	CallWithContext(ctx *CallContext, args ...Object) (ret Object, err error)
+}
+
 // ObjectImpl represents a default Object Implementation. To defined a new
 // value type, one can embed ObjectImpl in their type declarations to avoid
 // implementing all non-significant methods. TypeName() and String() methods
@@ -320,8 +329,9 @@ func (o *Bool) GobEncode() (b []byte, err error) {
 // BuiltinFunction represents a builtin function.
 type BuiltinFunction struct {
 	ObjectImpl
-	Name  string
-	Value CallableFunc
+	Name     string
+	Value    CallableFunc
+	ctxValue CallableContextFunc // called instead of Value by VM, if set
 }
 
 // TypeName returns the name of the type.
@@ -335,7 +345,7 @@ func (o *BuiltinFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *BuiltinFunction) Copy() Object {
-	return &BuiltinFunction{Value: o.Value}
+	return &BuiltinFunction{Value: o.Value, ctxValue: o.ctxValue}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -349,6 +359,17 @@ func (o *BuiltinFunction) Call(args ...Object) (Object, error) {
 	return o.Value(args...)
 }
 
+// CallWithContext executes a builtin function with the CallContext of the VM.
+func (o *BuiltinFunction) CallWithContext(
+	ctx *CallContext,
+	args ...Object,
+) (Object, error) {
+	if o.ctxValue != nil {
+		return o.ctxValue(ctx, args...)
+	}
+	return o.Value(args...)
+}
+
 // CanCall returns whether the Object can be Called.
 func (o *BuiltinFunction) CanCall() bool {
 	return true
@@ -371,7 +392,7 @@ func (m *BuiltinModule) AsImmutableMap(moduleName string) *ImmutableMap {
 		attrs[k] = v.Copy()
 	}
 	attrs["__module_name__"] = &String{Value: moduleName}
-	return &ImmutableMap{Value: attrs}
+	return &ImmutableMap{Value: attrs, module: true}
 }
 
 // Bytes represents a byte array.
@@ -574,6 +595,7 @@ type CompiledFunction struct {
 	NumLocals     int // number of local variables (including function parameters)
 	NumParameters int
 	VarArgs       bool
+	Generator     bool // calling the function returns a Generator
 	SourceMap     map[int]parser.Pos
 	Free          []*ObjectPtr
 }
@@ -594,6 +616,7 @@ func (o *CompiledFunction) Copy() Object {
 		NumLocals:     o.NumLocals,
 		NumParameters: o.NumParameters,
 		VarArgs:       o.VarArgs,
+		Generator:     o.Generator,
 		Free:          append([]*ObjectPtr{}, o.Free...), // DO NOT Copy() of elements; these are variable pointers
 	}
 }
@@ -620,6 +643,53 @@ func (o *CompiledFunction) CanCall() bool {
 	return true
 }
 
+// ContextFunction represents a user function that receives the CallContext of
+// the VM calling it, so that it can invoke script callbacks.
+type ContextFunction struct {
+	ObjectImpl
+	Name  string
+	Value CallableContextFunc
+}
+
+// TypeName returns the name of the type.
+func (o *ContextFunction) TypeName() string {
+	return "user-function:" + o.Name
+}
+
+func (o *ContextFunction) String() string {
+	return "<user-function>"
+}
+
+// Copy returns a copy of the type.
+func (o *ContextFunction) Copy() Object {
+	return &ContextFunction{Value: o.Value, Name: o.Name}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *ContextFunction) Equals(_ Object) bool {
+	return false
+}
+
+// Call invokes the function outside of a VM. Compiled functions passed as
+// callbacks cannot be invoked in this case.
+func (o *ContextFunction) Call(args ...Object) (Object, error) {
+	return o.Value(&CallContext{}, args...)
+}
+
+// CallWithContext invokes the function with the CallContext of the VM.
+func (o *ContextFunction) CallWithContext(
+	ctx *CallContext,
+	args ...Object,
+) (Object, error) {
+	return o.Value(ctx, args...)
+}
+
+// CanCall returns whether the Object can be Called.
+func (o *ContextFunction) CanCall() bool {
+	return true
+}
+
 // Error represents an error value.
 type Error struct {
 	ObjectImpl
@@ -901,7 +971,8 @@ func (o *ImmutableArray) CanIterate() bool {
 // ImmutableMap represents an immutable map object.
 type ImmutableMap struct {
 	ObjectImpl
-	Value map[string]Object
+	Value  map[string]Object
+	module bool // builtin module, whose members never change
 }
 
 // TypeName returns the name of the type.
@@ -1,6 +1,8 @@
 package slim_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/snple/slim"
@@ -734,3 +736,163 @@ func boolValue(b bool) slim.Object {
 	}
 	return slim.FalseValue
 }
+
+type proxyItem struct {
+	Name  string  `slim:"name"`
+	Price float64 `json:"price"`
+	Qty   int
+	note  string
+}
+
+func (i *proxyItem) Total(discount ...float64) float64 {
+	total := i.Price * float64(i.Qty)
+	for _, d := range discount {
+		total -= d
+	}
+	return total
+}
+
+func (i *proxyItem) SetNote(note string) error {
+	if note == "" {
+		return errors.New("empty note")
+	}
+	i.note = note
+	return nil
+}
+
+func (i proxyItem) Split() (string, int) {
+	return i.Name, i.Qty
+}
+
+type proxyAddr struct {
+	City string
+	Zip  string
+}
+
+type proxyOrder struct {
+	Item proxyItem
+	Ship *proxyAddr
+	Bill *proxyAddr
+	Next *proxyOrder
+}
+
+func TestStructProxy(t *testing.T) {
+	item := &proxyItem{Name: "a", Price: 1.5, Qty: 2}
+	o, err := slim.WrapStruct(item)
+	require.NoError(t, err)
+	require.Equal(t, "struct:proxyItem", o.TypeName())
+
+	script := slim.NewScript([]byte(`
+name := item.name
+item.price = 2
+item["Qty"] += 1
+total := item.Total()
+discounted := item.Total(1, 0.5)
+split := item.Split()
+err := item.SetNote("")
+item.SetNote("n")
+missing := item.missing
+keys := []
+for k, _ in item { keys = append(keys, k) }
+`))
+	require.NoError(t, script.Add("item", o))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "a", c.Get("name").String())
+	require.Equal(t, 6.0, c.Get("total").Float())
+	require.Equal(t, 4.5, c.Get("discounted").Float())
+	require.Equal(t, "[\"a\", 3]", c.Get("split").String())
+	require.Equal(t, "error: \"empty note\"", c.Get("err").String())
+	require.True(t, c.Get("missing").IsUndefined())
+	require.Equal(t, 3, len(c.Get("keys").Array()))
+	require.True(t, *item == proxyItem{Name: "a", Price: 2, Qty: 3, note: "n"})
+
+	// invalid values and arguments
+	script = slim.NewScript([]byte(`item.price = "x"`))
+	require.NoError(t, script.Add("item", o))
+	_, err = script.Run()
+	require.True(t, strings.Contains(err.Error(),
+		"price: expected float, found string"), err.Error())
+	script = slim.NewScript([]byte(`item.Total("x")`))
+	require.NoError(t, script.Add("item", o))
+	_, err = script.Run()
+	require.True(t, strings.Contains(err.Error(),
+		"invalid type for argument '1' in call to 'user-function:Total': "+
+			"expected float, found string"),
+		err.Error())
+
+	// allowlist of the exposed members
+	o, err = slim.WrapStruct(item, "name", "Total")
+	require.NoError(t, err)
+	script = slim.NewScript([]byte(`
+out := [item.name, item.price, item.SetNote, item.Total()]
+item.price = 1`))
+	require.NoError(t, script.Add("item", o))
+	_, err = script.Run()
+	require.True(t, strings.Contains(err.Error(),
+		"not index-assignable: struct:proxyItem"), err.Error())
+	script = slim.NewScript([]byte(`
+out := [item.name, item.price, item.SetNote, item.Total()]`))
+	require.NoError(t, script.Add("item", o))
+	c, err = script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "[\"a\", <undefined>, <undefined>, 6]",
+		c.Get("out").String())
+	require.Equal(t, "{name: \"a\"}", o.String())
+
+	_, err = slim.WrapStruct(item, "note")
+	require.Error(t, err)
+	_, err = slim.WrapStruct(*item)
+	require.Error(t, err)
+
+	// nested structs
+	order := &proxyOrder{Ship: &proxyAddr{City: "A"}}
+	o, err = slim.WrapStruct(order)
+	require.NoError(t, err)
+	script = slim.NewScript([]byte(`
+order.Item.name = "b"
+order.Item.Qty += 2
+order.Ship.City = "Z"
+total := order.Item.Total()
+bill := order.Bill`))
+	require.NoError(t, script.Add("order", o))
+	c, err = script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "b", order.Item.Name)
+	require.Equal(t, 2, order.Item.Qty)
+	require.Equal(t, "Z", order.Ship.City)
+	require.Equal(t, 0.0, c.Get("total").Float())
+	require.True(t, c.Get("bill").IsUndefined())
+
+	o, err = slim.WrapStruct(order, "Ship.City", "Item")
+	require.NoError(t, err)
+	script = slim.NewScript([]byte(`
+order.Item.price = 1
+order.Ship.City = "Y"
+out := [order.Ship.Zip, order.Bill]`))
+	require.NoError(t, script.Add("order", o))
+	c, err = script.Run()
+	require.NoError(t, err)
+	require.Equal(t, 1.0, order.Item.Price)
+	require.Equal(t, "Y", order.Ship.City)
+	require.Equal(t, "[<undefined>, <undefined>]", c.Get("out").String())
+	script = slim.NewScript([]byte(`order.Ship.Zip = "1"`))
+	require.NoError(t, script.Add("order", o))
+	_, err = script.Run()
+	require.True(t, strings.Contains(err.Error(),
+		"not index-assignable: struct:proxyAddr"), err.Error())
+	script = slim.NewScript([]byte(`order.Ship = {City: "X"}`))
+	require.NoError(t, script.Add("order", o))
+	_, err = script.Run()
+	require.Error(t, err)
+
+	order.Next = order
+	o, err = slim.WrapStruct(order, "Next")
+	require.NoError(t, err)
+	require.Equal(t, "{Next: {...}}", o.String())
+
+	_, err = slim.WrapStruct(order, "Ship.Street")
+	require.Error(t, err)
+	_, err = slim.WrapStruct(order, "Item.name.x")
+	require.Error(t, err)
+}
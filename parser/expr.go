@@ -116,6 +116,7 @@ type CallExpr struct {
 	Args     []Expr
 	Ellipsis Pos
 	RParen   Pos
+	Optional bool // f?.(args)
 }
 
 func (e *CallExpr) exprNode() {}
@@ -138,7 +139,34 @@ func (e *CallExpr) String() string {
 	if len(args) > 0 && e.Ellipsis.IsValid() {
 		args[len(args)-1] = args[len(args)-1] + "..."
 	}
-	return e.Func.String() + "(" + strings.Join(args, ", ") + ")"
+	var optional string
+	if e.Optional {
+		optional = "?."
+	}
+	return e.Func.String() + optional + "(" + strings.Join(args, ", ") + ")"
+}
+
+// ChainExpr represents an expression containing optional chaining, e.g.
+// a?.b.c. If an optional link finds undefined, evaluation of the whole chain
+// stops and the result is undefined.
+type ChainExpr struct {
+	Expr Expr
+}
+
+func (e *ChainExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ChainExpr) Pos() Pos {
+	return e.Expr.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ChainExpr) End() Pos {
+	return e.Expr.End()
+}
+
+func (e *ChainExpr) String() string {
+	return e.Expr.String()
 }
 
 // CharLit represents a character literal.
@@ -356,10 +384,11 @@ func (e *ImportExpr) String() string {
 
 // IndexExpr represents an index expression.
 type IndexExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Index  Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Index    Expr
+	RBrack   Pos
+	Optional bool // x?.[index]
 }
 
 func (e *IndexExpr) exprNode() {}
@@ -379,7 +408,11 @@ func (e *IndexExpr) String() string {
 	if e.Index != nil {
 		index = e.Index.String()
 	}
-	return e.Expr.String() + "[" + index + "]"
+	var optional string
+	if e.Optional {
+		optional = "?."
+	}
+	return e.Expr.String() + optional + "[" + index + "]"
 }
 
 // IntLit represents an integer literal.
@@ -405,6 +438,30 @@ func (e *IntLit) String() string {
 	return e.Literal
 }
 
+// InterpolatedStringLit represents a string literal with embedded
+// expressions, e.g. "hello ${name}".
+type InterpolatedStringLit struct {
+	Parts    []Expr // *StringLit for text; embedded expressions otherwise
+	ValuePos Pos
+	Literal  string
+}
+
+func (e *InterpolatedStringLit) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *InterpolatedStringLit) Pos() Pos {
+	return e.ValuePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *InterpolatedStringLit) End() Pos {
+	return Pos(int(e.ValuePos) + len(e.Literal))
+}
+
+func (e *InterpolatedStringLit) String() string {
+	return e.Literal
+}
+
 // MapElementLit represents a map element.
 type MapElementLit struct {
 	Key      string
@@ -479,10 +536,33 @@ func (e *ParenExpr) String() string {
 	return "(" + e.Expr.String() + ")"
 }
 
+// PropagateExpr represents an error propagation expression.
+type PropagateExpr struct {
+	Expr        Expr
+	QuestionPos Pos
+}
+
+func (e *PropagateExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *PropagateExpr) Pos() Pos {
+	return e.Expr.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *PropagateExpr) End() Pos {
+	return e.QuestionPos + 1
+}
+
+func (e *PropagateExpr) String() string {
+	return e.Expr.String() + "?"
+}
+
 // SelectorExpr represents a selector expression.
 type SelectorExpr struct {
-	Expr Expr
-	Sel  Expr
+	Expr     Expr
+	Sel      Expr
+	Optional bool // x?.sel
 }
 
 func (e *SelectorExpr) exprNode() {}
@@ -498,16 +578,20 @@ func (e *SelectorExpr) End() Pos {
 }
 
 func (e *SelectorExpr) String() string {
+	if e.Optional {
+		return e.Expr.String() + "?." + e.Sel.String()
+	}
 	return e.Expr.String() + "." + e.Sel.String()
 }
 
 // SliceExpr represents a slice expression.
 type SliceExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Low    Expr
-	High   Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Low      Expr
+	High     Expr
+	RBrack   Pos
+	Optional bool // x?.[low:high]
 }
 
 func (e *SliceExpr) exprNode() {}
@@ -530,7 +614,11 @@ func (e *SliceExpr) String() string {
 	if e.High != nil {
 		high = e.High.String()
 	}
-	return e.Expr.String() + "[" + low + ":" + high + "]"
+	var optional string
+	if e.Optional {
+		optional = "?."
+	}
+	return e.Expr.String() + optional + "[" + low + ":" + high + "]"
 }
 
 // StringLit represents a string literal.
@@ -556,6 +644,29 @@ func (e *StringLit) String() string {
 	return e.Literal
 }
 
+// TypeGuardExpr represents the tag expression x.(type) of a type switch.
+type TypeGuardExpr struct {
+	Expr   Expr
+	LParen Pos
+	RParen Pos
+}
+
+func (e *TypeGuardExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *TypeGuardExpr) Pos() Pos {
+	return e.Expr.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *TypeGuardExpr) End() Pos {
+	return e.RParen + 1
+}
+
+func (e *TypeGuardExpr) String() string {
+	return e.Expr.String() + ".(type)"
+}
+
 // UnaryExpr represents an unary operator expression.
 type UnaryExpr struct {
 	Expr     Expr
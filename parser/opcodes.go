@@ -47,6 +47,19 @@ const (
 	OpIteratorValue               // Iterator value
 	OpBinaryOp                    // Binary operation
 	OpSuspend                     // Suspend VM
+	OpTry                         // Push error handler
+	OpTryEnd                      // Pop error handler
+	OpJumpNotError                // Jump if not error
+	OpSwitch                      // Jump using switch table
+	OpUnpack                      // Unpack values by indexes
+	OpCoalesceJump                // Nullish coalescing jump
+	OpJumpUndefined               // Jump if undefined
+	OpYield                       // Yield from generator
+	OpWide                        // Widen the operands of the next opcode
+	OpBinaryOpConst               // Binary operation with a constant operand
+	OpIndexLocal                  // Index operation by a local variable
+	OpIncLocal                    // Increment local variable
+	OpSelector                    // Select a member by a constant name
 )
 
 // OpcodeNames are string representation of opcodes.
@@ -93,6 +106,19 @@ var OpcodeNames = [...]string{
 	OpIteratorValue: "ITVAL",
 	OpBinaryOp:      "BINARYOP",
 	OpSuspend:       "SUSPEND",
+	OpTry:           "TRY",
+	OpTryEnd:        "TRYEND",
+	OpJumpNotError:  "JMPNERR",
+	OpSwitch:        "SWITCH",
+	OpUnpack:        "UNPACK",
+	OpCoalesceJump:  "COALJMP",
+	OpJumpUndefined: "JMPUNDEF",
+	OpYield:         "YIELD",
+	OpWide:          "WIDE",
+	OpBinaryOpConst: "BINARYOPC",
+	OpIndexLocal:    "INDEXL",
+	OpIncLocal:      "INCL",
+	OpSelector:      "SELECT",
 }
 
 // OpcodeOperands is the number of operands.
@@ -139,6 +165,66 @@ var OpcodeOperands = [...][]int{
 	OpIteratorValue: {},
 	OpBinaryOp:      {1},
 	OpSuspend:       {},
+	OpTry:           {4},
+	OpTryEnd:        {},
+	OpJumpNotError:  {4},
+	OpSwitch:        {2},
+	OpUnpack:        {1},
+	OpCoalesceJump:  {4},
+	OpJumpUndefined: {4},
+	OpYield:         {},
+	OpWide:          {},
+	OpBinaryOpConst: {1, 2},
+	OpIndexLocal:    {1},
+	OpIncLocal:      {1, 1},
+	OpSelector:      {2, 2},
+}
+
+// wideOperands is the number of operands of the opcodes prefixed by OpWide.
+var wideOperands = map[Opcode][]int{
+	OpConstant:     {4},
+	OpGetGlobal:    {4},
+	OpSetGlobal:    {4},
+	OpSetSelGlobal: {4, 2},
+	OpGetLocal:     {2},
+	OpSetLocal:     {2},
+	OpDefineLocal:  {2},
+	OpSetSelLocal:  {2, 2},
+	OpClosure:      {4, 2},
+	OpGetFreePtr:   {2},
+	OpGetFree:      {2},
+	OpSetFree:      {2},
+	OpGetLocalPtr:  {2},
+	OpSetSelFree:   {2, 2},
+	OpSwitch:       {4},
+}
+
+// WideOperands returns the number of operands of the opcode when it's
+// prefixed by OpWide, or nil if the opcode has no wide variant. The operands
+// of a wide variant are twice as wide as the operands of the opcode.
+func WideOperands(op Opcode) []int {
+	return wideOperands[op]
+}
+
+// ReadInstruction reads the opcode and the operands of the instruction at the
+// start of the bytecode. If the instruction is prefixed by OpWide, the opcode
+// following the prefix is returned with its wide operands, and wide is true.
+// read is the length of the instruction, including the prefix.
+func ReadInstruction(ins []byte) (
+	op Opcode,
+	operands []int,
+	read int,
+	wide bool,
+) {
+	op = ins[0]
+	numOperands := OpcodeOperands[op]
+	if op == OpWide {
+		op = ins[1]
+		numOperands = WideOperands(op)
+		read, wide = 1, true
+	}
+	operands, offset := ReadOperands(numOperands, ins[read+1:])
+	return op, operands, read + 1 + offset, wide
 }
 
 // ReadOperands reads operands from the bytecode.
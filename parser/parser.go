@@ -5,6 +5,7 @@ import (
 	"io"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/snple/slim/token"
 )
@@ -18,6 +19,9 @@ var stmtStart = map[token.Token]bool{
 	token.If:       true,
 	token.Return:   true,
 	token.Export:   true,
+	token.Try:      true,
+	token.Switch:   true,
+	token.Yield:    true,
 }
 
 // Error represents a parser error.
@@ -99,8 +103,11 @@ type Parser struct {
 	token     token.Token
 	tokenLit  string
 	exprLevel int // < 0: in control clause, >= 0: in expression
-	syncPos   Pos // last sync position
-	syncCount int // number of advance calls without progress
+	condColon Pos // ':' of the enclosing conditional's true branch
+	colons    map[colonKey]colonScan
+	brackets  map[Pos]Scanner // scanners after the matching brackets
+	syncPos   Pos             // last sync position
+	syncCount int             // number of advance calls without progress
 	trace     bool
 	indent    int
 	traceOut  io.Writer
@@ -196,8 +203,17 @@ func (p *Parser) parseBinaryExpr(prec1 int) Expr {
 }
 
 func (p *Parser) parseCondExpr(cond Expr) Expr {
+	s := *p.scanner
+	s.errorHandler = nil
+	condColon := p.condColon
+	p.condColon = p.findColon(&s)
+
 	questionPos := p.expect(token.Question)
+	if p.token == token.Semicolon && p.tokenLit == "\n" {
+		p.next() // the conditional continues on the next line
+	}
 	trueExpr := p.parseExpr()
+	p.condColon = condColon
 	colonPos := p.expect(token.Colon)
 	falseExpr := p.parseExpr()
 
@@ -235,16 +251,46 @@ func (p *Parser) parsePrimaryExpr() Expr {
 	}
 
 	x := p.parseOperand()
+	var chain bool
 
 L:
 	for {
 		switch p.token {
+		case token.QuestionDot:
+			p.next()
+
+			switch p.token {
+			case token.Ident:
+				x = p.parseSelector(x)
+			case token.LBrack:
+				x = p.parseIndexOrSlice(x)
+			case token.LParen:
+				x = p.parseCall(x)
+			default:
+				pos := p.pos
+				p.errorExpected(pos, "selector, index or call")
+				p.advance(stmtStart)
+				return &BadExpr{From: pos, To: p.pos}
+			}
+			switch x := x.(type) {
+			case *SelectorExpr:
+				x.Optional = true
+			case *IndexExpr:
+				x.Optional = true
+			case *SliceExpr:
+				x.Optional = true
+			case *CallExpr:
+				x.Optional = true
+			}
+			chain = true
 		case token.Period:
 			p.next()
 
 			switch p.token {
 			case token.Ident:
 				x = p.parseSelector(x)
+			case token.LParen:
+				x = p.parseTypeGuard(x)
 			default:
 				pos := p.pos
 				p.errorExpected(pos, "selector")
@@ -255,13 +301,195 @@ L:
 			x = p.parseIndexOrSlice(x)
 		case token.LParen:
 			x = p.parseCall(x)
+		case token.Question:
+			if !p.isPropagation() {
+				break L
+			}
+			x = &PropagateExpr{Expr: x, QuestionPos: p.pos}
+			p.next()
 		default:
 			break L
 		}
 	}
+	if chain {
+		return &ChainExpr{Expr: x}
+	}
 	return x
 }
 
+// isPropagation reports whether the current '?' token is a postfix error
+// propagation rather than the start of a conditional expression. If the
+// next token cannot start an expression, it is; if it can only start one,
+// it isn't. Otherwise, e.g. for '-' or '(' or a line break, '?' starts a
+// conditional only if a matching ':' follows within the enclosing one.
+func (p *Parser) isPropagation() bool {
+	s := *p.scanner
+	s.errorHandler = nil
+	next := s
+	tok, lit, _ := next.Scan()
+	switch p.questionFollower(tok, lit) {
+	case followOperand:
+		return false
+	case followOperator:
+		return true
+	}
+
+	colon := p.findColon(&s)
+	if !colon.IsValid() {
+		return true
+	}
+	return p.condColon.IsValid() && colon >= p.condColon
+}
+
+// kinds of tokens following a '?'
+const (
+	followOperator = iota // '?' propagates
+	followOperand         // '?' starts a conditional
+	followEither          // ambiguous
+)
+
+func (p *Parser) questionFollower(tok token.Token, lit string) int {
+	switch tok {
+	case token.Ident, token.Int, token.Float, token.Char, token.String,
+		token.True, token.False, token.Undefined, token.Import,
+		token.Func, token.Error, token.Immutable, token.Not:
+		return followOperand
+	case token.LBrace:
+		if p.exprLevel < 0 {
+			return followOperator // block of the control clause
+		}
+		return followOperand
+	case token.Add, token.Sub, token.Xor, token.LParen, token.LBrack:
+		return followEither
+	case token.Semicolon:
+		if lit == "\n" {
+			return followEither
+		}
+	}
+	return followOperator
+}
+
+// findColon scans ahead from just after a '?' for the ':' of the
+// conditional expression it would start, skipping nested conditionals and
+// brackets. It returns NoPos if the expression ends first, leaving s just
+// after the ':' otherwise. The results are memoized by position, so that
+// the scans of nested conditionals are done once.
+func (p *Parser) findColon(s *Scanner) Pos {
+	key := colonKey{s.offset, s.insertSemi, p.exprLevel < 0}
+	if r, ok := p.colons[key]; ok {
+		*s = r.next
+		return r.colon
+	}
+	colon := p.scanColon(s)
+	if p.colons == nil {
+		p.colons = make(map[colonKey]colonScan)
+	}
+	p.colons[key] = colonScan{colon: colon, next: *s}
+	return colon
+}
+
+// colonKey is the position findColon starts from.
+type colonKey struct {
+	offset     int
+	insertSemi bool
+	block      bool // '{' starts the block of a control clause
+}
+
+// colonScan is the result of findColon.
+type colonScan struct {
+	colon Pos
+	next  Scanner
+}
+
+// scanColon is findColon without the memoization. The brackets are skipped
+// by their matching closing brackets found by the previous scans.
+func (p *Parser) scanColon(s *Scanner) Pos {
+	var opens []Pos
+	tok, lit, pos := s.Scan()
+	if tok == token.Semicolon && lit == "\n" {
+		tok, _, pos = s.Scan()
+	}
+	for ; ; tok, _, pos = s.Scan() {
+		switch tok {
+		case token.EOF:
+			return NoPos
+		case token.LBrace, token.LParen, token.LBrack:
+			if tok == token.LBrace && len(opens) == 0 && p.exprLevel < 0 {
+				return NoPos
+			}
+			if next, ok := p.brackets[pos]; ok {
+				*s = next
+				continue
+			}
+			opens = append(opens, pos)
+		case token.RParen, token.RBrack, token.RBrace:
+			if len(opens) == 0 {
+				return NoPos
+			}
+			if p.brackets == nil {
+				p.brackets = make(map[Pos]Scanner)
+			}
+			p.brackets[opens[len(opens)-1]] = *s
+			opens = opens[:len(opens)-1]
+		}
+		if len(opens) > 0 {
+			continue
+		}
+
+		switch {
+		case tok == token.Colon:
+			return pos
+		case tok == token.Comma, tok == token.Semicolon,
+			tok == token.Assign, tok == token.Define,
+			tok >= token.AddAssign && tok <= token.AndNotAssign,
+			tok.IsKeyword() && p.questionFollower(tok, "") != followOperand:
+			return NoPos
+		case tok == token.Question:
+			next := *s
+			ntok, nlit, _ := next.Scan()
+			switch p.questionFollower(ntok, nlit) {
+			case followOperand:
+				// a nested conditional, after which ours continues
+				if !p.findColon(s).IsValid() {
+					return NoPos
+				}
+				return p.findColon(s)
+			case followEither:
+				// a nested conditional if both its ':' and ours follow.
+				// Otherwise a propagation, after which the scan is the
+				// one of the nested conditional, unless a line ends.
+				inner := p.findColon(s)
+				if !inner.IsValid() {
+					return NoPos
+				}
+				t := *s
+				if colon := p.findColon(&t); colon.IsValid() {
+					*s = t
+					return colon
+				}
+				if ntok == token.Semicolon {
+					return NoPos
+				}
+				return inner
+			}
+		}
+	}
+}
+
+func (p *Parser) parseTypeGuard(x Expr) Expr {
+	if p.trace {
+		defer untracep(tracep(p, "TypeGuard"))
+	}
+
+	lparen := p.expect(token.LParen)
+	if p.token != token.Ident || p.tokenLit != "type" {
+		p.errorExpected(p.pos, "type")
+	}
+	p.next()
+	rparen := p.expect(token.RParen)
+	return &TypeGuardExpr{Expr: x, LParen: lparen, RParen: rparen}
+}
+
 func (p *Parser) parseCall(x Expr) *CallExpr {
 	if p.trace {
 		defer untracep(tracep(p, "Call"))
@@ -407,14 +635,7 @@ func (p *Parser) parseOperand() Expr {
 	case token.Char:
 		return p.parseCharLit()
 	case token.String:
-		v, _ := strconv.Unquote(p.tokenLit)
-		x := &StringLit{
-			Value:    v,
-			ValuePos: p.pos,
-			Literal:  p.tokenLit,
-		}
-		p.next()
-		return x
+		return p.parseStringLit()
 	case token.True:
 		x := &BoolLit{
 			Value:    true,
@@ -479,7 +700,10 @@ func (p *Parser) parseImportExpr() Expr {
 	}
 
 	// module name
-	moduleName, _ := strconv.Unquote(p.tokenLit)
+	if hasInterpolation(p.tokenLit) {
+		p.error(p.pos, "string interpolation not allowed in module name")
+	}
+	moduleName := unquoteString(p.tokenLit)
 	expr := &ImportExpr{
 		ModuleName: moduleName,
 		Token:      token.Import,
@@ -491,6 +715,95 @@ func (p *Parser) parseImportExpr() Expr {
 	return expr
 }
 
+func (p *Parser) parseStringLit() Expr {
+	lit, pos := p.tokenLit, p.pos
+	if !hasInterpolation(lit) {
+		x := &StringLit{
+			Value:    unquoteString(lit),
+			ValuePos: pos,
+			Literal:  lit,
+		}
+		p.next()
+		return x
+	}
+
+	if p.trace {
+		defer untracep(tracep(p, "InterpolatedStringLit"))
+	}
+
+	x := &InterpolatedStringLit{ValuePos: pos, Literal: lit}
+	text := func(from, to int) {
+		if from < to {
+			x.Parts = append(x.Parts, &StringLit{
+				Value:    unquoteString(`"` + lit[from:to] + `"`),
+				ValuePos: pos + Pos(from),
+				Literal:  `"` + lit[from:to] + `"`,
+			})
+		}
+	}
+
+	end := len(lit)
+	if end > 1 && lit[end-1] == '"' {
+		end--
+	}
+	start := 1
+	for i := 1; i < end; {
+		switch {
+		case lit[i] == '\\':
+			i += 2
+		case lit[i] == '$' && i+1 < end && lit[i+1] == '{':
+			text(start, i)
+			expr, next := p.parseInterpolation(pos+Pos(i+2), pos+Pos(end))
+			x.Parts = append(x.Parts, expr)
+			i = int(next - pos)
+			start = i
+		default:
+			i++
+		}
+	}
+	text(start, end)
+
+	p.next()
+	return x
+}
+
+// parseInterpolation parses the expression embedded in a string literal
+// starting at pos. It returns the expression and the position following its
+// closing brace, or end if the brace is missing.
+func (p *Parser) parseInterpolation(pos, end Pos) (Expr, Pos) {
+	if p.trace {
+		defer untracep(tracep(p, "Interpolation"))
+	}
+
+	scanner, tok, lit, tokPos := p.scanner, p.token, p.tokenLit, p.pos
+	exprLevel := p.exprLevel
+	defer func() {
+		p.scanner, p.token, p.tokenLit, p.pos = scanner, tok, lit, tokPos
+		p.exprLevel = exprLevel
+	}()
+
+	// the errors of the embedded tokens are already reported while
+	// scanning the string literal.
+	s := *scanner
+	s.errorHandler = nil
+	s.seek(p.file.Offset(pos))
+	p.scanner = &s
+	p.exprLevel = 0
+	p.next()
+
+	if p.token == token.RBrace && p.pos < end {
+		p.error(p.pos, "empty string interpolation")
+		return &BadExpr{From: pos, To: p.pos}, p.pos + 1
+	}
+
+	x := p.parseExpr()
+	if p.token != token.RBrace || p.pos >= end {
+		p.errorExpected(p.pos, "'}'")
+		return x, end
+	}
+	return x, p.pos + 1
+}
+
 func (p *Parser) parseCharLit() Expr {
 	if n := len(p.tokenLit); n >= 3 {
 		code, _, _, err := strconv.UnquoteChar(p.tokenLit[1:n-1], '\'')
@@ -691,12 +1004,18 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 		return s
 	case token.Return:
 		return p.parseReturnStmt()
+	case token.Yield:
+		return p.parseYieldStmt()
 	case token.Export:
 		return p.parseExportStmt()
 	case token.If:
 		return p.parseIfStmt()
 	case token.For:
 		return p.parseForStmt()
+	case token.Try:
+		return p.parseTryStmt()
+	case token.Switch:
+		return p.parseSwitchStmt()
 	case token.Break, token.Continue:
 		return p.parseBranchStmt(p.token)
 	case token.Semicolon:
@@ -782,6 +1101,120 @@ func (p *Parser) parseForStmt() Stmt {
 	}
 }
 
+func (p *Parser) parseSwitchStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "SwitchStmt"))
+	}
+
+	pos := p.expect(token.Switch)
+
+	var init Stmt
+	var tag Expr
+	if p.token != token.LBrace {
+		outer := p.exprLevel
+		p.exprLevel = -1
+
+		var tagStmt Stmt
+		if p.token != token.Semicolon {
+			tagStmt = p.parseSimpleStmt(false)
+		}
+		if p.token == token.Semicolon {
+			p.next()
+			init = tagStmt
+			tagStmt = nil
+			if p.token != token.LBrace {
+				tagStmt = p.parseSimpleStmt(false)
+			}
+		}
+		tag = p.makeExpr(tagStmt, "switch expression")
+		p.exprLevel = outer
+	}
+
+	_, typeSwitch := tag.(*TypeGuardExpr)
+
+	lbrace := p.expect(token.LBrace)
+	var list []Stmt
+	for p.token == token.Case || p.token == token.Default {
+		list = append(list, p.parseCaseClause(typeSwitch))
+	}
+	rbrace := p.expect(token.RBrace)
+	p.expectSemi()
+	return &SwitchStmt{
+		SwitchPos: pos,
+		Init:      init,
+		Tag:       tag,
+		Body: &BlockStmt{
+			LBrace: lbrace,
+			RBrace: rbrace,
+			Stmts:  list,
+		},
+	}
+}
+
+func (p *Parser) parseCaseClause(typeSwitch bool) *CaseClause {
+	if p.trace {
+		defer untracep(tracep(p, "CaseClause"))
+	}
+
+	pos := p.pos
+	var list []Expr
+	if p.token == token.Case {
+		p.next()
+		for {
+			if typeSwitch && p.token == token.Error {
+				// 'error' keyword is a type name in type switches
+				list = append(list, &Ident{Name: "error", NamePos: p.pos})
+				p.next()
+			} else {
+				list = append(list, p.parseExpr())
+			}
+			if p.token != token.Comma {
+				break
+			}
+			p.next()
+		}
+	} else {
+		p.expect(token.Default)
+	}
+
+	colon := p.expect(token.Colon)
+	var body []Stmt
+	for p.token != token.Case && p.token != token.Default &&
+		p.token != token.RBrace && p.token != token.EOF {
+		body = append(body, p.parseStmt())
+	}
+	return &CaseClause{
+		CasePos: pos,
+		List:    list,
+		Colon:   colon,
+		Body:    body,
+	}
+}
+
+func (p *Parser) parseTryStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "TryStmt"))
+	}
+
+	pos := p.expect(token.Try)
+	body := p.parseBlockStmt()
+	catchPos := p.expect(token.Catch)
+
+	var ident *Ident
+	if p.token == token.Ident {
+		ident = p.parseIdent()
+	}
+	catchBody := p.parseBlockStmt()
+	p.expectSemi()
+	return &TryStmt{
+		TryPos:   pos,
+		Body:     body,
+		CatchPos: catchPos,
+		Ident:    ident,
+		Catch:    catchBody,
+	}
+}
+
 func (p *Parser) parseBranchStmt(tok token.Token) Stmt {
 	if p.trace {
 		defer untracep(tracep(p, "BranchStmt"))
@@ -916,6 +1349,19 @@ func (p *Parser) parseReturnStmt() Stmt {
 	var x Expr
 	if p.token != token.Semicolon && p.token != token.RBrace {
 		x = p.parseExpr()
+		if p.token == token.Comma {
+			// multiple results are returned as an array
+			list := []Expr{x}
+			for p.token == token.Comma {
+				p.next()
+				list = append(list, p.parseExpr())
+			}
+			x = &ArrayLit{
+				Elements: list,
+				LBrack:   list[0].Pos(),
+				RBrack:   list[len(list)-1].End() - 1,
+			}
+		}
 	}
 	p.expectSemi()
 	return &ReturnStmt{
@@ -924,6 +1370,25 @@ func (p *Parser) parseReturnStmt() Stmt {
 	}
 }
 
+func (p *Parser) parseYieldStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "YieldStmt"))
+	}
+
+	pos := p.pos
+	p.expect(token.Yield)
+
+	var x Expr
+	if p.token != token.Semicolon && p.token != token.RBrace {
+		x = p.parseExpr()
+	}
+	p.expectSemi()
+	return &YieldStmt{
+		YieldPos: pos,
+		Result:   x,
+	}
+}
+
 func (p *Parser) parseExportStmt() Stmt {
 	if p.trace {
 		defer untracep(tracep(p, "ExportStmt"))
@@ -1041,15 +1506,29 @@ func (p *Parser) parseMapElementLit() *MapElementLit {
 
 	pos := p.pos
 	name := "_"
-	if p.token == token.Ident {
+	isIdent := p.token == token.Ident
+	if isIdent {
 		name = p.tokenLit
 	} else if p.token == token.String {
-		v, _ := strconv.Unquote(p.tokenLit)
-		name = v
+		if hasInterpolation(p.tokenLit) {
+			p.error(pos, "string interpolation not allowed in map key")
+		}
+		name = unquoteString(p.tokenLit)
 	} else {
 		p.errorExpected(pos, "map key")
 	}
 	p.next()
+
+	// shorthand: {name} is the same as {name: name}
+	if isIdent && (p.token == token.Comma || p.token == token.RBrace ||
+		p.token == token.Semicolon && p.tokenLit == "\n") {
+		return &MapElementLit{
+			Key:    name,
+			KeyPos: pos,
+			Value:  &Ident{Name: name, NamePos: pos},
+		}
+	}
+
 	colonPos := p.expect(token.Colon)
 	valueExpr := p.parseExpr()
 	return &MapElementLit{
@@ -1174,6 +1653,45 @@ func (p *Parser) next() {
 	p.token, p.tokenLit, p.pos = p.scanner.Scan()
 }
 
+// hasInterpolation reports whether the string literal lit contains an
+// embedded expression.
+func hasInterpolation(lit string) bool {
+	if len(lit) == 0 || lit[0] != '"' {
+		return false
+	}
+	for i := 1; i < len(lit)-1; i++ {
+		switch lit[i] {
+		case '\\':
+			i++
+		case '$':
+			if lit[i+1] == '{' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unquoteString is like strconv.Unquote, but also accepts the \$ escape of
+// double-quoted strings.
+func unquoteString(lit string) string {
+	if len(lit) > 0 && lit[0] == '"' && strings.Contains(lit, `\$`) {
+		var b strings.Builder
+		for i := 0; i < len(lit); i++ {
+			if lit[i] == '\\' && i+1 < len(lit) {
+				if lit[i+1] != '$' {
+					b.WriteByte('\\')
+				}
+				i++
+			}
+			b.WriteByte(lit[i])
+		}
+		lit = b.String()
+	}
+	v, _ := strconv.Unquote(lit)
+	return v
+}
+
 func (p *Parser) printTrace(a ...interface{}) {
 	const (
 		dots = ". . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . "
@@ -250,6 +250,20 @@ func TestParseAssignment(t *testing.T) {
 				token.MulAssign,
 				p(1, 3)))
 	})
+
+	expectParseString(t, "a, b := c, d", "a, b := c, d")
+	expectParseString(t, "a, b = f()", "a, b = f()")
+	expectParseString(t, "[a, b] := f()", "[a, b] := f()")
+	expectParseString(t, "{a, b: c} := m", "{a: a, b: c} := m")
+	expectParseString(t, "f := func() { return a, b }",
+		"f := func() {return [a, b]}")
+	expectParse(t, "return a, b", func(p pfn) []Stmt {
+		return stmts(
+			returnStmt(p(1, 1),
+				arrayLit(p(1, 8), p(1, 11),
+					ident("a", p(1, 8)),
+					ident("b", p(1, 11)))))
+	})
 }
 
 func TestParseBoolean(t *testing.T) {
@@ -1234,6 +1248,32 @@ func TestParseMap(t *testing.T) {
 				p(1, 3)))
 		})
 
+	expectParse(t, "a = {b, c: 1, d}", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(mapLit(p(1, 5), p(1, 16),
+					mapElementLit("b", p(1, 6), NoPos, ident("b", p(1, 6))),
+					mapElementLit(
+						"c", p(1, 9), p(1, 10), intLit(1, p(1, 12))),
+					mapElementLit(
+						"d", p(1, 15), NoPos, ident("d", p(1, 15))))),
+				token.Assign,
+				p(1, 3)))
+	})
+	expectParse(t, `
+{
+	a,
+	b
+}`, func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				mapLit(p(2, 1), p(5, 1),
+					mapElementLit("a", p(3, 2), NoPos, ident("a", p(3, 2))),
+					mapElementLit("b", p(4, 2), NoPos, ident("b", p(4, 2))))))
+	})
+	expectParseError(t, `a = {"b"}`)
+
 	expectParse(t, `
 {
 	key1: 1,
@@ -1486,6 +1526,224 @@ func TestParseSemicolon(t *testing.T) {
 	})
 }
 
+func TestParseSwitch(t *testing.T) {
+	expectParse(t, `switch a {
+case 1, 2: b
+default:
+}`, func(p pfn) []Stmt {
+		return stmts(
+			switchStmt(
+				nil,
+				ident("a", p(1, 8)),
+				blockStmt(p(1, 10), p(4, 1),
+					caseClause(
+						exprs(intLit(1, p(2, 6)), intLit(2, p(2, 9))),
+						p(2, 1), p(2, 10),
+						exprStmt(ident("b", p(2, 12)))),
+					caseClause(nil, p(3, 1), p(3, 8))),
+				p(1, 1)))
+	})
+	expectParse(t, "switch a.(type) {}", func(p pfn) []Stmt {
+		return stmts(
+			switchStmt(
+				nil,
+				&TypeGuardExpr{
+					Expr:   ident("a", p(1, 8)),
+					LParen: p(1, 10),
+					RParen: p(1, 15),
+				},
+				blockStmt(p(1, 17), p(1, 18)),
+				p(1, 1)))
+	})
+
+	expectParseString(t, "switch {}", "switch {}")
+	expectParseString(t, "switch a := 1; a {}", "switch a := 1; a {}")
+	expectParseString(t, "switch a := 1; {}", "switch a := 1; {}")
+	expectParseString(t, `switch a {
+case 1:
+	b := 2
+	c()
+case 2:
+}`, "switch a {case 1: b := 2; c(); case 2: }")
+	expectParseString(t, `switch x.y.(type) { case int, error: a }`,
+		"switch x.y.(type) {case int, error: a}")
+
+	expectParseError(t, `switch a { b }`)
+	expectParseError(t, `switch a { case 1 }`)
+	expectParseError(t, `switch a := 1 {}`)
+	expectParseError(t, `switch a.(b) {}`)
+	expectParseError(t, `switch a { case error: }`)
+}
+
+func TestParseTry(t *testing.T) {
+	expectParse(t, "try {} catch e {}", func(p pfn) []Stmt {
+		return stmts(
+			tryStmt(
+				blockStmt(p(1, 5), p(1, 6)),
+				ident("e", p(1, 14)),
+				blockStmt(p(1, 16), p(1, 17)),
+				p(1, 1), p(1, 8)))
+	})
+
+	expectParse(t, "try { a } catch { b }", func(p pfn) []Stmt {
+		return stmts(
+			tryStmt(
+				blockStmt(p(1, 5), p(1, 9),
+					exprStmt(ident("a", p(1, 7)))),
+				nil,
+				blockStmt(p(1, 17), p(1, 21),
+					exprStmt(ident("b", p(1, 19)))),
+				p(1, 1), p(1, 11)))
+	})
+
+	expectParseError(t, `try {}`)
+	expectParseError(t, `try {} catch e`)
+	expectParseError(t, `try {}
+catch e {}`)
+	expectParseError(t, `try a catch e {}`)
+}
+
+func TestParseYield(t *testing.T) {
+	expectParse(t, "func() { yield 1; yield }", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				funcLit(
+					funcType(identList(p(1, 5), p(1, 6), false), p(1, 1)),
+					blockStmt(p(1, 8), p(1, 25),
+						yieldStmt(p(1, 10), intLit(1, p(1, 16))),
+						yieldStmt(p(1, 19), nil)))))
+	})
+
+	expectParseString(t, "func() { yield a + b }", "func() {yield (a + b)}")
+	expectParseError(t, "func() { x := yield 1 }")
+}
+
+func TestParsePropagate(t *testing.T) {
+	expectParse(t, "a := f()?", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(
+					propagateExpr(
+						callExpr(
+							ident("f", p(1, 6)),
+							p(1, 7), p(1, 8), NoPos),
+						p(1, 9))),
+				token.Define, p(1, 3)))
+	})
+	expectParse(t, `a?
+b`, func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(propagateExpr(ident("a", p(1, 1)), p(1, 2))),
+			exprStmt(ident("b", p(2, 1))))
+	})
+
+	expectParseString(t, `a?`, "a?")
+	expectParseString(t, `f(x?, y)?`, "f(x?, y)?")
+	expectParseString(t, `a.b?.c?`, "a.b?.c?")
+	expectParseString(t, `a? * b? < c`, "((a? * b?) < c)")
+	expectParseString(t, `a? == b ? c : d`, "((a? == b) ? c : d)")
+	expectParseString(t, `a ? b? : c?`, "(a ? b? : c?)")
+	expectParseString(t, `x := a?[1]:[2]`, "x := (a ? [1] : [2])")
+	expectParseString(t, `a?-1:2`, "(a ? (-1) : 2)")
+	expectParseString(t, `a?.5:2`, "(a ? .5 : 2)")
+	expectParseString(t, `f(x)? + 1`, "(f(x)? + 1)")
+	expectParseString(t, `f(x)? - 1`, "(f(x)? - 1)")
+	expectParseString(t, `[f()? - 1, (g()?)]`, "[(f()? - 1), (g()?)]")
+	expectParseString(t, `a ? f()? + 1 : 2`, "(a ? (f()? + 1) : 2)")
+	expectParseString(t, `a ? b ? -1 : 2 : 3`, "(a ? (b ? (-1) : 2) : 3)")
+	expectParseString(t, `a ? b ? 1 : f()? - 1 : 2`,
+		"(a ? (b ? 1 : (f()? - 1)) : 2)")
+	expectParseString(t, "x := c?\n\t1 : 2", "x := (c ? 1 : 2)")
+	expectParseString(t, "x := c ?\n\t(1) : 2", "x := (c ? (1) : 2)")
+	expectParseString(t, "if f()? {}", "if f()? {}")
+	expectParseString(t, `a ?-a ?-a`, "((a? - a?) - a)")
+	expectParseString(t, `a ?-a ?-a : 1`, "(a ? ((-a?) - a) : 1)")
+
+	// the lookahead is linear in the number of '?' and brackets
+	_, err := parseSource("test", []byte("a"+strings.Repeat(" ?-a", 10000)),
+		nil)
+	require.NoError(t, err)
+	_, err = parseSource("test", []byte("a"+strings.Repeat(" ?-(a", 10000)+
+		strings.Repeat(")", 10000)), nil)
+	require.NoError(t, err)
+
+	expectParseError(t, `a ? b`)
+	expectParseError(t, `a?b`)
+}
+
+func TestParseOptionalChaining(t *testing.T) {
+	expectParse(t, "a?.b.c", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				chainExpr(
+					selectorExpr(
+						&SelectorExpr{
+							Expr:     ident("a", p(1, 1)),
+							Sel:      stringLit("b", p(1, 4)),
+							Optional: true,
+						},
+						stringLit("c", p(1, 6))))))
+	})
+
+	expectParse(t, "a?.[1]?.(b?.c)", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				chainExpr(
+					&CallExpr{
+						Func: &IndexExpr{
+							Expr:     ident("a", p(1, 1)),
+							LBrack:   p(1, 4),
+							Index:    intLit(1, p(1, 5)),
+							RBrack:   p(1, 6),
+							Optional: true,
+						},
+						LParen: p(1, 9),
+						Args: exprs(
+							chainExpr(
+								&SelectorExpr{
+									Expr:     ident("b", p(1, 10)),
+									Sel:      stringLit("c", p(1, 13)),
+									Optional: true,
+								})),
+						RParen:   p(1, 14),
+						Optional: true,
+					})))
+	})
+
+	expectParseString(t, "a?.b", "a?.b")
+	expectParseString(t, "a?.[1:2]", "a?.[1:2]")
+	expectParseString(t, "a?.b()?.[c]", "a?.b()?.[c]")
+	expectParseString(t, "a?.b?", "a?.b?")
+	expectParseString(t, "a ?.5 : 1", "(a ? .5 : 1)")
+	expectParseString(t, "x := a?.b + c?.d", "x := (a?.b + c?.d)")
+	expectParseString(t, "f()?.x", "f()?.x")
+	expectParseString(t, "(f()?).x?.y", "(f()?).x?.y")
+
+	expectParseError(t, "a?.")
+	expectParseError(t, "a?.1")
+}
+
+func TestParseCoalesce(t *testing.T) {
+	expectParse(t, "a ?? b", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				binaryExpr(
+					ident("a", p(1, 1)),
+					ident("b", p(1, 6)),
+					token.Coalesce,
+					p(1, 3))))
+	})
+
+	expectParseString(t, "a ?? b ?? c", "((a ?? b) ?? c)")
+	expectParseString(t, "a ?? b || c", "(a ?? (b || c))")
+	expectParseString(t, "a || b ?? c + d", "((a || b) ?? (c + d))")
+	expectParseString(t, "a ?? b ? c : d", "((a ?? b) ? c : d)")
+	expectParseString(t, "a?.b ?? c", "(a?.b ?? c)")
+
+	expectParseError(t, "a ??")
+}
+
 func TestParseString(t *testing.T) {
 	expectParse(t, `a = "foo\nbar"`, func(p pfn) []Stmt {
 		return stmts(
@@ -1504,6 +1762,75 @@ func TestParseString(t *testing.T) {
 				token.Assign,
 				p(1, 3)))
 	})
+
+	expectParse(t, `a = "x\${y}"`, func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(stringLit("x${y}", p(1, 5))),
+				token.Assign,
+				p(1, 3)))
+	})
+}
+
+func TestParseInterpolatedString(t *testing.T) {
+	expectParse(t, `a = "x ${b.c} y ${d + 1}"`, func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(interpolatedStringLit(p(1, 5),
+					stringLit("x ", p(1, 6)),
+					selectorExpr(
+						ident("b", p(1, 10)),
+						stringLit("c", p(1, 12))),
+					stringLit(" y ", p(1, 14)),
+					binaryExpr(
+						ident("d", p(1, 19)),
+						intLit(1, p(1, 23)),
+						token.Add,
+						p(1, 21)))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParse(t, `a = "${m["k"]}"`, func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(interpolatedStringLit(p(1, 5),
+					indexExpr(
+						ident("m", p(1, 8)),
+						stringLit("k", p(1, 10)),
+						p(1, 9), p(1, 13)))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParse(t, `a = "${"${b}"}!"`, func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(interpolatedStringLit(p(1, 5),
+					interpolatedStringLit(p(1, 8),
+						ident("b", p(1, 11))),
+					stringLit("!", p(1, 15)))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParseString(t, `a = "x ${b} \${c}"`, `a = "x ${b} \${c}"`)
+
+	expectParseError(t, `a = "${}"`)
+	expectParseError(t, `a = "x ${ } y"`)
+	input := `a = "x ${} y"`
+	testFile := NewFileSet().AddFile("test", -1, len(input))
+	_, err := NewParser(testFile, []byte(input), nil).ParseFile()
+	require.Equal(t, "Parse Error: empty string interpolation\n\tat test:1:10",
+		err.Error())
+	expectParseError(t, `a = "${b c}"`)
+	expectParseError(t, `a = "${b"`)
+	expectParseError(t, `a = {"${b}": 1}`)
+	expectParseError(t, `a = import("${b}")`)
 }
 
 func TestParseInt(t *testing.T) {
@@ -1780,6 +2107,10 @@ func emptyStmt(implicit bool, pos Pos) *EmptyStmt {
 	return &EmptyStmt{Implicit: implicit, Semicolon: pos}
 }
 
+func yieldStmt(pos Pos, result Expr) *YieldStmt {
+	return &YieldStmt{Result: result, YieldPos: pos}
+}
+
 func returnStmt(pos Pos, result Expr) *ReturnStmt {
 	return &ReturnStmt{Result: result, ReturnPos: pos}
 }
@@ -1831,6 +2162,31 @@ func funcType(params *IdentList, pos Pos) *FuncType {
 	return &FuncType{Params: params, FuncPos: pos}
 }
 
+func switchStmt(
+	init Stmt,
+	tag Expr,
+	body *BlockStmt,
+	pos Pos,
+) *SwitchStmt {
+	return &SwitchStmt{Init: init, Tag: tag, Body: body, SwitchPos: pos}
+}
+
+func caseClause(list []Expr, pos, colon Pos, body ...Stmt) *CaseClause {
+	return &CaseClause{List: list, Body: body, CasePos: pos, Colon: colon}
+}
+
+func tryStmt(
+	body *BlockStmt,
+	ident *Ident,
+	catchBody *BlockStmt,
+	pos, catchPos Pos,
+) *TryStmt {
+	return &TryStmt{
+		Body: body, Ident: ident, Catch: catchBody, TryPos: pos,
+		CatchPos: catchPos,
+	}
+}
+
 func blockStmt(lbrace, rbrace Pos, list ...Stmt) *BlockStmt {
 	return &BlockStmt{Stmts: list, LBrace: lbrace, RBrace: rbrace}
 }
@@ -1867,6 +2223,10 @@ func condExpr(
 	}
 }
 
+func propagateExpr(x Expr, questionPos Pos) *PropagateExpr {
+	return &PropagateExpr{Expr: x, QuestionPos: questionPos}
+}
+
 func unaryExpr(x Expr, op token.Token, pos Pos) *UnaryExpr {
 	return &UnaryExpr{Expr: x, Token: op, TokenPos: pos}
 }
@@ -1893,6 +2253,10 @@ func stringLit(value string, pos Pos) *StringLit {
 	return &StringLit{Value: value, ValuePos: pos}
 }
 
+func interpolatedStringLit(pos Pos, parts ...Expr) *InterpolatedStringLit {
+	return &InterpolatedStringLit{Parts: parts, ValuePos: pos}
+}
+
 func charLit(value rune, pos Pos) *CharLit {
 	return &CharLit{
 		Value: value, ValuePos: pos, Literal: fmt.Sprintf("'%c'", value),
@@ -1970,6 +2334,10 @@ func errorExpr(
 	}
 }
 
+func chainExpr(x Expr) *ChainExpr {
+	return &ChainExpr{Expr: x}
+}
+
 func selectorExpr(x, sel Expr) *SelectorExpr {
 	return &SelectorExpr{Expr: x, Sel: sel}
 }
@@ -2041,6 +2409,29 @@ func equalStmt(t *testing.T, expected, actual Stmt) {
 			actual.(*ReturnStmt).Result)
 		require.Equal(t, expected.ReturnPos,
 			actual.(*ReturnStmt).ReturnPos)
+	case *YieldStmt:
+		equalExpr(t, expected.Result,
+			actual.(*YieldStmt).Result)
+		require.Equal(t, expected.YieldPos,
+			actual.(*YieldStmt).YieldPos)
+	case *SwitchStmt:
+		equalStmt(t, expected.Init, actual.(*SwitchStmt).Init)
+		equalExpr(t, expected.Tag, actual.(*SwitchStmt).Tag)
+		equalStmt(t, expected.Body, actual.(*SwitchStmt).Body)
+		require.Equal(t, expected.SwitchPos,
+			actual.(*SwitchStmt).SwitchPos)
+	case *CaseClause:
+		equalExprs(t, expected.List, actual.(*CaseClause).List)
+		equalStmts(t, expected.Body, actual.(*CaseClause).Body)
+		require.Equal(t, expected.CasePos, actual.(*CaseClause).CasePos)
+		require.Equal(t, expected.Colon, actual.(*CaseClause).Colon)
+	case *TryStmt:
+		equalStmt(t, expected.Body, actual.(*TryStmt).Body)
+		equalExpr(t, expected.Ident, actual.(*TryStmt).Ident)
+		equalStmt(t, expected.Catch, actual.(*TryStmt).Catch)
+		require.Equal(t, expected.TryPos, actual.(*TryStmt).TryPos)
+		require.Equal(t, expected.CatchPos,
+			actual.(*TryStmt).CatchPos)
 	case *BranchStmt:
 		equalExpr(t, expected.Label,
 			actual.(*BranchStmt).Label)
@@ -2092,6 +2483,11 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*StringLit).Value)
 		require.Equal(t, int(expected.ValuePos),
 			int(actual.(*StringLit).ValuePos))
+	case *InterpolatedStringLit:
+		require.Equal(t, int(expected.ValuePos),
+			int(actual.(*InterpolatedStringLit).ValuePos))
+		equalExprs(t, expected.Parts,
+			actual.(*InterpolatedStringLit).Parts)
 	case *ArrayLit:
 		require.Equal(t, expected.LBrack,
 			actual.(*ArrayLit).LBrack)
@@ -2136,6 +2532,11 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*CallExpr).RParen)
 		equalExprs(t, expected.Args,
 			actual.(*CallExpr).Args)
+		require.Equal(t, expected.Optional,
+			actual.(*CallExpr).Optional)
+	case *ChainExpr:
+		equalExpr(t, expected.Expr,
+			actual.(*ChainExpr).Expr)
 	case *ParenExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*ParenExpr).Expr)
@@ -2152,6 +2553,8 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*IndexExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*IndexExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*IndexExpr).Optional)
 	case *SliceExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SliceExpr).Expr)
@@ -2163,11 +2566,15 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*SliceExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*SliceExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*SliceExpr).Optional)
 	case *SelectorExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SelectorExpr).Expr)
 		equalExpr(t, expected.Sel,
 			actual.(*SelectorExpr).Sel)
+		require.Equal(t, expected.Optional,
+			actual.(*SelectorExpr).Optional)
 	case *ImportExpr:
 		require.Equal(t, expected.ModuleName,
 			actual.(*ImportExpr).ModuleName)
@@ -2184,6 +2591,18 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			int(actual.(*ErrorExpr).LParen))
 		require.Equal(t, int(expected.RParen),
 			int(actual.(*ErrorExpr).RParen))
+	case *TypeGuardExpr:
+		equalExpr(t, expected.Expr,
+			actual.(*TypeGuardExpr).Expr)
+		require.Equal(t, expected.LParen,
+			actual.(*TypeGuardExpr).LParen)
+		require.Equal(t, expected.RParen,
+			actual.(*TypeGuardExpr).RParen)
+	case *PropagateExpr:
+		equalExpr(t, expected.Expr,
+			actual.(*PropagateExpr).Expr)
+		require.Equal(t, expected.QuestionPos,
+			actual.(*PropagateExpr).QuestionPos)
 	case *CondExpr:
 		equalExpr(t, expected.Cond,
 			actual.(*CondExpr).Cond)
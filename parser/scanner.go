@@ -134,7 +134,18 @@ func (s *Scanner) Scan() (
 		case ',':
 			tok = token.Comma
 		case '?':
-			tok = token.Question
+			switch {
+			case s.ch == '?':
+				s.next()
+				tok = token.Coalesce
+			case s.ch == '.' && !isDigit(rune(s.peek())):
+				s.next()
+				tok = token.QuestionDot
+			default:
+				// may be a postfix error propagation; the parser decides
+				insertSemi = true
+				tok = token.Question
+			}
 		case ';':
 			tok = token.Semicolon
 			literal = ";"
@@ -431,6 +442,12 @@ func (s *Scanner) scanNumber() (token.Token, string) {
 func (s *Scanner) scanEscape(quote rune) bool {
 	offs := s.offset
 
+	if s.ch == '$' && quote == '"' {
+		// \$ prevents interpolation
+		s.next()
+		return true
+	}
+
 	var n int
 	var base, max uint32
 	switch s.ch {
@@ -531,10 +548,47 @@ func (s *Scanner) scanString() string {
 		if ch == '\\' {
 			s.scanEscape('"')
 		}
+		if ch == '$' && s.ch == '{' {
+			s.next()
+			if !s.scanInterpolation() {
+				s.error(offs, "string literal not terminated")
+				break
+			}
+		}
 	}
 	return string(s.src[offs:s.offset])
 }
 
+// scanInterpolation skips the tokens of an expression embedded in a string
+// literal, up to and including the matching '}'. It reports whether the
+// closing brace was found.
+func (s *Scanner) scanInterpolation() bool {
+	s.insertSemi = false
+	depth := 0
+	for {
+		tok, _, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return false
+		case token.LBrace:
+			depth++
+		case token.RBrace:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		}
+	}
+}
+
+// seek moves the scanner to the given offset of the source.
+func (s *Scanner) seek(offset int) {
+	s.ch = ' '
+	s.readOffset = offset
+	s.insertSemi = false
+	s.next()
+}
+
 func (s *Scanner) scanRawString() string {
 	offs := s.offset - 1 // '`' opening already consumed
 
@@ -62,6 +62,10 @@ func TestScanner_Scan(t *testing.T) {
 		},
 		{token.String, "`\r`"},
 		{token.String, "`foo\r\nbar`"},
+		{token.String, `"a \${b}"`},
+		{token.String, `"a ${b} c"`},
+		{token.String, `"a ${m["}"]} c"`},
+		{token.String, `"a ${func() { return "${b}" }()} c"`},
 		{token.Add, "+"},
 		{token.Sub, "-"},
 		{token.Mul, "*"},
@@ -108,6 +112,8 @@ func TestScanner_Scan(t *testing.T) {
 		{token.RBrace, "}"},
 		{token.Semicolon, ";"},
 		{token.Colon, ":"},
+		{token.Coalesce, "??"},
+		{token.QuestionDot, "?."},
 		{token.Break, "break"},
 		{token.Continue, "continue"},
 		{token.Else, "else"},
@@ -124,6 +124,45 @@ func (s *BranchStmt) String() string {
 	return s.Token.String() + label
 }
 
+// CaseClause represents a case of a switch statement.
+type CaseClause struct {
+	CasePos Pos
+	List    []Expr // list of expressions or types; nil means default case
+	Colon   Pos
+	Body    []Stmt
+}
+
+func (s *CaseClause) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *CaseClause) Pos() Pos {
+	return s.CasePos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *CaseClause) End() Pos {
+	if n := len(s.Body); n > 0 {
+		return s.Body[n-1].End()
+	}
+	return s.Colon + 1
+}
+
+func (s *CaseClause) String() string {
+	var list []string
+	for _, e := range s.Body {
+		list = append(list, e.String())
+	}
+	if s.List == nil {
+		return "default: " + strings.Join(list, "; ")
+	}
+	var exprs []string
+	for _, e := range s.List {
+		exprs = append(exprs, e.String())
+	}
+	return "case " + strings.Join(exprs, ", ") + ": " +
+		strings.Join(list, "; ")
+}
+
 // EmptyStmt represents an empty statement.
 type EmptyStmt struct {
 	Semicolon Pos
@@ -347,3 +386,91 @@ func (s *ReturnStmt) String() string {
 	}
 	return "return"
 }
+
+// SwitchStmt represents a switch statement.
+type SwitchStmt struct {
+	SwitchPos Pos
+	Init      Stmt       // initialization statement; or nil
+	Tag       Expr       // tag expression; or nil
+	Body      *BlockStmt // CaseClauses only
+}
+
+func (s *SwitchStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *SwitchStmt) Pos() Pos {
+	return s.SwitchPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *SwitchStmt) End() Pos {
+	return s.Body.End()
+}
+
+func (s *SwitchStmt) String() string {
+	var init, tag string
+	if s.Init != nil {
+		init = s.Init.String() + "; "
+	}
+	if s.Tag != nil {
+		tag = s.Tag.String() + " "
+	}
+	return "switch " + init + tag + s.Body.String()
+}
+
+// TryStmt represents a try-catch statement.
+type TryStmt struct {
+	TryPos   Pos
+	Body     *BlockStmt
+	CatchPos Pos
+	Ident    *Ident // catch variable; or nil
+	Catch    *BlockStmt
+}
+
+func (s *TryStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *TryStmt) Pos() Pos {
+	return s.TryPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *TryStmt) End() Pos {
+	return s.Catch.End()
+}
+
+func (s *TryStmt) String() string {
+	var ident string
+	if s.Ident != nil {
+		ident = s.Ident.String() + " "
+	}
+	return "try " + s.Body.String() + " catch " + ident + s.Catch.String()
+}
+
+// YieldStmt represents a yield statement.
+type YieldStmt struct {
+	YieldPos Pos
+	Result   Expr // yielded value; or nil
+}
+
+func (s *YieldStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *YieldStmt) Pos() Pos {
+	return s.YieldPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *YieldStmt) End() Pos {
+	if s.Result != nil {
+		return s.Result.End()
+	}
+	return s.YieldPos + 5
+}
+
+func (s *YieldStmt) String() string {
+	if s.Result != nil {
+		return "yield " + s.Result.String()
+	}
+	return "yield"
+}
@@ -0,0 +1,86 @@
+package slim
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// VMPool is a pool of VMs that run the same bytecode, so that the stack and
+// the frames of a VM are reused by the subsequent runs instead of being
+// allocated by each run. VMs are reset when they are put back, so no state of
+// a run leaks into the next one. It is safe for concurrent use by multiple
+// goroutines.
+type VMPool struct {
+	bytecode *Bytecode
+	pool     sync.Pool
+}
+
+// NewVMPool creates a pool of VMs running the bytecode.
+func NewVMPool(bytecode *Bytecode) *VMPool {
+	return &VMPool{bytecode: bytecode}
+}
+
+// Get returns a VM of the pool with the given globals and allocation limit,
+// or a new VM if the pool is empty. The other limits of the VM have their
+// default values.
+func (p *VMPool) Get(globals []Object, maxAllocs int64) *VM {
+	v, _ := p.pool.Get().(*VM)
+	if v == nil {
+		return NewVM(p.bytecode, globals, maxAllocs)
+	}
+	if globals == nil {
+		globals = make([]Object, GlobalsSize)
+	}
+	v.globals = globals
+	v.maxAllocs = maxAllocs
+	return v
+}
+
+// Put resets the VM and puts it back to the pool. VMs that are suspended or
+// that were not created for the bytecode of the pool are not put back. The
+// VM, and the CallContext passed to the Go functions it called, must not be
+// used after calling Put.
+func (p *VMPool) Put(v *VM) {
+	if v.suspended || v.mainFunc != p.bytecode.MainFunction {
+		return
+	}
+	v.reset()
+	p.pool.Put(v)
+}
+
+// reset clears the states of the VM, including the objects left on the
+// stack, and restores the default limits.
+func (v *VM) reset() {
+	for i := range v.stack {
+		v.stack[i] = nil
+	}
+	for i := range v.frames {
+		v.frames[i] = frame{}
+	}
+	v.sp = 0
+	v.globals = nil
+	v.frames[0].fn = v.mainFunc
+	v.frames[0].ip = -1
+	v.framesIndex = 1
+	v.curFrame = &v.frames[0]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = -1
+	v.handlers = v.handlers[:0]
+	v.handlerBase = 0
+	for i := range v.caches {
+		v.caches[i] = selectorCache{}
+	}
+	atomic.StoreInt64(&v.aborting, 0)
+	v.suspending = false
+	v.suspended = false
+	v.invoking = 0
+	v.entryFn = false
+	v.maxStack = StackSize
+	v.maxFrames = MaxFrames
+	v.maxInsts = -1
+	v.maxMemory = -1
+	v.memory = 0
+	v.maxStrLen = MaxStringLen
+	v.maxBytesLen = MaxBytesLen
+	v.err = nil
+}
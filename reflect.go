@@ -0,0 +1,921 @@
+package slim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	objectType       = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	timeType         = reflect.TypeOf(time.Time{})
+	durationType     = reflect.TypeOf(time.Duration(0))
+	callableFuncType = reflect.TypeOf(CallableFunc(nil))
+)
+
+// structField is an exported field of a struct, including the fields
+// promoted from its embedded structs.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map // reflect.Type to []structField
+
+// structFields returns the fields of the struct type. A field is named by its
+// "slim" tag, or by its "json" tag if it has none, or by its Go name. The
+// fields tagged "-" are ignored, and the embedded structs without a tag name
+// have their fields promoted like encoding/json does.
+func structFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	var fields []structField
+	depths := make(map[string]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("slim")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				continue // its fields are promoted
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		// the shallower field wins, or the first one at the same depth
+		if depth, ok := depths[name]; ok {
+			if depth <= len(f.Index) {
+				continue
+			}
+			for i := range fields {
+				if fields[i].name == name {
+					fields = append(fields[:i], fields[i+1:]...)
+					break
+				}
+			}
+		}
+		depths[name] = len(f.Index)
+		fields = append(fields, structField{
+			name:      name,
+			index:     f.Index,
+			omitEmpty: hasTagOption(opts, "omitempty"),
+		})
+	}
+
+	actual, _ := structFieldsCache.LoadOrStore(t, fields)
+	return actual.([]structField)
+}
+
+func hasTagOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fromReflect converts the values of the types FromInterface does not know,
+// such as structs, typed slices and maps, using reflection.
+func fromReflect(v interface{}) (Object, error) {
+	c := &reflectConverter{seen: make(map[uintptr]bool)}
+	return c.convert(reflect.ValueOf(v))
+}
+
+type reflectConverter struct {
+	seen map[uintptr]bool // pointers and maps being converted
+}
+
+func (c *reflectConverter) convert(rv reflect.Value) (Object, error) {
+	for rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return UndefinedValue, nil
+	}
+
+	t := rv.Type()
+	if rv.CanInterface() {
+		if t.Implements(objectType) {
+			return rv.Interface().(Object), nil
+		}
+		if t.Implements(errorType) {
+			if rv.Kind() == reflect.Ptr && rv.IsNil() {
+				return UndefinedValue, nil
+			}
+			err := rv.Interface().(error)
+			return &Error{Value: &String{Value: err.Error()}}, nil
+		}
+	}
+	switch {
+	case t == timeType && rv.CanInterface():
+		return &Time{Value: rv.Interface().(time.Time)}, nil
+	case t == durationType:
+		return &Int{Value: rv.Int()}, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return &Int{Value: rv.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert to object: %s value %d "+
+				"overflows int", t, u)
+		}
+		return &Int{Value: int64(u)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: rv.Float()}, nil
+	case reflect.String:
+		if rv.Len() > MaxStringLen {
+			return nil, ErrStringLimit
+		}
+		return &String{Value: rv.String()}, nil
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		ptr := rv.Pointer()
+		if c.seen[ptr] {
+			return nil, fmt.Errorf("cannot convert to object: "+
+				"cyclic value of %s", t)
+		}
+		c.seen[ptr] = true
+		defer delete(c.seen, ptr)
+		return c.convert(rv.Elem())
+	case reflect.Slice:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			if rv.Len() > MaxBytesLen {
+				return nil, ErrBytesLimit
+			}
+			return &Bytes{Value: rv.Bytes()}, nil
+		}
+		return c.convertElements(rv)
+	case reflect.Array:
+		return c.convertElements(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		ptr := rv.Pointer()
+		if c.seen[ptr] {
+			return nil, fmt.Errorf("cannot convert to object: "+
+				"cyclic value of %s", t)
+		}
+		c.seen[ptr] = true
+		defer delete(c.seen, ptr)
+		return c.convertMap(rv)
+	case reflect.Struct:
+		return c.convertStruct(rv)
+	case reflect.Func:
+		if !rv.IsNil() && rv.CanInterface() &&
+			t.ConvertibleTo(callableFuncType) {
+			fn := rv.Convert(callableFuncType).Interface().(CallableFunc)
+			return &UserFunction{Value: fn}, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert to object: %s", t)
+}
+
+func (c *reflectConverter) convertElements(rv reflect.Value) (Object, error) {
+	arr := make([]Object, rv.Len())
+	for i := range arr {
+		o, err := c.convert(rv.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = o
+	}
+	return &Array{Value: arr}, nil
+}
+
+func (c *reflectConverter) convertMap(rv reflect.Value) (Object, error) {
+	kv := make(map[string]Object, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k, err := mapKeyString(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		o, err := c.convert(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		kv[k] = o
+	}
+	return &Map{Value: kv}, nil
+}
+
+// mapKeyString returns the map key of the string or integer key.
+func mapKeyString(k reflect.Value) (string, error) {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("cannot convert to object: map key type %s",
+		k.Type())
+}
+
+func (c *reflectConverter) convertStruct(rv reflect.Value) (Object, error) {
+	fields := structFields(rv.Type())
+	kv := make(map[string]Object, len(fields))
+	for _, f := range fields {
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			continue // field of a nil embedded struct pointer
+		}
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		o, err := c.convert(fv)
+		if err != nil {
+			return nil, err
+		}
+		kv[f.name] = o
+	}
+	return &Map{Value: kv}, nil
+}
+
+// isEmptyValue reports whether the value is omitted by the "omitempty" tag
+// option, as in encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Decode decodes the object into the Go value out points to. It's the
+// reverse of FromInterface: Int, Float, String, Bool, Char, Bytes and Time
+// are decoded into the Go values of the same kinds, arrays into slices and
+// arrays, and maps into maps and structs, whose fields are named as in
+// FromInterface. The keys of a map that have no field in the struct are
+// ignored. Undefined sets the pointers, slices, maps and interfaces to nil,
+// and leaves the other values unchanged. The values of type Object are set
+// as they are, and the values of type interface{} are set to the result of
+// ToInterface. Decode returns a DecodeError with the path of the object if
+// an object cannot be decoded into its Go value.
+func Decode(o Object, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("decode target must be a non-nil pointer")
+	}
+	return decodeValue("", o, rv.Elem())
+}
+
+func decodeValue(path string, o Object, rv reflect.Value) error {
+	if o == nil {
+		o = UndefinedValue
+	}
+	t := rv.Type()
+	if (t.Kind() != reflect.Interface || t.NumMethod() > 0) &&
+		reflect.TypeOf(o).AssignableTo(t) {
+		rv.Set(reflect.ValueOf(o)) // objects are set as they are
+		return nil
+	}
+	if o == UndefinedValue {
+		switch rv.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			rv.Set(reflect.Zero(t))
+		}
+		return nil
+	}
+
+	mismatch := func(expected string) error {
+		return DecodeError{
+			Path:     path,
+			Expected: expected,
+			Found:    o.TypeName(),
+		}
+	}
+	switch t {
+	case timeType:
+		v, ok := o.(*Time)
+		if !ok {
+			return mismatch("time")
+		}
+		rv.Set(reflect.ValueOf(v.Value))
+		return nil
+	case durationType:
+		v, ok := o.(*Int)
+		if !ok {
+			return mismatch("int")
+		}
+		rv.SetInt(v.Value)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		v := ToInterface(o)
+		if v == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if !reflect.TypeOf(v).AssignableTo(t) {
+			return mismatch(t.String())
+		}
+		rv.Set(reflect.ValueOf(v))
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return decodeValue(path, o, rv.Elem())
+	case reflect.Bool:
+		v, ok := o.(*Bool)
+		if !ok {
+			return mismatch("bool")
+		}
+		rv.SetBool(!v.IsFalsy())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		var v int64
+		switch o := o.(type) {
+		case *Int:
+			v = o.Value
+		case *Char:
+			v = int64(o.Value)
+		default:
+			return mismatch("int")
+		}
+		if rv.OverflowInt(v) {
+			return DecodeError{
+				Path:     path,
+				Expected: t.String(),
+				Found:    strconv.FormatInt(v, 10),
+			}
+		}
+		rv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		var v int64
+		switch o := o.(type) {
+		case *Int:
+			v = o.Value
+		case *Char:
+			v = int64(o.Value)
+		default:
+			return mismatch("int")
+		}
+		if v < 0 || rv.OverflowUint(uint64(v)) {
+			return DecodeError{
+				Path:     path,
+				Expected: t.String(),
+				Found:    strconv.FormatInt(v, 10),
+			}
+		}
+		rv.SetUint(uint64(v))
+	case reflect.Float32, reflect.Float64:
+		switch o := o.(type) {
+		case *Float:
+			rv.SetFloat(o.Value)
+		case *Int:
+			rv.SetFloat(float64(o.Value))
+		default:
+			return mismatch("float")
+		}
+	case reflect.String:
+		v, ok := o.(*String)
+		if !ok {
+			return mismatch("string")
+		}
+		rv.SetString(v.Value)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch o := o.(type) {
+			case *Bytes:
+				rv.SetBytes(append([]byte(nil), o.Value...))
+				return nil
+			case *String:
+				rv.SetBytes([]byte(o.Value))
+				return nil
+			}
+		}
+		elements, ok := arrayElements(o)
+		if !ok {
+			return mismatch("array")
+		}
+		rv.Set(reflect.MakeSlice(t, len(elements), len(elements)))
+		return decodeElements(path, elements, rv)
+	case reflect.Array:
+		elements, ok := arrayElements(o)
+		if !ok {
+			return mismatch("array")
+		}
+		if len(elements) != rv.Len() {
+			return DecodeError{
+				Path:     path,
+				Expected: fmt.Sprintf("array of length %d", rv.Len()),
+				Found:    fmt.Sprintf("array of length %d", len(elements)),
+			}
+		}
+		return decodeElements(path, elements, rv)
+	case reflect.Map:
+		m, ok := mapElements(o)
+		if !ok {
+			return mismatch("map")
+		}
+		return decodeMap(path, m, rv)
+	case reflect.Struct:
+		m, ok := mapElements(o)
+		if !ok {
+			return mismatch("map")
+		}
+		return decodeStruct(path, m, rv)
+	default:
+		return mismatch(t.String())
+	}
+	return nil
+}
+
+func arrayElements(o Object) ([]Object, bool) {
+	switch o := o.(type) {
+	case *Array:
+		return o.Value, true
+	case *ImmutableArray:
+		return o.Value, true
+	}
+	return nil, false
+}
+
+func mapElements(o Object) (map[string]Object, bool) {
+	switch o := o.(type) {
+	case *Map:
+		return o.Value, true
+	case *ImmutableMap:
+		return o.Value, true
+	}
+	return nil, false
+}
+
+func decodeElements(path string, elements []Object, rv reflect.Value) error {
+	for i, e := range elements {
+		err := decodeValue(path+"["+strconv.Itoa(i)+"]", e, rv.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeMap(path string, m map[string]Object, rv reflect.Value) error {
+	t := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMapWithSize(t, len(m)))
+	}
+	for k, e := range m {
+		key := reflect.New(t.Key()).Elem()
+		var err error
+		switch key.Kind() {
+		case reflect.String:
+			key.SetString(k)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+			reflect.Int64:
+			var n int64
+			if n, err = strconv.ParseInt(k, 10, t.Key().Bits()); err == nil {
+				key.SetInt(n)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64, reflect.Uintptr:
+			var n uint64
+			if n, err = strconv.ParseUint(k, 10, t.Key().Bits()); err == nil {
+				key.SetUint(n)
+			}
+		default:
+			return DecodeError{
+				Path:     path,
+				Expected: t.String(),
+				Found:    "map",
+			}
+		}
+		if err != nil {
+			return DecodeError{
+				Path:     path,
+				Expected: "key of " + t.Key().String(),
+				Found:    strconv.Quote(k),
+			}
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := decodeValue(joinPath(path, k), e, elem); err != nil {
+			return err
+		}
+		rv.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func decodeStruct(path string, m map[string]Object, rv reflect.Value) error {
+	for _, f := range structFields(rv.Type()) {
+		e, ok := m[f.name]
+		if !ok {
+			continue
+		}
+		fv, ok := fieldByIndexAlloc(rv, f.index)
+		if !ok {
+			continue // field of an unexported embedded struct pointer
+		}
+		if err := decodeValue(joinPath(path, f.name), e, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndexAlloc returns the settable field of the struct, allocating the
+// nil embedded struct pointers on its way.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, v.CanSet()
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// StructProxy is an object that exposes a Go struct to the scripts. Its
+// fields are read and written by index or selector, and its methods are
+// called as functions, so that the changes made by the scripts are seen by
+// Go. Use WrapStruct to create a StructProxy. The accesses to the struct are
+// not synchronized.
+type StructProxy struct {
+	ObjectImpl
+	v       reflect.Value   // pointer to the struct
+	members map[string]bool // nil if all the members are exposed
+}
+
+// WrapStruct returns a StructProxy of the struct ptr points to. members are
+// the names of the fields and the methods exposed to the scripts; all the
+// exported fields and methods are exposed if none is given. The fields are
+// named like they are by FromInterface, and the methods by their Go names.
+// The field values are converted by FromInterface when they are read, and
+// by Decode when they are written. The methods take their arguments decoded
+// by Decode; their results are converted by FromInterface, an array of them
+// is returned if there are more than one, and a non-nil error returned as
+// the last result is returned as an Error object.
+//
+// The struct and the non-nil pointer to struct fields are read as the
+// proxies of the nested structs, so that their fields can be written too. A
+// member exposes the whole nested struct, and a dotted member, e.g.
+// "addr.city", exposes only the given member of it.
+func WrapStruct(ptr interface{}, members ...string) (*StructProxy, error) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() ||
+		v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot wrap %T: not a pointer to struct", ptr)
+	}
+	o := &StructProxy{v: v}
+	if len(members) > 0 {
+		o.members = make(map[string]bool, len(members))
+		for _, name := range members {
+			if !hasMember(v.Type(), name) {
+				return nil, fmt.Errorf("no member '%s' in %s", name, v.Type())
+			}
+			o.members[name] = true
+		}
+	}
+	return o, nil
+}
+
+// hasMember reports whether the pointer to struct type t has the field or
+// the method of the given name, or the dotted name of a member of a nested
+// struct.
+func hasMember(t reflect.Type, name string) bool {
+	for _, f := range structFields(t.Elem()) {
+		if f.name == name {
+			return true
+		}
+	}
+	if _, ok := t.MethodByName(name); ok {
+		return true
+	}
+
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return false
+	}
+	for _, f := range structFields(t.Elem()) {
+		if f.name != name[:i] {
+			continue
+		}
+		ft := t.Elem().FieldByIndex(f.index).Type
+		if ft.Kind() != reflect.Ptr {
+			ft = reflect.PtrTo(ft)
+		}
+		return isProxyStruct(ft) && hasMember(ft, name[i+1:])
+	}
+	return false
+}
+
+// isProxyStruct reports whether the values pointed to by the pointer type t
+// are exposed as StructProxies rather than converted by FromInterface.
+func isProxyStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct &&
+		t.Elem() != timeType &&
+		!t.Implements(objectType) && !t.Implements(errorType) &&
+		!t.Elem().Implements(objectType) && !t.Elem().Implements(errorType)
+}
+
+// TypeName returns the name of the type.
+func (o *StructProxy) TypeName() string {
+	return "struct:" + o.v.Elem().Type().Name()
+}
+
+func (o *StructProxy) String() string {
+	return o.format(make(map[proxyKey]bool))
+}
+
+// proxyKey identifies a struct; a struct shares its address with its first
+// field.
+type proxyKey struct {
+	ptr uintptr
+	t   reflect.Type
+}
+
+// format formats the exposed fields like a Map does, writing the structs
+// reached again through a cycle of pointers as {...}.
+func (o *StructProxy) format(seen map[proxyKey]bool) string {
+	key := proxyKey{o.v.Pointer(), o.v.Type()}
+	if seen[key] {
+		return "{...}"
+	}
+	seen[key] = true
+	defer delete(seen, key)
+
+	var pairs []string
+	for k, v := range o.fieldMap().Value {
+		var s string
+		if p, ok := v.(*StructProxy); ok {
+			s = p.format(seen)
+		} else {
+			s = v.String()
+		}
+		pairs = append(pairs, fmt.Sprintf("%s: %s", k, s))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+}
+
+// Copy returns a proxy of a shallow copy of the struct, exposing the same
+// members.
+func (o *StructProxy) Copy() Object {
+	v := reflect.New(o.v.Elem().Type())
+	v.Elem().Set(o.v.Elem())
+	return &StructProxy{v: v, members: o.members}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *StructProxy) Equals(x Object) bool {
+	t, ok := x.(*StructProxy)
+	return ok && t.v.Pointer() == o.v.Pointer() && t.v.Type() == o.v.Type()
+}
+
+// IndexGet returns the value of the field or the method of the given name.
+func (o *StructProxy) IndexGet(index Object) (res Object, err error) {
+	name, ok := ToString(index)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if !o.exposes(name) {
+		return UndefinedValue, nil
+	}
+	if f, ok := o.field(name); ok {
+		fv, err := o.v.Elem().FieldByIndexErr(f.index)
+		if err != nil {
+			return UndefinedValue, nil // field of a nil embedded struct
+		}
+		if fv.Kind() == reflect.Struct {
+			fv = fv.Addr()
+		}
+		if isProxyStruct(fv.Type()) {
+			if fv.IsNil() {
+				return UndefinedValue, nil
+			}
+			return &StructProxy{v: fv, members: o.nested(name)}, nil
+		}
+		c := &reflectConverter{seen: make(map[uintptr]bool)}
+		return c.convert(fv)
+	}
+	if m := o.v.MethodByName(name); m.IsValid() {
+		return &UserFunction{
+			Name: name,
+			Value: func(args ...Object) (Object, error) {
+				return callMethod(m, args)
+			},
+		}, nil
+	}
+	return UndefinedValue, nil
+}
+
+// IndexSet sets the value of the field of the given name.
+func (o *StructProxy) IndexSet(index, value Object) error {
+	name, ok := ToString(index)
+	if !ok {
+		return ErrInvalidIndexType
+	}
+	if o.members != nil && !o.members[name] {
+		return ErrNotIndexAssignable // or only some members of it exposed
+	}
+	f, ok := o.field(name)
+	if !ok {
+		return ErrNotIndexAssignable
+	}
+	fv, ok := fieldByIndexAlloc(o.v.Elem(), f.index)
+	if !ok {
+		return ErrNotIndexAssignable
+	}
+	return decodeValue(name, value, fv)
+}
+
+// Iterate creates an iterator of the exposed fields.
+func (o *StructProxy) Iterate() Iterator {
+	return o.fieldMap().Iterate()
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *StructProxy) CanIterate() bool {
+	return true
+}
+
+// exposes reports whether the member of the given name, or any member of it,
+// is exposed.
+func (o *StructProxy) exposes(name string) bool {
+	if o.members == nil || o.members[name] {
+		return true
+	}
+	for m := range o.members {
+		if strings.HasPrefix(m, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// nested returns the members of the nested struct of the given name that are
+// exposed.
+func (o *StructProxy) nested(name string) map[string]bool {
+	if o.members == nil || o.members[name] {
+		return nil
+	}
+	members := make(map[string]bool)
+	for m := range o.members {
+		if strings.HasPrefix(m, name+".") {
+			members[m[len(name)+1:]] = true
+		}
+	}
+	return members
+}
+
+func (o *StructProxy) field(name string) (structField, bool) {
+	for _, f := range structFields(o.v.Elem().Type()) {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// fieldMap returns a map of the values of the exposed fields.
+func (o *StructProxy) fieldMap() *Map {
+	m := &Map{Value: make(map[string]Object)}
+	for _, f := range structFields(o.v.Elem().Type()) {
+		if !o.exposes(f.name) {
+			continue
+		}
+		if v, err := o.IndexGet(&String{Value: f.name}); err == nil {
+			m.Value[f.name] = v
+		}
+	}
+	return m
+}
+
+// callMethod calls the method with the arguments decoded into its parameter
+// types.
+func callMethod(m reflect.Value, args []Object) (Object, error) {
+	t := m.Type()
+	numIn := t.NumIn()
+	if len(args) != numIn && (!t.IsVariadic() || len(args) < numIn-1) {
+		return nil, ErrWrongNumArguments
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var at reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			at = t.In(numIn - 1).Elem()
+		} else {
+			at = t.In(i)
+		}
+		in[i] = reflect.New(at).Elem()
+		if err := decodeValue("", arg, in[i]); err != nil {
+			var de DecodeError
+			if !errors.As(err, &de) {
+				return nil, err
+			}
+			name := strconv.Itoa(i + 1)
+			if de.Path != "" {
+				name += "." + de.Path
+			}
+			return nil, ErrInvalidArgumentType{
+				Name:     name,
+				Expected: de.Expected,
+				Found:    de.Found,
+			}
+		}
+	}
+
+	out := m.Call(in)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return &Error{Value: &String{Value: err.Error()}}, nil
+		}
+		out = out[:n-1]
+	}
+	results := make([]Object, len(out))
+	for i, r := range out {
+		o, err := FromInterface(r.Interface())
+		if err != nil {
+			return nil, err
+		}
+		results[i] = o
+	}
+	switch len(results) {
+	case 0:
+		return UndefinedValue, nil
+	case 1:
+		return results[0], nil
+	}
+	return &Array{Value: results}, nil
+}
@@ -1,7 +1,13 @@
 package slim
 
 import (
+	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/snple/slim/parser"
@@ -67,22 +73,47 @@ func (vs Vars) Remove(name string) bool {
 	return true
 }
 
+// Scope is a long-lived session of successive compiles and runs, like a
+// REPL. The global variables and the constants of each compiled source are
+// kept by the scope, so that the sources compiled after can use them. It is
+// safe for concurrent use by multiple goroutines.
 type Scope struct {
-	lock            sync.RWMutex
-	symbolTable     *SymbolTable
-	globals         []Object
-	globalIndexes   map[string]int
-	maxAllocs       int64
-	maxConstObjects int
+	lock             sync.RWMutex
+	symbolTable      *SymbolTable
+	constants        []Object
+	globals          []Object
+	globalIndexes    map[string]int
+	importDir        string
+	enableFileImport bool
+	maxStackSize     int
+	maxFrames        int
+	maxGlobals       int
+	maxAllocs        int64
+	maxInsts         int64
+	maxMemory        int64
+	maxStringLen     int
+	maxBytesLen      int
+	maxConstObjects  int
 
 	modules ModuleGetter
 }
 
+// NewScope creates a Scope with the modules and the variables. The variables
+// are defined in the order of their names, so that their indexes don't depend
+// on the iteration order of vars.
 func NewScope(modules ModuleGetter, vars Vars) *Scope {
 	s := &Scope{
 		symbolTable:     NewSymbolTable(),
-		globals:         make([]Object, GlobalsSize),
+		globals:         make([]Object, len(vars)),
+		globalIndexes:   make(map[string]int, len(vars)),
+		maxStackSize:    StackSize,
+		maxFrames:       MaxFrames,
+		maxGlobals:      GlobalsSize,
 		maxAllocs:       -1,
+		maxInsts:        -1,
+		maxMemory:       -1,
+		maxStringLen:    MaxStringLen,
+		maxBytesLen:     MaxBytesLen,
 		maxConstObjects: -1,
 		modules:         modules,
 	}
@@ -91,22 +122,158 @@ func NewScope(modules ModuleGetter, vars Vars) *Scope {
 		s.symbolTable.DefineBuiltin(idx, fn.Name)
 	}
 
-	idx := 0
-	for name, value := range vars {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for idx, name := range names {
 		symbol := s.symbolTable.Define(name)
 		if symbol.Index != idx {
 			panic(fmt.Errorf("wrong symbol index: %d != %d",
 				idx, symbol.Index))
 		}
 
-		s.globals[symbol.Index] = value.value
-
-		idx += 1
+		s.globals[symbol.Index] = vars[name].value
+		s.globalIndexes[name] = symbol.Index
 	}
 
 	return s
 }
 
+// SetImports sets the import modules of the sources compiled after.
+func (s *Scope) SetImports(modules ModuleGetter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.modules = modules
+}
+
+// SetImportDir sets the initial import directory for the module files of the
+// sources compiled after.
+func (s *Scope) SetImportDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.importDir = dir
+	return nil
+}
+
+// EnableFileImport enables or disables module loading from the local files
+// for the sources compiled after. It's disabled by default.
+func (s *Scope) EnableFileImport(enable bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enableFileImport = enable
+}
+
+// Define defines the global variable of the name with the value converted by
+// FromInterface, or sets the value of the variable if it's already defined.
+// It can be called at any time; the variable can be used by the sources
+// compiled after. Define returns an error if the variable exceeds the globals
+// limit.
+func (s *Scope) Define(name string, value interface{}) error {
+	obj, err := FromInterface(value)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if idx, ok := s.globalIndexes[name]; ok {
+		s.globals[idx] = obj
+		return nil
+	}
+
+	numGlobals := s.symbolTable.MaxSymbols() + 1
+	if numGlobals > s.maxGlobals {
+		return fmt.Errorf("exceeding globals limit: %d", numGlobals)
+	}
+	symbol := s.symbolTable.Define(name)
+	if symbol.Index >= len(s.globals) {
+		s.globals = append(s.globals,
+			make([]Object, symbol.Index+1-len(s.globals))...)
+	}
+	s.globals[symbol.Index] = obj
+	s.globalIndexes[name] = symbol.Index
+	return nil
+}
+
+// SetMaxStackSize sets the maximum number of objects on the stack of each
+// run. The stack grows as needed up to this size. Run returns
+// ErrStackOverflow error if it exceeds this limit. It defaults to StackSize,
+// and zero or a negative number sets the default.
+func (s *Scope) SetMaxStackSize(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxStackSize = n
+}
+
+// SetMaxFrames sets the maximum depth of the function calls of each run. Run
+// returns ErrStackOverflow error if it exceeds this limit. It defaults to
+// MaxFrames, and zero or a negative number sets the default.
+func (s *Scope) SetMaxFrames(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxFrames = n
+}
+
+// SetMaxGlobals sets the maximum number of global variables of the scope.
+// Complie returns an error if the compiled source exceeds this limit. It
+// defaults to GlobalsSize.
+func (s *Scope) SetMaxGlobals(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxGlobals = n
+}
+
+// SetMaxInstructions sets the maximum number of instructions executed by each
+// run. Run returns ErrInstructionLimit error if it exceeds this limit.
+func (s *Scope) SetMaxInstructions(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxInsts = n
+}
+
+// SetMaxMemory sets the maximum number of bytes allocated by each run. Run
+// returns ErrMemoryLimit error if it exceeds this limit.
+func (s *Scope) SetMaxMemory(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxMemory = n
+}
+
+// SetMaxStringLen sets the maximum byte-length of the strings created by each
+// run. It defaults to MaxStringLen.
+func (s *Scope) SetMaxStringLen(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxStringLen = n
+}
+
+// SetMaxBytesLen sets the maximum length of the bytes created by each run. It
+// defaults to MaxBytesLen.
+func (s *Scope) SetMaxBytesLen(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxBytesLen = n
+}
+
 func (s *Scope) Complie(name string, src []byte) (*Bytecode, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -126,14 +293,24 @@ func (s *Scope) Complie(name string, src []byte) (*Bytecode, error) {
 		return nil, err
 	}
 
-	// compile
-	c := NewCompiler(srcFile, s.symbolTable, nil, s.modules, nil)
+	// compile with the constants of the previous sources, which are used
+	// by their functions
+	c := NewCompiler(srcFile, s.symbolTable, s.constants, s.modules, nil)
+	c.EnableFileImport(s.enableFileImport)
+	c.SetImportDir(s.importDir)
 	if err := c.Compile(file); err != nil {
 		return nil, err
 	}
 
-	// reduce globals size
-	s.globals = s.globals[:s.symbolTable.MaxSymbols()+1]
+	// check the globals limit
+	numGlobals := s.symbolTable.MaxSymbols()
+	if numGlobals > s.maxGlobals {
+		return nil, fmt.Errorf("exceeding globals limit: %d", numGlobals)
+	}
+	if numGlobals > len(s.globals) {
+		s.globals = append(s.globals,
+			make([]Object, numGlobals-len(s.globals))...)
+	}
 
 	s.globalIndexes = make(map[string]int, len(s.globals))
 	for _, name := range s.symbolTable.Names() {
@@ -143,17 +320,22 @@ func (s *Scope) Complie(name string, src []byte) (*Bytecode, error) {
 		}
 	}
 
-	// remove duplicates from constants
+	// remove duplicates from constants. the constants of the previous
+	// sources are already unique, so they keep their indexes.
 	bytecode := c.Bytecode()
 	bytecode.RemoveDuplicates()
 
-	// check the constant objects limit
+	// check the constant objects limit of the source
 	if s.maxConstObjects >= 0 {
-		cnt := bytecode.CountObjects()
+		cnt := 0
+		for _, c := range bytecode.Constants[len(s.constants):] {
+			cnt += CountObjects(c)
+		}
 		if cnt > s.maxConstObjects {
 			return nil, fmt.Errorf("exceeding constant objects limit: %d", cnt)
 		}
 	}
+	s.constants = bytecode.Constants
 
 	return bytecode, nil
 }
@@ -162,8 +344,7 @@ func (s *Scope) Run(bytecode *Bytecode) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	vm := NewVM(bytecode, s.globals, s.maxAllocs)
-	err := vm.Run()
+	err := s.newVM(bytecode).Run()
 	if err != nil {
 		return err
 	}
@@ -171,6 +352,30 @@ func (s *Scope) Run(bytecode *Bytecode) error {
 	return nil
 }
 
+// RunContext is like Run but includes a context. The run is aborted when ctx
+// is done, and ctx.Err() is returned.
+func (s *Scope) RunContext(ctx context.Context, bytecode *Bytecode) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := s.newVM(bytecode)
+	_, err := runContext(ctx, v, func() (Object, error) {
+		return nil, v.Run()
+	})
+	return err
+}
+
+func (s *Scope) newVM(bytecode *Bytecode) *VM {
+	vm := NewVM(bytecode, s.globals, s.maxAllocs)
+	vm.SetMaxStackSize(s.maxStackSize)
+	vm.SetMaxFrames(s.maxFrames)
+	vm.SetMaxInstructions(s.maxInsts)
+	vm.SetMaxMemory(s.maxMemory)
+	vm.SetMaxStringLen(s.maxStringLen)
+	vm.SetMaxBytesLen(s.maxBytesLen)
+	return vm
+}
+
 func (s *Scope) ComplieAndRun(name string, src []byte) error {
 	bytecode, err := s.Complie(name, src)
 	if err != nil {
@@ -271,3 +476,135 @@ OUT:
 
 	return vars
 }
+
+// scopeState is the state of a Scope written by SaveState.
+type scopeState struct {
+	Version    int
+	Symbols    []scopeSymbol
+	NumSymbols int
+	MaxSymbols int
+	Constants  []Object
+	Objects    []snapshotObject
+	Globals    []scopeGlobal
+}
+
+type scopeSymbol struct {
+	Name  string
+	Index int
+}
+
+// scopeGlobal is a global variable referring to an object of the state.
+type scopeGlobal struct {
+	Index int
+	ID    int
+}
+
+// SaveState writes the symbol table, the constants and the global variables
+// of the scope to the writer, so that the session can be resumed by
+// LoadScope, e.g. in another process. As with VM.Snapshot, compiled functions
+// are written as references to the constants, and builtin modules and their
+// functions as references by name. The variables holding other Go functions
+// or objects that cannot be written are skipped, and are undefined in the
+// loaded scope. The limits and the import options are not written.
+func (s *Scope) SaveState(w io.Writer) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	state := &scopeState{
+		Version:    SnapshotVersion,
+		NumSymbols: s.symbolTable.numDefinition,
+		MaxSymbols: s.symbolTable.MaxSymbols(),
+		Constants:  s.constants,
+	}
+	for name, idx := range s.globalIndexes {
+		state.Symbols = append(state.Symbols, scopeSymbol{
+			Name:  name,
+			Index: idx,
+		})
+	}
+	sort.Slice(state.Symbols, func(i, j int) bool {
+		return state.Symbols[i].Index < state.Symbols[j].Index
+	})
+
+	enc := newObjectEncoder()
+	enc.addConstants(s.constants)
+	for idx, g := range s.globals {
+		if g == nil {
+			continue
+		}
+		n := len(enc.objects)
+		id, err := enc.encode(g)
+		if err != nil {
+			enc.truncate(n)
+			continue
+		}
+		state.Globals = append(state.Globals, scopeGlobal{Index: idx, ID: id})
+	}
+	state.Objects = enc.objects
+
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// LoadScope reads a state written by Scope.SaveState and returns a Scope
+// that continues the session. modules are the import modules of the scope,
+// and are used to restore the builtin modules the state refers to.
+func LoadScope(r io.Reader, modules *ModuleMap) (*Scope, error) {
+	if modules == nil {
+		modules = NewModuleMap()
+	}
+	var state scopeState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	if state.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported state version: %d", state.Version)
+	}
+	if state.NumSymbols < 0 || state.NumSymbols > state.MaxSymbols {
+		return nil, errors.New("invalid state")
+	}
+	for i, c := range state.Constants {
+		fc, err := fixDecodedObject(c, modules)
+		if err != nil {
+			return nil, err
+		}
+		state.Constants[i] = fc
+	}
+
+	dec := &snapshotDecoder{
+		bytecode: &Bytecode{Constants: state.Constants},
+		modules:  modules,
+		objects:  state.Objects,
+	}
+	if err := dec.decodeAll(); err != nil {
+		return nil, err
+	}
+
+	s := NewScope(modules, nil)
+	s.constants = state.Constants
+	s.globals = make([]Object, state.MaxSymbols)
+
+	// define the symbols at their indexes. the indexes of the variables
+	// defined in the blocks are not used by the symbols.
+	t := s.symbolTable
+	for _, sym := range state.Symbols {
+		if sym.Index < t.numDefinition || sym.Index >= state.MaxSymbols {
+			return nil, errors.New("invalid state")
+		}
+		t.numDefinition = sym.Index
+		t.Define(sym.Name)
+		s.globalIndexes[sym.Name] = sym.Index
+	}
+	if t.numDefinition > state.NumSymbols {
+		return nil, errors.New("invalid state")
+	}
+	t.numDefinition = state.NumSymbols
+	t.maxDefinition = state.MaxSymbols
+
+	for _, g := range state.Globals {
+		if g.Index < 0 || g.Index >= len(s.globals) {
+			return nil, errors.New("invalid state")
+		}
+		s.globals[g.Index] = dec.get(g.ID)
+	}
+	return s, nil
+}
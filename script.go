@@ -2,7 +2,9 @@ package slim
 
 import (
 	"context"
+	"encoding/gob"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sync"
 
@@ -14,9 +16,18 @@ type Script struct {
 	variables        map[string]*Variable
 	modules          ModuleGetter
 	input            []byte
+	maxStackSize     int
+	maxFrames        int
+	maxGlobals       int
 	maxAllocs        int64
+	maxInsts         int64
+	maxMemory        int64
+	maxStringLen     int
+	maxBytesLen      int
 	maxConstObjects  int
 	enableFileImport bool
+	enableVMPool     bool
+	noOptimize       bool
 	importDir        string
 }
 
@@ -25,7 +36,14 @@ func NewScript(input []byte) *Script {
 	return &Script{
 		variables:       make(map[string]*Variable),
 		input:           input,
+		maxStackSize:    StackSize,
+		maxFrames:       MaxFrames,
+		maxGlobals:      GlobalsSize,
 		maxAllocs:       -1,
+		maxInsts:        -1,
+		maxMemory:       -1,
+		maxStringLen:    MaxStringLen,
+		maxBytesLen:     MaxBytesLen,
 		maxConstObjects: -1,
 	}
 }
@@ -68,6 +86,29 @@ func (s *Script) SetImportDir(dir string) error {
 	return nil
 }
 
+// SetMaxStackSize sets the maximum number of objects on the stack during the
+// run time. The stack grows as needed up to this size. Compiled script will
+// return ErrStackOverflow error if it exceeds this limit. It defaults to
+// StackSize, and zero or a negative number sets the default.
+func (s *Script) SetMaxStackSize(n int) {
+	s.maxStackSize = n
+}
+
+// SetMaxFrames sets the maximum depth of the function calls during the run
+// time. Compiled script will return ErrStackOverflow error if it exceeds this
+// limit. It defaults to MaxFrames, and zero or a negative number sets the
+// default.
+func (s *Script) SetMaxFrames(n int) {
+	s.maxFrames = n
+}
+
+// SetMaxGlobals sets the maximum number of global variables, including the
+// added variables. Compile returns an error if the script exceeds this
+// limit. It defaults to GlobalsSize.
+func (s *Script) SetMaxGlobals(n int) {
+	s.maxGlobals = n
+}
+
 // SetMaxAllocs sets the maximum number of objects allocations during the run
 // time. Compiled script will return ErrObjectAllocLimit error if it
 // exceeds this limit.
@@ -75,6 +116,33 @@ func (s *Script) SetMaxAllocs(n int64) {
 	s.maxAllocs = n
 }
 
+// SetMaxInstructions sets the maximum number of instructions executed during
+// the run time. Compiled script will return ErrInstructionLimit error if it
+// exceeds this limit.
+func (s *Script) SetMaxInstructions(n int64) {
+	s.maxInsts = n
+}
+
+// SetMaxMemory sets the maximum number of bytes allocated during the run
+// time. The sizes of the strings, bytes, arrays and maps created by the script
+// are approximated. Compiled script will return ErrMemoryLimit error if it
+// exceeds this limit.
+func (s *Script) SetMaxMemory(n int64) {
+	s.maxMemory = n
+}
+
+// SetMaxStringLen sets the maximum byte-length of the strings created during
+// the run time. It defaults to MaxStringLen.
+func (s *Script) SetMaxStringLen(n int) {
+	s.maxStringLen = n
+}
+
+// SetMaxBytesLen sets the maximum length of the bytes created during the run
+// time. It defaults to MaxBytesLen.
+func (s *Script) SetMaxBytesLen(n int) {
+	s.maxBytesLen = n
+}
+
 // SetMaxConstObjects sets the maximum number of objects in the compiled
 // constants.
 func (s *Script) SetMaxConstObjects(n int) {
@@ -87,6 +155,21 @@ func (s *Script) EnableFileImport(enable bool) {
 	s.enableFileImport = enable
 }
 
+// EnableVMPool enables or disables the pooling of the VMs of the compiled
+// script. When enabled, the VMs are reused by the subsequent runs and calls of
+// Compiled and its clones instead of being created by each of them. It's
+// disabled by default.
+func (s *Script) EnableVMPool(enable bool) {
+	s.enableVMPool = enable
+}
+
+// EnableOptimization enables or disables the optimizations of the compiled
+// code, like the folding of the constant expressions. Disabling them can help
+// debugging the compiled code. It's enabled by default.
+func (s *Script) EnableOptimization(enable bool) {
+	s.noOptimize = !enable
+}
+
 // Compile compiles the script with all the defined variables, and, returns
 // Compiled object.
 func (s *Script) Compile() (*Compiled, error) {
@@ -105,13 +188,18 @@ func (s *Script) Compile() (*Compiled, error) {
 
 	c := NewCompiler(srcFile, symbolTable, nil, s.modules, nil)
 	c.EnableFileImport(s.enableFileImport)
+	c.EnableOptimization(!s.noOptimize)
 	c.SetImportDir(s.importDir)
 	if err := c.Compile(file); err != nil {
 		return nil, err
 	}
 
-	// reduce globals size
-	globals = globals[:symbolTable.MaxSymbols()+1]
+	// check the globals limit
+	numGlobals := symbolTable.MaxSymbols()
+	if numGlobals > s.maxGlobals {
+		return nil, fmt.Errorf("exceeding globals limit: %d", numGlobals)
+	}
+	globals = append(globals, make([]Object, numGlobals-len(globals))...)
 
 	// global symbol names to indexes
 	globalIndexes := make(map[string]int, len(globals))
@@ -133,11 +221,24 @@ func (s *Script) Compile() (*Compiled, error) {
 			return nil, fmt.Errorf("exceeding constant objects limit: %d", cnt)
 		}
 	}
+	modules, _ := s.modules.(*ModuleMap)
+	var pool *VMPool
+	if s.enableVMPool {
+		pool = NewVMPool(bytecode)
+	}
 	return &Compiled{
 		globalIndexes: globalIndexes,
 		bytecode:      bytecode,
 		globals:       globals,
+		modules:       modules,
+		pool:          pool,
+		maxStackSize:  s.maxStackSize,
+		maxFrames:     s.maxFrames,
 		maxAllocs:     s.maxAllocs,
+		maxInsts:      s.maxInsts,
+		maxMemory:     s.maxMemory,
+		maxStringLen:  s.maxStringLen,
+		maxBytesLen:   s.maxBytesLen,
 	}, nil
 }
 
@@ -179,7 +280,7 @@ func (s *Script) prepCompile() (
 		symbolTable.DefineBuiltin(idx, fn.Name)
 	}
 
-	globals = make([]Object, GlobalsSize)
+	globals = make([]Object, len(names))
 
 	for idx, name := range names {
 		symbol := symbolTable.Define(name)
@@ -198,17 +299,33 @@ type Compiled struct {
 	globalIndexes map[string]int // global symbol name to index
 	bytecode      *Bytecode
 	globals       []Object
+	modules       *ModuleMap
+	pool          *VMPool // shared by the clones; or nil
+	maxStackSize  int
+	maxFrames     int
 	maxAllocs     int64
+	maxInsts      int64
+	maxMemory     int64
+	maxStringLen  int
+	maxBytesLen   int
+	suspended     *VM    // VM suspended by a function, if any
+	callName      string // name of the function called by Call
 	lock          sync.RWMutex
 }
 
-// Run executes the compiled script in the virtual machine.
+// Run executes the compiled script in the virtual machine. If the execution
+// is suspended by a function calling CallContext.Suspend, Run returns
+// ErrSuspended and the execution can be continued with Resume.
 func (c *Compiled) Run() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
-	return v.Run()
+	v := c.newVM()
+	c.suspended = nil
+	_, err := c.runVM(v, func() (Object, error) {
+		return v.RunCompiled(nil)
+	})
+	return err
 }
 
 // RunContext is like Run but includes a context.
@@ -216,7 +333,245 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
+	v := c.newVM()
+	c.suspended = nil
+	_, err = c.runVMContext(ctx, v, func() (Object, error) {
+		return v.RunCompiled(nil)
+	})
+	return
+}
+
+// Call calls the compiled function stored in the global variable identified
+// by the name with the given arguments, and, returns the value it returned.
+// Arguments are converted using FromInterface. Compiled must be run before
+// so that the global variable holds the function.
+func (c *Compiled) Call(name string, args ...interface{}) (*Variable, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn, objs, err := c.prepCall(name, args)
+	if err != nil {
+		return nil, err
+	}
+	v := c.newVM()
+	c.suspended, c.callName = nil, name
+	ret, err := c.runVM(v, func() (Object, error) {
+		return v.RunCompiled(fn, objs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Variable{
+		name:  name,
+		value: ret,
+	}, nil
+}
+
+// CallContext is like Call but includes a context.
+func (c *Compiled) CallContext(
+	ctx context.Context,
+	name string,
+	args ...interface{},
+) (ret *Variable, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn, objs, err := c.prepCall(name, args)
+	if err != nil {
+		return nil, err
+	}
+	v := c.newVM()
+	c.suspended, c.callName = nil, name
+	retVal, err := c.runVMContext(ctx, v, func() (Object, error) {
+		return v.RunCompiled(fn, objs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Variable{
+		name:  name,
+		value: retVal,
+	}, nil
+}
+
+// SetMaxInstructions sets the maximum number of instructions executed by the
+// subsequent runs and calls. They return ErrInstructionLimit error if they
+// exceed this limit.
+func (c *Compiled) SetMaxInstructions(n int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.maxInsts = n
+}
+
+// Suspended returns true if the last Run or Call was suspended by a function
+// calling CallContext.Suspend and has not been resumed to completion yet.
+func (c *Compiled) Suspended() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.suspended != nil
+}
+
+// Resume continues the suspended execution with value as the result of the
+// function call that suspended it. value is converted using FromInterface.
+// When the execution was started by Call, the returned Variable holds the
+// value returned by the called function; otherwise it holds undefined.
+// ErrSuspended is returned again if the execution is suspended once more.
+func (c *Compiled) Resume(value interface{}) (*Variable, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v, obj, err := c.prepResume(value)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := c.runVM(v, func() (Object, error) {
+		return v.Resume(obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.resumed(ret), nil
+}
+
+// ResumeContext is like Resume but includes a context.
+func (c *Compiled) ResumeContext(
+	ctx context.Context,
+	value interface{},
+) (*Variable, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v, obj, err := c.prepResume(value)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := c.runVMContext(ctx, v, func() (Object, error) {
+		return v.Resume(obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.resumed(ret), nil
+}
+
+// Snapshot writes the state of the suspended execution to the writer. Use
+// Restore on a Compiled of the same script to resume it later, e.g. in
+// another process. See VM.Snapshot for the objects that can be written.
+func (c *Compiled) Snapshot(w io.Writer) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.suspended == nil {
+		return ErrNotSuspended
+	}
+	enc := gob.NewEncoder(w)
+	if err := c.suspended.snapshot(enc); err != nil {
+		return err
+	}
+	return enc.Encode(c.callName)
+}
+
+// Restore reads a snapshot written by Snapshot and replaces the global
+// variables with the ones of the snapshot, so that the execution can be
+// continued with Resume. The Go functions the snapshot refers to are taken
+// from the global variables of the Compiled, i.e. the variables added to the
+// Script.
+func (c *Compiled) Restore(r io.Reader) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dec := gob.NewDecoder(r)
+	v, err := restoreVM(dec, c.bytecode, c.modules, c.globals)
+	if err != nil {
+		return err
+	}
+	var name string
+	if err := dec.Decode(&name); err != nil {
+		return err
+	}
+	c.suspended, c.callName = v, name
+	return nil
+}
+
+func (c *Compiled) newVM() *VM {
+	var v *VM
+	if c.pool != nil {
+		v = c.pool.Get(c.globals, c.maxAllocs)
+	} else {
+		v = NewVM(c.bytecode, c.globals, c.maxAllocs)
+	}
+	v.SetMaxStackSize(c.maxStackSize)
+	v.SetMaxFrames(c.maxFrames)
+	v.SetMaxInstructions(c.maxInsts)
+	v.SetMaxMemory(c.maxMemory)
+	v.SetMaxStringLen(c.maxStringLen)
+	v.SetMaxBytesLen(c.maxBytesLen)
+	return v
+}
+
+func (c *Compiled) prepResume(value interface{}) (*VM, Object, error) {
+	if c.suspended == nil {
+		return nil, nil, ErrNotSuspended
+	}
+	obj, err := FromInterface(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := c.suspended
+	c.suspended = nil
+	return v, obj, nil
+}
+
+func (c *Compiled) resumed(ret Object) *Variable {
+	if ret == nil {
+		ret = UndefinedValue
+	}
+	return &Variable{
+		name:  c.callName,
+		value: ret,
+	}
+}
+
+// releaseVM keeps the VM if the execution is suspended, or puts it back to the
+// pool otherwise.
+func (c *Compiled) releaseVM(v *VM, err error) {
+	if err == ErrSuspended {
+		c.suspended = v
+	} else if c.pool != nil {
+		c.pool.Put(v)
+	}
+}
+
+// runVM runs the VM with the given function and keeps the VM if the
+// execution is suspended.
+func (c *Compiled) runVM(
+	v *VM,
+	run func() (Object, error),
+) (Object, error) {
+	ret, err := run()
+	c.releaseVM(v, err)
+	return ret, err
+}
+
+// runVMContext is like runVM but aborts the VM when ctx is done.
+func (c *Compiled) runVMContext(
+	ctx context.Context,
+	v *VM,
+	run func() (Object, error),
+) (Object, error) {
+	ret, err := runContext(ctx, v, run)
+	c.releaseVM(v, err)
+	return ret, err
+}
+
+// runContext runs the VM by calling run, and aborts the VM when ctx is done.
+func runContext(
+	ctx context.Context,
+	v *VM,
+	run func() (Object, error),
+) (ret Object, err error) {
 	ch := make(chan error, 1)
 	go func() {
 		defer func() {
@@ -231,7 +586,9 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 				}
 			}
 		}()
-		ch <- v.Run()
+		var e error
+		ret, e = run()
+		ch <- e
 	}()
 
 	select {
@@ -244,6 +601,34 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 	return
 }
 
+func (c *Compiled) prepCall(
+	name string,
+	args []interface{},
+) (*CompiledFunction, []Object, error) {
+	idx, ok := c.globalIndexes[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("'%s' is not defined", name)
+	}
+	fn, ok := c.globals[idx].(*CompiledFunction)
+	if !ok {
+		typeName := "undefined"
+		if c.globals[idx] != nil {
+			typeName = c.globals[idx].TypeName()
+		}
+		return nil, nil, fmt.Errorf("'%s' is not a compiled function: %s",
+			name, typeName)
+	}
+	objs := make([]Object, len(args))
+	for i, arg := range args {
+		obj, err := FromInterface(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		objs[i] = obj
+	}
+	return fn, objs, nil
+}
+
 // Clone creates a new copy of Compiled. Cloned copies are safe for concurrent
 // use by multiple goroutines.
 func (c *Compiled) Clone() *Compiled {
@@ -254,7 +639,15 @@ func (c *Compiled) Clone() *Compiled {
 		globalIndexes: c.globalIndexes,
 		bytecode:      c.bytecode,
 		globals:       make([]Object, len(c.globals)),
+		modules:       c.modules,
+		pool:          c.pool,
+		maxStackSize:  c.maxStackSize,
+		maxFrames:     c.maxFrames,
 		maxAllocs:     c.maxAllocs,
+		maxInsts:      c.maxInsts,
+		maxMemory:     c.maxMemory,
+		maxStringLen:  c.maxStringLen,
+		maxBytesLen:   c.maxBytesLen,
 	}
 	// copy global objects
 	for idx, g := range c.globals {
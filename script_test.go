@@ -1,10 +1,13 @@
 package slim_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -100,6 +103,23 @@ a := enum.all([1,2,3], func(_, v) {
 	require.Error(t, err)
 }
 
+func TestScript_EnableOptimization(t *testing.T) {
+	s := slim.NewScript([]byte(`a := 60 * 60 * 24`))
+	s.SetMaxAllocs(0)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 86400, c.Get("a").Int())
+
+	// evaluated at run time
+	s.EnableOptimization(false)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrObjectAllocLimit))
+	s.SetMaxAllocs(2)
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 86400, c.Get("a").Int())
+}
+
 func TestScript_SetMaxConstObjects(t *testing.T) {
 	// one constant '5'
 	s := slim.NewScript([]byte(`a := 5`))
@@ -112,7 +132,7 @@ func TestScript_SetMaxConstObjects(t *testing.T) {
 	require.Equal(t, "exceeding constant objects limit: 1", err.Error())
 
 	// two constants '5' and '1'
-	s = slim.NewScript([]byte(`a := 5 + 1`))
+	s = slim.NewScript([]byte(`a := 5; b := a + 1`))
 	s.SetMaxConstObjects(2) // limit = 2
 	_, err = s.Compile()
 	require.NoError(t, err)
@@ -137,6 +157,335 @@ func TestScript_SetMaxConstObjects(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestScript_SetMaxInstructions(t *testing.T) {
+	// constant, set global and suspend
+	s := slim.NewScript([]byte(`a := 5`))
+	s.SetMaxInstructions(3)
+	_, err := s.Run()
+	require.NoError(t, err)
+	s.SetMaxInstructions(2)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrInstructionLimit))
+
+	// loops that allocate nothing are stopped
+	s = slim.NewScript([]byte(`for {}`))
+	s.SetMaxInstructions(1000)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrInstructionLimit))
+
+	// the limit cannot be caught
+	s = slim.NewScript([]byte(`
+a := 0
+try { for { a++ } } catch e { a = -1 }`))
+	s.SetMaxInstructions(1000)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	err = c.Run()
+	require.True(t, errors.Is(err, slim.ErrInstructionLimit))
+	require.True(t, c.Get("a").Int() > 0)
+
+	// calls are limited by the limit of Compiled
+	s = slim.NewScript([]byte(`f := func(n) { for i := 0; i < n; i++ {} }`))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	_, err = c.Call("f", 1000)
+	require.NoError(t, err)
+	c.SetMaxInstructions(1000)
+	_, err = c.Call("f", 10)
+	require.NoError(t, err)
+	_, err = c.Call("f", 1000)
+	require.True(t, errors.Is(err, slim.ErrInstructionLimit))
+	c.SetMaxInstructions(-1)
+	_, err = c.Call("f", 1000)
+	require.NoError(t, err)
+
+	// scopes
+	scope := slim.NewScope(nil, nil)
+	scope.SetMaxInstructions(1000)
+	require.NoError(t, scope.ComplieAndRun("", []byte(`a := 1`)))
+	err = scope.ComplieAndRun("", []byte(`for {}`))
+	require.True(t, errors.Is(err, slim.ErrInstructionLimit))
+}
+
+func TestScript_SetMaxStackSize(t *testing.T) {
+	src := []byte(`
+f := func(n) { if n == 0 { return 0 }; return 1 + f(n-1) }
+out := f(depth)`)
+
+	// deep recursion needs more frames than the default
+	s := slim.NewScript(src)
+	require.NoError(t, s.Add("depth", 5000))
+	_, err := s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+	s.SetMaxFrames(10000)
+	s.SetMaxStackSize(100000)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 5000, c.Get("out").Int())
+
+	// tiny stacks
+	s = slim.NewScript(src)
+	require.NoError(t, s.Add("depth", 10))
+	s.SetMaxFrames(4)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+	s.SetMaxFrames(16)
+	s.SetMaxStackSize(32)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+	s.SetMaxStackSize(64)
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 10, c.Get("out").Int())
+
+	// array literals grow the stack
+	s = slim.NewScript([]byte(`out := len([` +
+		strings.Repeat("1, ", 499) + `1])`))
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 500, c.Get("out").Int())
+	s.SetMaxStackSize(100)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+
+	// the whole stack is usable: len and its 100 arguments fit in 101 slots
+	s = slim.NewScript([]byte(`out := len([` +
+		strings.Repeat("1, ", 99) + `1])`))
+	s.SetMaxStackSize(101)
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 100, c.Get("out").Int())
+	s.SetMaxStackSize(100)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+
+	// zero or negative limits set the defaults
+	s = slim.NewScript(src)
+	require.NoError(t, s.Add("depth", 10))
+	s.SetMaxFrames(0)
+	s.SetMaxStackSize(0)
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 10, c.Get("out").Int())
+	s.SetMaxFrames(-1)
+	s.SetMaxStackSize(-1)
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 10, c.Get("out").Int())
+
+	// and so do spread arguments
+	s = slim.NewScript([]byte(`
+a := []
+for i := 0; i < 500; i++ { a = append(a, i) }
+out := func(...x) { return len(x) }(a...)`))
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 500, c.Get("out").Int())
+	s.SetMaxStackSize(100)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStackOverflow))
+}
+
+func TestScript_SetMaxGlobals(t *testing.T) {
+	s := slim.NewScript([]byte(`b := a + 1; c := b + 1`))
+	require.NoError(t, s.Add("a", 1))
+	s.SetMaxGlobals(3)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, 3, c.Get("c").Int())
+	s.SetMaxGlobals(2)
+	_, err = s.Compile()
+	require.Error(t, err)
+
+	scope := slim.NewScope(nil, slim.NewVars())
+	scope.SetMaxGlobals(2)
+	require.NoError(t, scope.ComplieAndRun("", []byte(`a := 1; b := a + 1`)))
+	require.Equal(t, int64(2), scope.Get("b").Value())
+	require.Error(t, scope.ComplieAndRun("", []byte(`c := 3`)))
+}
+
+func TestScript_SetMaxMemory(t *testing.T) {
+	run := func(src string, limit int64) error {
+		s := slim.NewScript([]byte(src))
+		s.SetImports(stdlib.GetModuleMap("text"))
+		s.SetMaxMemory(limit)
+		_, err := s.Run()
+		return err
+	}
+
+	// large values are not allocated
+	err := run(`a := bytes(1000000000)`, 1000000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+	err = run(`a := import("text").repeat("x", 1000000000)`, 1000000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+	require.NoError(t, run(`a := bytes(100000)`, 1000000))
+
+	// sizes are accumulated
+	src := `
+s := ""
+for i := 0; i < 100; i++ { s += "0123456789" }`
+	require.NoError(t, run(src, 100000))
+	err = run(src, 10000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+
+	// growing arrays with append are not counted again
+	require.NoError(t, run(`
+a := []
+for i := 0; i < 10000; i++ { a = append(a, i) }`, 2000000))
+
+	// neither are maps, but their new keys are
+	require.NoError(t, run(`
+m := {}
+for i := 0; i < 1000; i++ { m[0] = i }`, 80000))
+	err = run(`
+m := {}
+for i := 0; i < 1000; i++ { m[i] = i }`, 80000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+	err = run(`m := {}; for i := 0; true; i++ { m["k" + string(i)] = true }`,
+		1000000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+
+	// the limit cannot be caught
+	err = run(`try { a := [1, 2, 3] + bytes(1000) } catch e {}`, 1000)
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+
+	// limit per string and bytes
+	s := slim.NewScript([]byte(`b := "56789"; a := "01234" + b`))
+	s.SetMaxStringLen(10)
+	_, err = s.Run()
+	require.NoError(t, err)
+	s.SetMaxStringLen(9)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStringLimit))
+	s = slim.NewScript([]byte(`
+text := import("text")
+a := 0
+try { text.repeat("x", 10) } catch e { a = e }`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	s.SetMaxStringLen(9)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.True(t, strings.Contains(c.Get("a").String(),
+		slim.ErrStringLimit.Error()))
+	s = slim.NewScript([]byte(`a := bytes(5); b := bytes("123456")`))
+	s.SetMaxBytesLen(5)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrBytesLimit))
+
+	// scopes
+	scope := slim.NewScope(nil, nil)
+	scope.SetMaxMemory(1000)
+	require.NoError(t, scope.ComplieAndRun("", []byte(`a := [1, 2, 3]`)))
+	err = scope.ComplieAndRun("", []byte(`b := bytes(1000)`))
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+}
+
+func TestScope(t *testing.T) {
+	// variables are defined in the order of their names
+	vars := slim.NewVars()
+	require.NoError(t, vars.SetAny("b", 2))
+	require.NoError(t, vars.SetAny("a", 1))
+	scope := slim.NewScope(nil, vars)
+	require.Equal(t, int64(1), scope.Get("a").Value())
+	b, err := scope.Complie("", []byte(`c := a`))
+	require.NoError(t, err)
+	require.Equal(t, "0000 GETG    0    ", b.FormatInstructions()[0])
+
+	// functions and their constants are kept by the scope
+	require.NoError(t, scope.ComplieAndRun("", []byte(`
+f := func(x) { return "hello " + x }`)))
+	require.NoError(t, scope.ComplieAndRun("", []byte(`s := f("world")`)))
+	require.Equal(t, "hello world", scope.Get("s").String())
+
+	// variables defined at any time
+	require.NoError(t, scope.Define("d", 4))
+	require.NoError(t, scope.Define("a", 10))
+	require.NoError(t, scope.ComplieAndRun("", []byte(`e := a + d`)))
+	require.Equal(t, int64(14), scope.Get("e").Value())
+	scope.SetMaxGlobals(6)
+	require.Error(t, scope.Define("g", 7))
+
+	// runs with a context
+	scope = slim.NewScope(nil, nil)
+	require.NoError(t, scope.ComplieAndRun("", []byte(`a := 1`)))
+	b, err = scope.Complie("", []byte(`for { a++ }`))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = scope.RunContext(ctx, b)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	b, err = scope.Complie("", []byte(`a = 2`))
+	require.NoError(t, err)
+	require.NoError(t, scope.RunContext(context.Background(), b))
+	require.Equal(t, int64(2), scope.Get("a").Value())
+
+	// file imports
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mod.slim"),
+		[]byte(`export 42`), 0644))
+	scope = slim.NewScope(nil, nil)
+	_, err = scope.Complie("", []byte(`a := import("./mod")`))
+	require.Error(t, err)
+	scope.EnableFileImport(true)
+	require.NoError(t, scope.SetImportDir(dir))
+	require.NoError(t, scope.ComplieAndRun("", []byte(`b := import("./mod")`)))
+	require.Equal(t, int64(42), scope.Get("b").Value())
+}
+
+func TestScope_SaveState(t *testing.T) {
+	mods := slim.NewModuleMap()
+	mods.AddBuiltinModule("mod1", map[string]slim.Object{
+		"double": &slim.UserFunction{
+			Value: func(args ...slim.Object) (slim.Object, error) {
+				arg0, _ := slim.ToInt64(args[0])
+				return &slim.Int{Value: arg0 * 2}, nil
+			},
+		},
+	})
+	scope := slim.NewScope(mods, nil)
+	require.NoError(t, scope.Define("host", &slim.UserFunction{
+		Value: func(args ...slim.Object) (slim.Object, error) {
+			return slim.UndefinedValue, nil
+		},
+	}))
+	require.NoError(t, scope.ComplieAndRun("", []byte(`
+mod1 := import("mod1")
+double := mod1.double
+counter := func() { n := 0; return func() { n++; return n } }()
+counter()
+h := undefined
+if true { x := 5; h = func() { return x } }
+data := {a: [1, 2], b: "s"}
+alias := data.a`)))
+
+	var buf bytes.Buffer
+	require.NoError(t, scope.SaveState(&buf))
+	_, err := slim.LoadScope(bytes.NewReader(buf.Bytes()), nil)
+	require.Error(t, err) // module mod1 not found
+
+	loaded, err := slim.LoadScope(&buf, mods)
+	require.NoError(t, err)
+	require.False(t, loaded.IsDefined("host"))
+	require.NoError(t, loaded.ComplieAndRun("", []byte(`
+counter()
+c := counter()
+data.a[0] = 9
+a := alias[0]
+y := h()
+z := double(mod1.double(2))
+host = 1`)))
+	require.Equal(t, int64(3), loaded.Get("c").Value())
+	require.Equal(t, int64(9), loaded.Get("a").Value())
+	require.Equal(t, int64(5), loaded.Get("y").Value())
+	require.Equal(t, int64(8), loaded.Get("z").Value())
+	require.Equal(t, "s", loaded.Get("data").Map()["b"])
+	require.Equal(t, int64(1), loaded.Get("host").Value())
+
+	// the original scope is not changed
+	require.Equal(t, int64(1), scope.Get("alias").Array()[0])
+}
+
 func TestScriptConcurrency(t *testing.T) {
 	solve := func(a, b, c int) (d, e int) {
 		a += 2
@@ -366,6 +715,93 @@ func TestScriptSourceModule(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestScript_EnableVMPool(t *testing.T) {
+	s := slim.NewScript([]byte(`
+f := func(n) {
+	if n == 0 { return 1 + "bottom" }
+	try { return f(n - 1) } catch e { return e + 1 }
+}
+sum := func(n) { t := 0; for i := 1; i <= n; i++ { t += i }; return t }
+out := 0
+if fail { f(50) } else { out = sum(x) }`))
+	require.NoError(t, s.Add("fail", false))
+	require.NoError(t, s.Add("x", 0))
+	s.EnableVMPool(true)
+	c, err := s.Compile()
+	require.NoError(t, err)
+
+	// runs failing in the middle of handlers and deep frames leave no state
+	// to the next runs
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Set("fail", i%2 == 0))
+		require.NoError(t, c.Set("x", i))
+		err := c.Run()
+		if i%2 == 0 {
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(),
+				"invalid operation: error + int"))
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, i*(i+1)/2, c.Get("out").Int())
+	}
+
+	// aborted runs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.CallContext(ctx, "sum", 1000000)
+	require.Equal(t, context.Canceled, err)
+	v, err := c.Call("sum", 10)
+	require.NoError(t, err)
+	require.Equal(t, 55, v.Int())
+
+	// the clones share the pool and keep their own globals
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		clone := c.Clone()
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = clone.Set("fail", false)
+				_ = clone.Set("x", n)
+				if err := clone.Run(); err != nil {
+					panic(err)
+				}
+				if clone.Get("out").Int() != n*(n+1)/2 {
+					panic(fmt.Errorf("wrong result: %d",
+						clone.Get("out").Int()))
+				}
+			}
+		}(i * 10)
+	}
+	wg.Wait()
+
+	// suspended VMs are not reused until they end
+	s = slim.NewScript([]byte(`out := await() + 1`))
+	require.NoError(t, s.Add("await", &slim.ContextFunction{
+		Name: "await",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			return nil, ctx.Suspend()
+		},
+	}))
+	s.EnableVMPool(true)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.Equal(t, slim.ErrSuspended, c.Run())
+	clone := c.Clone()
+	require.Equal(t, slim.ErrSuspended, clone.Run())
+	_, err = clone.Resume(2)
+	require.NoError(t, err)
+	_, err = c.Resume(1)
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Get("out").Int())
+	require.Equal(t, 3, clone.Get("out").Int())
+}
+
 func BenchmarkArrayIndex(b *testing.B) {
 	bench(b.N, `a := [1, 2, 3, 4, 5, 6, 7, 8, 9];
         for i := 0; i < 1000; i++ {
@@ -382,6 +818,61 @@ func BenchmarkArrayIndexCompare(b *testing.B) {
     `)
 }
 
+func BenchmarkModuleSelector(b *testing.B) {
+	mod := &slim.BuiltinModule{Attrs: map[string]slim.Object{
+		"id": &slim.UserFunction{
+			Value: func(args ...slim.Object) (slim.Object, error) {
+				return args[0], nil
+			},
+		},
+	}}
+	modules := slim.NewModuleMap()
+	modules.Add("mod", mod)
+	s := slim.NewScript([]byte(`m := import("mod")
+        for i := 0; i < 1000; i++ {
+            m.id(i)
+        }
+    `))
+	s.SetImports(modules)
+	c, err := s.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledRun(b *testing.B) {
+	benchRun(b, false)
+}
+
+func BenchmarkCompiledRunPooled(b *testing.B) {
+	benchRun(b, true)
+}
+
+func benchRun(b *testing.B, pooled bool) {
+	s := slim.NewScript([]byte(`out := a * 2 + b > 10 && c != "x"`))
+	_ = s.Add("a", 3)
+	_ = s.Add("b", 5)
+	_ = s.Add("c", "y")
+	s.EnableVMPool(pooled)
+	c, err := s.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func bench(n int, input string) {
 	s := slim.NewScript([]byte(input))
 	c, err := s.Compile()
@@ -478,6 +969,21 @@ func TestCompiled_RunContext(t *testing.T) {
 	defer cancel()
 	err = c.RunContext(ctx)
 	require.Equal(t, context.DeadlineExceeded, err)
+
+	// timeout inside a callback
+	c = compile(t, `call(func() { for true {} })`, M{
+		"call": slim.CallableContextFunc(func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			return ctx.Invoke(args[0])
+		}),
+	})
+	ctx, cancel = context.WithTimeout(context.Background(),
+		1*time.Millisecond)
+	defer cancel()
+	err = c.RunContext(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
 }
 
 func TestCompiled_CustomObject(t *testing.T) {
@@ -665,3 +1171,164 @@ data["b"] = 2
 	require.Equal(t, 1001, clone.Get("count").Int())
 	require.Equal(t, 2, len(clone.Get("data").Map()))
 }
+
+func TestCompiled_Call(t *testing.T) {
+	c := compile(t, `
+count := 0
+on_event := func(name, n) {
+	count += n
+	return name + ":" + string(count)
+}
+sum := func(...args) {
+	s := 0
+	for a in args { s += a }
+	return s
+}
+fail := func() { return {} + 1 }
+a := 5
+`, nil)
+
+	// functions are defined by running the script
+	_, err := c.Call("on_event", "x", 1)
+	require.Error(t, err)
+	compiledRun(t, c)
+
+	v, err := c.Call("on_event", "x", 1)
+	require.NoError(t, err)
+	require.Equal(t, "x:1", v.Value())
+	v, err = c.Call("on_event", "y", 2)
+	require.NoError(t, err)
+	require.Equal(t, "y:3", v.Value())
+	compiledGet(t, c, "count", int64(3))
+
+	v, err = c.Call("sum", 1, 2, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), v.Value())
+
+	_, err = c.Call("on_event", "x")
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(),
+		"wrong number of arguments: want=2, got=1"))
+
+	_, err = c.Call("fail")
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "invalid operation"))
+
+	_, err = c.Call("a")
+	require.Error(t, err)
+	_, err = c.Call("b")
+	require.Error(t, err)
+
+	// timeout
+	c = compile(t, `f := func() { for true {} }`, nil)
+	compiledRun(t, c)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		1*time.Millisecond)
+	defer cancel()
+	_, err = c.CallContext(ctx, "f")
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestCompiled_Suspend(t *testing.T) {
+	var requested []int
+	await := &slim.ContextFunction{
+		Name: "await_approval",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			id, _ := slim.ToInt(args[0])
+			requested = append(requested, id)
+			return nil, ctx.Suspend()
+		},
+	}
+	c := compile(t, `
+log := []
+approve := func(id) {
+	for {
+		ok := await_approval(id)
+		log = append(log, ok)
+		if ok { return id * ok }
+	}
+}
+out := 0
+try {
+	out = approve(1) + approve(2)
+} catch e {
+	out = e
+}
+`, M{"await_approval": await})
+
+	err := c.Run()
+	require.Equal(t, slim.ErrSuspended, err)
+	require.True(t, c.Suspended())
+	require.Equal(t, []int{1}, requested)
+
+	// the script continues where it left off, with the resumed value
+	_, err = c.Resume(false)
+	require.Equal(t, slim.ErrSuspended, err)
+	_, err = c.Resume(10)
+	require.Equal(t, slim.ErrSuspended, err)
+	require.Equal(t, []int{1, 1, 2}, requested)
+	v, err := c.Resume(10)
+	require.NoError(t, err)
+	require.Nil(t, v.Value())
+	require.False(t, c.Suspended())
+	compiledGet(t, c, "out", int64(30))
+	require.Equal(t, "[false, 10, 10]", c.Get("log").String())
+
+	_, err = c.Resume(10)
+	require.Equal(t, slim.ErrNotSuspended, err)
+
+	// errors after resuming are caught by the handlers of the script
+	err = c.Run()
+	require.Equal(t, slim.ErrSuspended, err)
+	_, err = c.Resume(10)
+	require.Equal(t, slim.ErrSuspended, err)
+	_, err = c.Resume("x")
+	require.NoError(t, err)
+	require.True(t, strings.Contains(c.Get("out").String(),
+		"invalid operation: int * string"))
+
+	// functions called by Call return their value when resumed
+	_, err = c.Call("approve", 7)
+	require.Equal(t, slim.ErrSuspended, err)
+	v, err = c.ResumeContext(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(21), v.Value())
+
+	// a run started again discards the suspended execution
+	err = c.Run()
+	require.Equal(t, slim.ErrSuspended, err)
+	err = c.RunContext(context.Background())
+	require.Equal(t, slim.ErrSuspended, err)
+	_, err = c.Resume(false)
+	require.Equal(t, slim.ErrSuspended, err)
+	require.Equal(t, []int{1, 1, 2, 1, 2, 7, 1, 1, 1}, requested)
+
+	// callbacks cannot suspend the VM
+	s := slim.NewScript([]byte(`
+fn := import("fn")
+out := fn.map([1], func(x) { return await_approval(x) })`))
+	require.NoError(t, s.Add("await_approval", await))
+	mapFn := &slim.ContextFunction{
+		Name: "map",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			return ctx.Invoke(args[1], args[0].(*slim.Array).Value[0])
+		},
+	}
+	mods := slim.NewModuleMap()
+	mods.AddBuiltinModule("fn", map[string]slim.Object{"map": mapFn})
+	s.SetImports(mods)
+	_, err = s.Run()
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(),
+		slim.ErrCannotSuspend.Error()))
+
+	// suspending outside VM
+	_, err = await.Call(&slim.Int{Value: 1})
+	require.True(t, errors.Is(err, slim.ErrNoRunningVM))
+}
@@ -2,7 +2,6 @@ package slim
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 	"time"
 )
@@ -18,13 +17,14 @@ var (
 )
 
 const (
-	// GlobalsSize is the maximum number of global variables for a VM.
+	// GlobalsSize is the default maximum number of global variables for a
+	// VM.
 	GlobalsSize = 1024
 
-	// StackSize is the maximum stack size for a VM.
+	// StackSize is the default maximum stack size for a VM.
 	StackSize = 2048
 
-	// MaxFrames is the maximum number of function frames for a VM.
+	// MaxFrames is the default maximum number of function frames for a VM.
 	MaxFrames = 1024
 
 	// SourceFileExtDefault is the default extension for source files.
@@ -34,6 +34,13 @@ const (
 // CallableFunc is a function signature for the callable functions.
 type CallableFunc = func(args ...Object) (ret Object, err error)
 
+// CallableContextFunc is a function signature for the callable functions that
+// need to call back into the VM using the CallContext.
+type CallableContextFunc = func(
+	ctx *CallContext,
+	args ...Object,
+) (ret Object, err error)
+
 // CountObjects returns the number of objects that a given object o contains.
 // For scalar value types, it will always be 1. For compound value types,
 // this will include its elements and all of their elements recursively.
@@ -62,6 +69,40 @@ func CountObjects(o Object) (c int) {
 	return
 }
 
+// approximate sizes used by the memory limit of VM
+const (
+	objectHeaderSize = 32 // object and its value header
+	objectSlotSize   = 16 // element of an array or a map
+)
+
+// objectSize returns the approximate number of bytes allocated for the
+// object, not including the objects it refers to.
+func objectSize(o Object) int64 {
+	switch o := o.(type) {
+	case *String:
+		return objectHeaderSize + int64(len(o.Value))
+	case *Bytes:
+		return objectHeaderSize + int64(len(o.Value))
+	case *Array:
+		return objectHeaderSize + int64(len(o.Value))*objectSlotSize
+	case *ImmutableArray:
+		return objectHeaderSize + int64(len(o.Value))*objectSlotSize
+	case *Map:
+		return objectHeaderSize + mapSize(o.Value)
+	case *ImmutableMap:
+		return objectHeaderSize + mapSize(o.Value)
+	}
+	return objectHeaderSize
+}
+
+func mapSize(m map[string]Object) int64 {
+	n := int64(len(m)) * objectSlotSize * 2
+	for k := range m {
+		n += int64(len(k))
+	}
+	return n
+}
+
 // ToString will try to convert object o to string value.
 func ToString(o Object) (v string, ok bool) {
 	if o == UndefinedValue {
@@ -242,7 +283,13 @@ func ToInterface(o Object) (res interface{}) {
 	return
 }
 
-// FromInterface will attempt to convert an interface{} v to a slim Object
+// FromInterface will attempt to convert an interface{} v to a slim Object.
+// The values of other types than the ones listed below are converted using
+// reflection: the integers and floats of any size to Int and Float, the
+// durations to Int, the structs and the maps with string or integer keys to
+// Map, the slices and arrays to Array, and the pointers to the values they
+// point to. The fields of a struct are named by their "slim" tags, or by
+// their "json" tags, and support the "-" name and the "omitempty" option.
 func FromInterface(v interface{}) (Object, error) {
 	switch v := v.(type) {
 	case nil:
@@ -304,6 +351,8 @@ func FromInterface(v interface{}) (Object, error) {
 		return v, nil
 	case CallableFunc:
 		return &UserFunction{Value: v}, nil
+	case CallableContextFunc:
+		return &ContextFunction{Value: v}, nil
 	}
-	return nil, fmt.Errorf("cannot convert to object: %T", v)
+	return fromReflect(v)
 }
@@ -42,6 +42,17 @@ func TestInstructions_String(t *testing.T) {
 0002 GETL    1    
 0004 CONST   2    
 0007 CONST   65535`)
+
+	// wide operands
+	assertInstructionString(t,
+		[][]byte{
+			slim.MakeInstruction(parser.OpConstant, 65536),
+			slim.MakeInstruction(parser.OpGetLocal, 256),
+			slim.MakeInstruction(parser.OpClosure, 1, 300),
+		},
+		`0000 CONST.W 65536
+0006 GETL.W  256  
+0010 CLOSURE.W 1     300  `)
 }
 
 func TestMakeInstruction(t *testing.T) {
@@ -54,6 +65,14 @@ func TestMakeInstruction(t *testing.T) {
 	makeInstruction(t, []byte{parser.OpPop}, parser.OpPop)
 	makeInstruction(t, []byte{parser.OpTrue}, parser.OpTrue)
 	makeInstruction(t, []byte{parser.OpFalse}, parser.OpFalse)
+
+	// operands that overflow are widened
+	makeInstruction(t, []byte{parser.OpWide, parser.OpConstant, 0, 1, 0, 0},
+		parser.OpConstant, 65536)
+	makeInstruction(t, []byte{parser.OpWide, parser.OpSetLocal, 1, 0},
+		parser.OpSetLocal, 256)
+	makeInstruction(t, []byte{parser.OpWide, parser.OpSetSelFree, 0, 1, 1, 0},
+		parser.OpSetSelFree, 1, 256)
 }
 
 func TestNumObjects(t *testing.T) {
@@ -135,3 +154,107 @@ func makeInstruction(
 	inst := slim.MakeInstruction(opcode, operands...)
 	require.Equal(t, expected, inst)
 }
+
+func TestFromInterface(t *testing.T) {
+	type Base struct {
+		ID int `json:"id"`
+	}
+	type Item struct {
+		Name  string  `slim:"name"`
+		Price float32 `json:"price,omitempty"`
+	}
+	type Order struct {
+		Base
+		Items    []Item           `slim:"items"`
+		Tags     map[string]int   `slim:"tags"`
+		Customer *Item            `slim:"customer"`
+		Timeout  time.Duration    `slim:"timeout"`
+		Meta     map[int]uint16   `slim:"meta"`
+		Notes    []string         `json:"-"`
+		Extra    interface{}      `slim:"extra"`
+		Raw      []byte           `slim:"raw"`
+		Nested   map[string]*Item `slim:"nested"`
+		Codes    [2]int8          `slim:"codes"`
+		secret   string
+		Any      map[string]string `slim:"any,omitempty"`
+	}
+
+	o, err := slim.FromInterface(&Order{
+		Base:    Base{ID: 7},
+		Items:   []Item{{Name: "a", Price: 1.5}, {Name: "b"}},
+		Tags:    map[string]int{"x": 1},
+		Timeout: 2 * time.Second,
+		Meta:    map[int]uint16{3: 4},
+		Notes:   []string{"n"},
+		Extra:   int32(5),
+		Raw:     []byte("raw"),
+		Nested:  map[string]*Item{"n": nil},
+		Codes:   [2]int8{1, -1},
+		secret:  "s",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &slim.Map{Value: map[string]slim.Object{
+		"id": &slim.Int{Value: 7},
+		"items": &slim.Array{Value: []slim.Object{
+			&slim.Map{Value: map[string]slim.Object{
+				"name":  &slim.String{Value: "a"},
+				"price": &slim.Float{Value: 1.5},
+			}},
+			&slim.Map{Value: map[string]slim.Object{
+				"name": &slim.String{Value: "b"},
+			}},
+		}},
+		"tags": &slim.Map{Value: map[string]slim.Object{
+			"x": &slim.Int{Value: 1},
+		}},
+		"customer": slim.UndefinedValue,
+		"timeout":  &slim.Int{Value: int64(2 * time.Second)},
+		"meta": &slim.Map{Value: map[string]slim.Object{
+			"3": &slim.Int{Value: 4},
+		}},
+		"extra": &slim.Int{Value: 5},
+		"raw":   &slim.Bytes{Value: []byte("raw")},
+		"nested": &slim.Map{Value: map[string]slim.Object{
+			"n": slim.UndefinedValue,
+		}},
+		"codes": &slim.Array{Value: []slim.Object{
+			&slim.Int{Value: 1}, &slim.Int{Value: -1},
+		}},
+	}}, o)
+
+	o, err = slim.FromInterface([]string{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, &slim.Array{Value: []slim.Object{
+		&slim.String{Value: "a"}, &slim.String{Value: "b"},
+	}}, o)
+
+	o, err = slim.FromInterface(uint64(42))
+	require.NoError(t, err)
+	require.Equal(t, &slim.Int{Value: 42}, o)
+	_, err = slim.FromInterface(uint64(1 << 63))
+	require.Error(t, err)
+
+	// objects and Go functions in the typed values
+	o, err = slim.FromInterface(map[string]slim.CallableFunc{
+		"f": func(args ...slim.Object) (slim.Object, error) {
+			return slim.TrueValue, nil
+		},
+	})
+	require.NoError(t, err)
+	ret, err := o.(*slim.Map).Value["f"].Call()
+	require.NoError(t, err)
+	require.Equal(t, slim.TrueValue, ret)
+
+	// cyclic values and unsupported types
+	type Node struct {
+		Next *Node
+	}
+	n := &Node{}
+	n.Next = n
+	_, err = slim.FromInterface(n)
+	require.Error(t, err)
+	_, err = slim.FromInterface(make(chan int))
+	require.Error(t, err)
+	_, err = slim.FromInterface(map[float64]int{1: 1})
+	require.Error(t, err)
+}
@@ -0,0 +1,1027 @@
+package slim
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// VM.Snapshot. Snapshots of other versions cannot be restored.
+const SnapshotVersion = 1
+
+// ErrSnapshotMismatch is an error where a snapshot is restored with a
+// bytecode other than the one it was taken from.
+var ErrSnapshotMismatch = errors.New("snapshot does not match bytecode")
+
+type snapshotKind uint8
+
+const (
+	snapshotValue snapshotKind = iota // gob encoded object
+	snapshotUndefined
+	snapshotBool
+	snapshotConstant // constant of the bytecode
+	snapshotBuiltin  // builtin function
+	snapshotGlobal   // host object set in a global variable
+	snapshotModule   // builtin module
+	snapshotAttr     // attribute of a constant or builtin module
+	snapshotArray
+	snapshotImmutableArray
+	snapshotMap
+	snapshotImmutableMap
+	snapshotRawMap // map shared by maps and map iterators
+	snapshotError
+	snapshotFunction
+	snapshotPtr
+	snapshotArrayIterator
+	snapshotBytesIterator
+	snapshotStringIterator
+	snapshotMapIterator
+	snapshotGenerator
+)
+
+// snapshotHeader is written before the state of the VM.
+type snapshotHeader struct {
+	Version  int
+	Checksum uint32
+}
+
+// snapshotObject is an object of the VM state. Objects refer to each other by
+// their index in the snapshot plus 1; 0 is nil.
+type snapshotObject struct {
+	Kind     snapshotKind
+	Value    Object
+	Index    int
+	Refs     []int
+	Keys     []string
+	Ints     []int
+	Bytes    []byte
+	Runes    []rune
+	Handlers []snapshotHandler
+}
+
+type snapshotHandler struct {
+	FramesIndex int
+	SP          int
+	CatchIP     int
+}
+
+type snapshotFrame struct {
+	Fn          int
+	FreeVars    []int
+	IP          int
+	BasePointer int
+	Gen         int
+	NoResult    bool
+}
+
+// snapshotState is the state of a suspended VM.
+type snapshotState struct {
+	Objects     []snapshotObject
+	Stack       []int
+	Globals     []int
+	Frames      []snapshotFrame
+	Handlers    []snapshotHandler
+	IP          int
+	HandlerBase int
+	EntryFn     bool
+	MaxStack    int
+	MaxFrames   int
+	MaxAllocs   int64
+	Allocs      int64
+	MaxInsts    int64
+	Insts       int64
+	MaxMemory   int64
+	Memory      int64
+	MaxStrLen   int
+	MaxBytesLen int
+}
+
+// Snapshot writes the state of the suspended VM to the writer, so that the
+// execution can be resumed by another VM created by RestoreVM, e.g. in
+// another process. Compiled functions are written as references to the
+// bytecode, and Go functions as references to builtin functions, builtin
+// modules or the global variables holding them. Other objects must be
+// registered with gob.Register to be written.
+func (v *VM) Snapshot(w io.Writer) error {
+	return v.snapshot(gob.NewEncoder(w))
+}
+
+func (v *VM) snapshot(enc *gob.Encoder) error {
+	if !v.suspended {
+		return ErrNotSuspended
+	}
+	s := newSnapshotEncoder(v)
+	state := &snapshotState{
+		IP:          v.ip,
+		HandlerBase: v.handlerBase,
+		EntryFn:     v.entryFn,
+		MaxStack:    v.maxStack,
+		MaxFrames:   v.maxFrames,
+		MaxAllocs:   v.maxAllocs,
+		Allocs:      v.allocs,
+		MaxInsts:    v.maxInsts,
+		Insts:       v.insts,
+		MaxMemory:   v.maxMemory,
+		Memory:      v.memory,
+		MaxStrLen:   v.maxStrLen,
+		MaxBytesLen: v.maxBytesLen,
+	}
+	var err error
+	if state.Globals, err = s.encodeObjects(v.globals); err != nil {
+		return err
+	}
+	if state.Stack, err = s.encodeObjects(v.stack[:v.sp]); err != nil {
+		return err
+	}
+	for i := 0; i < v.framesIndex; i++ {
+		f := &v.frames[i]
+		sf := snapshotFrame{
+			IP:          f.ip,
+			BasePointer: f.basePointer,
+			NoResult:    f.noResult,
+		}
+		if sf.Fn, err = s.encode(f.fn); err != nil {
+			return err
+		}
+		for _, p := range f.freeVars {
+			id, err := s.encode(p)
+			if err != nil {
+				return err
+			}
+			sf.FreeVars = append(sf.FreeVars, id)
+		}
+		if f.gen != nil {
+			if sf.Gen, err = s.encode(f.gen); err != nil {
+				return err
+			}
+		}
+		state.Frames = append(state.Frames, sf)
+	}
+	state.Handlers = encodeHandlers(v.handlers)
+	state.Objects = s.objects
+
+	header := &snapshotHeader{
+		Version:  SnapshotVersion,
+		Checksum: bytecodeChecksum(v.constants, v.mainFunc),
+	}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	return enc.Encode(state)
+}
+
+// RestoreVM reads a snapshot written by VM.Snapshot and returns a suspended
+// VM that continues the execution when resumed. bytecode must be the bytecode
+// the snapshot was taken from, and modules are used to restore the builtin
+// modules the snapshot refers to.
+func RestoreVM(
+	r io.Reader,
+	bytecode *Bytecode,
+	modules *ModuleMap,
+) (*VM, error) {
+	return restoreVM(gob.NewDecoder(r), bytecode, modules, nil)
+}
+
+// restoreVM restores a VM from the decoder. If globals is not nil, the host
+// objects the snapshot refers to are taken from it, and the restored global
+// variables are written to it.
+func restoreVM(
+	dec *gob.Decoder,
+	bytecode *Bytecode,
+	modules *ModuleMap,
+	globals []Object,
+) (*VM, error) {
+	if modules == nil {
+		modules = NewModuleMap()
+	}
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d",
+			header.Version)
+	}
+	if header.Checksum != bytecodeChecksum(bytecode.Constants,
+		bytecode.MainFunction) {
+		return nil, ErrSnapshotMismatch
+	}
+	var state snapshotState
+	if err := dec.Decode(&state); err != nil {
+		return nil, err
+	}
+	if len(state.Stack) > state.MaxStack ||
+		len(state.Frames) > state.MaxFrames || len(state.Frames) == 0 {
+		return nil, errors.New("invalid snapshot")
+	}
+
+	s := &snapshotDecoder{
+		bytecode: bytecode,
+		modules:  modules,
+		globals:  globals,
+		objects:  state.Objects,
+	}
+	if err := s.decodeAll(); err != nil {
+		return nil, err
+	}
+
+	if globals == nil || len(globals) < len(state.Globals) {
+		globals = make([]Object, len(state.Globals))
+	}
+	v := NewVM(bytecode, globals, state.MaxAllocs)
+	for i, id := range state.Globals {
+		globals[i] = s.get(id)
+	}
+	v.SetMaxStackSize(state.MaxStack)
+	v.SetMaxFrames(state.MaxFrames)
+	if err := v.growStack(len(state.Stack)); err != nil {
+		return nil, err
+	}
+	if len(v.frames) < len(state.Frames) {
+		v.frames = make([]frame, len(state.Frames))
+	}
+	for i, id := range state.Stack {
+		v.stack[i] = s.get(id)
+	}
+	v.sp = len(state.Stack)
+	for i, sf := range state.Frames {
+		fn, ok := s.get(sf.Fn).(*CompiledFunction)
+		if !ok {
+			return nil, errors.New("invalid snapshot")
+		}
+		f := &v.frames[i]
+		f.fn = fn
+		f.freeVars = nil
+		for _, id := range sf.FreeVars {
+			p, ok := s.get(id).(*ObjectPtr)
+			if !ok {
+				return nil, errors.New("invalid snapshot")
+			}
+			f.freeVars = append(f.freeVars, p)
+		}
+		f.ip = sf.IP
+		f.basePointer = sf.BasePointer
+		f.noResult = sf.NoResult
+		f.gen, _ = s.get(sf.Gen).(*Generator)
+	}
+	v.framesIndex = len(state.Frames)
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = state.IP
+	v.handlers = decodeHandlers(state.Handlers)
+	v.handlerBase = state.HandlerBase
+	v.entryFn = state.EntryFn
+	v.allocs = state.Allocs
+	v.maxInsts = state.MaxInsts
+	v.insts = state.Insts
+	v.maxMemory = state.MaxMemory
+	v.memory = state.Memory
+	v.maxStrLen = state.MaxStrLen
+	v.maxBytesLen = state.MaxBytesLen
+	if !v.validState() {
+		return nil, errors.New("invalid snapshot")
+	}
+	v.suspended = true
+	return v, nil
+}
+
+// validState reports whether the instruction pointers, the stack pointers
+// and the frame indexes of the restored VM are within bounds, so that a
+// corrupted snapshot is rejected instead of making the VM panic.
+func (v *VM) validState() bool {
+	if v.ip < -1 || v.ip >= len(v.curInsts) {
+		return false
+	}
+	sp := v.sp
+	for i := v.framesIndex - 1; i >= 0; i-- {
+		f := &v.frames[i]
+		if f.basePointer < 0 || f.basePointer > sp {
+			return false
+		}
+		// the ip of the current frame is v.ip
+		if i < v.framesIndex-1 &&
+			(f.ip < -1 || f.ip >= len(f.fn.Instructions)) {
+			return false
+		}
+		sp = f.basePointer
+	}
+
+	if v.handlerBase < 0 || v.handlerBase > len(v.handlers) {
+		return false
+	}
+	for _, h := range v.handlers {
+		if h.framesIndex < 1 || h.framesIndex > v.framesIndex {
+			return false
+		}
+		f := &v.frames[h.framesIndex-1]
+		if h.sp < f.basePointer || h.sp > v.sp ||
+			h.catchIP < 0 || h.catchIP >= len(f.fn.Instructions) {
+			return false
+		}
+	}
+	return true
+}
+
+// validState is like VM.validState for the frame saved by a suspended
+// generator, which is resumed on top of the stack. The frame of a running
+// generator is checked with the frames of the VM.
+func (g *Generator) validState() bool {
+	if g.running || g.done {
+		return true
+	}
+	if g.ip < -1 || g.ip >= len(g.fn.Instructions) ||
+		len(g.stack) < g.fn.NumLocals {
+		return false
+	}
+	for _, h := range g.handlers {
+		if h.sp < 0 || h.sp > len(g.stack) ||
+			h.catchIP < 0 || h.catchIP >= len(g.fn.Instructions) {
+			return false
+		}
+	}
+	return true
+}
+
+// bytecodeChecksum returns the checksum of the instructions of the compiled
+// functions of the bytecode.
+func bytecodeChecksum(constants []Object, mainFunc *CompiledFunction) uint32 {
+	h := crc32.NewIEEE()
+	_, _ = h.Write(mainFunc.Instructions)
+	for _, c := range constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			_, _ = h.Write(fn.Instructions)
+		}
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum32()
+}
+
+func encodeHandlers(handlers []handler) []snapshotHandler {
+	res := make([]snapshotHandler, len(handlers))
+	for i, h := range handlers {
+		res[i] = snapshotHandler{
+			FramesIndex: h.framesIndex,
+			SP:          h.sp,
+			CatchIP:     h.catchIP,
+		}
+	}
+	return res
+}
+
+func decodeHandlers(handlers []snapshotHandler) []handler {
+	res := make([]handler, len(handlers))
+	for i, h := range handlers {
+		res[i] = handler{
+			framesIndex: h.FramesIndex,
+			sp:          h.SP,
+			catchIP:     h.CatchIP,
+		}
+	}
+	return res
+}
+
+// attrRef is an attribute of a constant or builtin module.
+type attrRef struct {
+	module Object
+	key    string
+}
+
+type snapshotEncoder struct {
+	objects   []snapshotObject
+	ids       map[Object]int
+	rawMaps   map[uintptr]int
+	constants map[Object]int
+	protos    map[*byte]int  // first instruction to prototype function
+	protoSrcs map[string]int // instructions to prototype function
+	attrs     map[Object]attrRef
+	globals   map[Object]int
+	mainFunc  *CompiledFunction
+	entryFunc *CompiledFunction // calls the function run by RunCompiled
+}
+
+func newSnapshotEncoder(v *VM) *snapshotEncoder {
+	s := newObjectEncoder()
+	s.mainFunc = v.mainFunc
+	if v.entryFn {
+		s.entryFunc = v.frames[0].fn
+	}
+	s.addProto(v.mainFunc, -1)
+	s.addConstants(v.constants)
+	for i, g := range v.globals {
+		if isPointer(g) && isHostObject(g) {
+			if _, ok := s.globals[g]; !ok {
+				s.globals[g] = i
+			}
+		}
+	}
+	return s
+}
+
+// newObjectEncoder creates an encoder that refers to no bytecode.
+func newObjectEncoder() *snapshotEncoder {
+	return &snapshotEncoder{
+		ids:       make(map[Object]int),
+		rawMaps:   make(map[uintptr]int),
+		constants: make(map[Object]int),
+		protos:    make(map[*byte]int),
+		protoSrcs: make(map[string]int),
+		attrs:     make(map[Object]attrRef),
+		globals:   make(map[Object]int),
+	}
+}
+
+// addConstants makes the encoder write the constants, the functions created
+// from them and the attributes of the modules as references.
+func (s *snapshotEncoder) addConstants(constants []Object) {
+	for i, c := range constants {
+		if !isPointer(c) {
+			continue
+		}
+		s.constants[c] = i
+		switch c := c.(type) {
+		case *CompiledFunction:
+			s.addProto(c, i)
+		case *ImmutableMap:
+			s.addAttrs(c)
+		}
+	}
+}
+
+func (s *snapshotEncoder) addProto(fn *CompiledFunction, idx int) {
+	if len(fn.Instructions) > 0 {
+		s.protos[&fn.Instructions[0]] = idx
+	}
+	if _, ok := s.protoSrcs[string(fn.Instructions)]; !ok {
+		s.protoSrcs[string(fn.Instructions)] = idx
+	}
+}
+
+func (s *snapshotEncoder) addAttrs(m *ImmutableMap) {
+	for k, v := range m.Value {
+		if isPointer(v) && isHostObject(v) {
+			s.attrs[v] = attrRef{module: m, key: k}
+		}
+	}
+}
+
+func (s *snapshotEncoder) encodeObjects(objs []Object) ([]int, error) {
+	ids := make([]int, len(objs))
+	for i, o := range objs {
+		id, err := s.encode(o)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// truncate removes the objects added after the first n objects.
+func (s *snapshotEncoder) truncate(n int) {
+	s.objects = s.objects[:n]
+	for o, id := range s.ids {
+		if id > n {
+			delete(s.ids, o)
+		}
+	}
+	for ptr, id := range s.rawMaps {
+		if id > n {
+			delete(s.rawMaps, ptr)
+		}
+	}
+}
+
+// add appends the object to the snapshot and returns its ID.
+func (s *snapshotEncoder) add(o Object, so snapshotObject) int {
+	s.objects = append(s.objects, so)
+	id := len(s.objects)
+	if o != nil {
+		s.ids[o] = id
+	}
+	return id
+}
+
+// encode adds the object and the objects it refers to, and returns its ID.
+func (s *snapshotEncoder) encode(o Object) (int, error) {
+	if o == nil {
+		return 0, nil
+	}
+	if !isPointer(o) {
+		return s.add(nil, snapshotObject{Kind: snapshotValue, Value: o}), nil
+	}
+	if id, ok := s.ids[o]; ok {
+		return id, nil
+	}
+	if idx, ok := s.constants[o]; ok {
+		return s.add(o, snapshotObject{Kind: snapshotConstant, Index: idx}), nil
+	}
+
+	switch o := o.(type) {
+	case *Undefined:
+		return s.add(o, snapshotObject{Kind: snapshotUndefined}), nil
+	case *Bool:
+		idx := 0
+		if !o.IsFalsy() {
+			idx = 1
+		}
+		return s.add(o, snapshotObject{Kind: snapshotBool, Index: idx}), nil
+	case *Array:
+		return s.encodeElements(o, snapshotArray, o.Value)
+	case *ImmutableArray:
+		return s.encodeElements(o, snapshotImmutableArray, o.Value)
+	case *Map:
+		return s.encodeMap(o, snapshotMap, o.Value)
+	case *ImmutableMap:
+		if modName := inferModuleName(o); modName != "" {
+			s.addAttrs(o)
+			return s.add(o, snapshotObject{
+				Kind: snapshotModule,
+				Keys: []string{modName},
+			}), nil
+		}
+		return s.encodeMap(o, snapshotImmutableMap, o.Value)
+	case *Error:
+		id := s.add(o, snapshotObject{Kind: snapshotError})
+		ref, err := s.encode(o.Value)
+		if err != nil {
+			return 0, err
+		}
+		s.objects[id-1].Refs = []int{ref}
+		return id, nil
+	case *CompiledFunction:
+		return s.encodeFunction(o)
+	case *ObjectPtr:
+		id := s.add(o, snapshotObject{Kind: snapshotPtr})
+		var ref int
+		if o.Value != nil {
+			var err error
+			if ref, err = s.encode(*o.Value); err != nil {
+				return 0, err
+			}
+		}
+		s.objects[id-1].Refs = []int{ref}
+		return id, nil
+	case *ArrayIterator:
+		id, err := s.encodeElements(o, snapshotArrayIterator, o.v)
+		if err != nil {
+			return 0, err
+		}
+		s.objects[id-1].Ints = []int{o.i, o.l}
+		return id, nil
+	case *BytesIterator:
+		return s.add(o, snapshotObject{
+			Kind:  snapshotBytesIterator,
+			Bytes: o.v,
+			Ints:  []int{o.i, o.l},
+		}), nil
+	case *StringIterator:
+		return s.add(o, snapshotObject{
+			Kind:  snapshotStringIterator,
+			Runes: o.v,
+			Ints:  []int{o.i, o.l},
+		}), nil
+	case *MapIterator:
+		id := s.add(o, snapshotObject{
+			Kind: snapshotMapIterator,
+			Keys: o.k,
+			Ints: []int{o.i, o.l},
+		})
+		ref, err := s.encodeRawMap(o.v)
+		if err != nil {
+			return 0, err
+		}
+		s.objects[id-1].Refs = []int{ref}
+		return id, nil
+	case *Generator:
+		return s.encodeGenerator(o)
+	}
+
+	for i, b := range builtinFuncs {
+		if o == Object(b) {
+			return s.add(o, snapshotObject{Kind: snapshotBuiltin, Index: i}), nil
+		}
+	}
+	if ref, ok := s.attrs[o]; ok {
+		mod, err := s.encode(ref.module)
+		if err != nil {
+			return 0, err
+		}
+		return s.add(o, snapshotObject{
+			Kind: snapshotAttr,
+			Refs: []int{mod},
+			Keys: []string{ref.key},
+		}), nil
+	}
+	if idx, ok := s.globals[o]; ok {
+		return s.add(o, snapshotObject{Kind: snapshotGlobal, Index: idx}), nil
+	}
+	if isHostObject(o) {
+		return 0, fmt.Errorf("cannot snapshot %s", o.TypeName())
+	}
+	return s.add(o, snapshotObject{Kind: snapshotValue, Value: o}), nil
+}
+
+func (s *snapshotEncoder) encodeElements(
+	o Object,
+	kind snapshotKind,
+	elements []Object,
+) (int, error) {
+	id := s.add(o, snapshotObject{Kind: kind})
+	refs, err := s.encodeObjects(elements)
+	if err != nil {
+		return 0, err
+	}
+	s.objects[id-1].Refs = refs
+	return id, nil
+}
+
+func (s *snapshotEncoder) encodeMap(
+	o Object,
+	kind snapshotKind,
+	m map[string]Object,
+) (int, error) {
+	id := s.add(o, snapshotObject{Kind: kind})
+	ref, err := s.encodeRawMap(m)
+	if err != nil {
+		return 0, err
+	}
+	s.objects[id-1].Refs = []int{ref}
+	return id, nil
+}
+
+// encodeRawMap adds the map value shared by maps and their iterators.
+func (s *snapshotEncoder) encodeRawMap(m map[string]Object) (int, error) {
+	ptr := reflect.ValueOf(m).Pointer()
+	if id, ok := s.rawMaps[ptr]; ok {
+		return id, nil
+	}
+	id := s.add(nil, snapshotObject{Kind: snapshotRawMap})
+	s.rawMaps[ptr] = id
+	keys := make([]string, 0, len(m))
+	refs := make([]int, 0, len(m))
+	for k, v := range m {
+		ref, err := s.encode(v)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, k)
+		refs = append(refs, ref)
+	}
+	s.objects[id-1].Keys = keys
+	s.objects[id-1].Refs = refs
+	return id, nil
+}
+
+func (s *snapshotEncoder) encodeFunction(fn *CompiledFunction) (int, error) {
+	if fn == s.mainFunc {
+		return s.add(fn, snapshotObject{Kind: snapshotFunction, Index: -1}), nil
+	}
+	if fn == s.entryFunc {
+		return s.add(fn, snapshotObject{
+			Kind:  snapshotFunction,
+			Index: -2,
+			Ints:  []int{int(fn.Instructions[1])},
+		}), nil
+	}
+	idx, ok := -2, false
+	if len(fn.Instructions) > 0 {
+		idx, ok = s.protos[&fn.Instructions[0]]
+	}
+	if !ok {
+		if idx, ok = s.protoSrcs[string(fn.Instructions)]; !ok {
+			return 0, errors.New("cannot snapshot compiled function " +
+				"not found in bytecode")
+		}
+	}
+	id := s.add(fn, snapshotObject{Kind: snapshotFunction, Index: idx})
+	refs := make([]int, len(fn.Free))
+	for i, p := range fn.Free {
+		ref, err := s.encode(p)
+		if err != nil {
+			return 0, err
+		}
+		refs[i] = ref
+	}
+	s.objects[id-1].Refs = refs
+	return id, nil
+}
+
+func (s *snapshotEncoder) encodeGenerator(g *Generator) (int, error) {
+	id := s.add(g, snapshotObject{
+		Kind:     snapshotGenerator,
+		Ints:     []int{g.ip, boolToInt(g.running), boolToInt(g.done), g.i},
+		Handlers: encodeHandlers(g.handlers),
+	})
+	fn, err := s.encode(g.fn)
+	if err != nil {
+		return 0, err
+	}
+	value, err := s.encode(g.value)
+	if err != nil {
+		return 0, err
+	}
+	stack, err := s.encodeObjects(g.stack)
+	if err != nil {
+		return 0, err
+	}
+	s.objects[id-1].Refs = append([]int{fn, value}, stack...)
+	return id, nil
+}
+
+type snapshotDecoder struct {
+	bytecode *Bytecode
+	modules  *ModuleMap
+	globals  []Object
+	objects  []snapshotObject
+	decoded  []Object
+	rawMaps  map[int]map[string]Object
+}
+
+func (s *snapshotDecoder) get(id int) Object {
+	if id <= 0 || id > len(s.decoded) {
+		return nil
+	}
+	return s.decoded[id-1]
+}
+
+// decodeAll creates the objects of the snapshot before filling them, as they
+// may refer to each other.
+func (s *snapshotDecoder) decodeAll() error {
+	s.decoded = make([]Object, len(s.objects))
+	s.rawMaps = make(map[int]map[string]Object)
+	for i := range s.objects {
+		if s.objects[i].Kind == snapshotAttr {
+			continue
+		}
+		o, err := s.create(i + 1)
+		if err != nil {
+			return err
+		}
+		s.decoded[i] = o
+	}
+	for i, so := range s.objects {
+		if so.Kind != snapshotAttr {
+			continue
+		}
+		if len(so.Refs) != 1 || len(so.Keys) != 1 {
+			return errors.New("invalid snapshot")
+		}
+		var attrs map[string]Object
+		switch mod := s.get(so.Refs[0]).(type) {
+		case *ImmutableMap:
+			attrs = mod.Value
+		case *Map:
+			attrs = mod.Value
+		}
+		attr, ok := attrs[so.Keys[0]]
+		if !ok {
+			return fmt.Errorf("cannot restore module attribute '%s'",
+				so.Keys[0])
+		}
+		s.decoded[i] = attr
+	}
+	for i := range s.objects {
+		if err := s.fill(i + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create returns the object of the snapshot. The objects it refers to are
+// set by fill.
+func (s *snapshotDecoder) create(id int) (Object, error) {
+	so := &s.objects[id-1]
+	switch so.Kind {
+	case snapshotValue:
+		return so.Value, nil
+	case snapshotUndefined:
+		return UndefinedValue, nil
+	case snapshotBool:
+		if so.Index != 0 {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	case snapshotConstant:
+		if so.Index < 0 || so.Index >= len(s.bytecode.Constants) {
+			return nil, errors.New("invalid snapshot")
+		}
+		return s.bytecode.Constants[so.Index], nil
+	case snapshotBuiltin:
+		if so.Index < 0 || so.Index >= len(builtinFuncs) {
+			return nil, errors.New("invalid snapshot")
+		}
+		return builtinFuncs[so.Index], nil
+	case snapshotGlobal:
+		if so.Index < 0 || so.Index >= len(s.globals) ||
+			s.globals[so.Index] == nil {
+			return nil, fmt.Errorf("cannot restore global variable %d",
+				so.Index)
+		}
+		return s.globals[so.Index], nil
+	case snapshotModule:
+		if len(so.Keys) != 1 {
+			return nil, errors.New("invalid snapshot")
+		}
+		mod := s.modules.GetBuiltinModule(so.Keys[0])
+		if mod == nil {
+			return nil, fmt.Errorf("cannot restore module '%s'", so.Keys[0])
+		}
+		return mod.AsImmutableMap(so.Keys[0]), nil
+	case snapshotArray:
+		return &Array{}, nil
+	case snapshotImmutableArray:
+		return &ImmutableArray{}, nil
+	case snapshotMap:
+		return &Map{}, nil
+	case snapshotImmutableMap:
+		return &ImmutableMap{}, nil
+	case snapshotRawMap:
+		s.rawMaps[id] = make(map[string]Object, len(so.Keys))
+		return nil, nil
+	case snapshotError:
+		return &Error{}, nil
+	case snapshotFunction:
+		if so.Index == -2 {
+			if len(so.Ints) != 1 {
+				return nil, errors.New("invalid snapshot")
+			}
+			return newEntryFunc(so.Ints[0]), nil
+		}
+		var proto *CompiledFunction
+		if so.Index == -1 {
+			proto = s.bytecode.MainFunction
+		} else if so.Index >= 0 && so.Index < len(s.bytecode.Constants) {
+			proto, _ = s.bytecode.Constants[so.Index].(*CompiledFunction)
+		}
+		if proto == nil {
+			return nil, errors.New("invalid snapshot")
+		}
+		if so.Index == -1 || len(so.Refs) == 0 {
+			return proto, nil
+		}
+		return &CompiledFunction{
+			Instructions:  proto.Instructions,
+			NumLocals:     proto.NumLocals,
+			NumParameters: proto.NumParameters,
+			VarArgs:       proto.VarArgs,
+			Generator:     proto.Generator,
+			SourceMap:     proto.SourceMap,
+		}, nil
+	case snapshotPtr:
+		return &ObjectPtr{}, nil
+	case snapshotArrayIterator:
+		return &ArrayIterator{}, nil
+	case snapshotBytesIterator:
+		return &BytesIterator{v: so.Bytes}, nil
+	case snapshotStringIterator:
+		return &StringIterator{v: so.Runes}, nil
+	case snapshotMapIterator:
+		return &MapIterator{k: so.Keys}, nil
+	case snapshotGenerator:
+		return &Generator{}, nil
+	}
+	return nil, fmt.Errorf("invalid snapshot object kind: %d", so.Kind)
+}
+
+// fill sets the objects the object of the snapshot refers to.
+func (s *snapshotDecoder) fill(id int) error {
+	so := &s.objects[id-1]
+	o := s.decoded[id-1]
+	refs := make([]Object, len(so.Refs))
+	for i, ref := range so.Refs {
+		if ref < 0 || ref > len(s.objects) {
+			return errors.New("invalid snapshot")
+		}
+		refs[i] = s.get(ref)
+	}
+	rawMap := func() (map[string]Object, error) {
+		if len(so.Refs) != 1 || s.rawMaps[so.Refs[0]] == nil {
+			return nil, errors.New("invalid snapshot")
+		}
+		return s.rawMaps[so.Refs[0]], nil
+	}
+	ints := func(n int) error {
+		if len(so.Ints) != n {
+			return errors.New("invalid snapshot")
+		}
+		return nil
+	}
+
+	var err error
+	switch so.Kind {
+	case snapshotArray:
+		o.(*Array).Value = refs
+	case snapshotImmutableArray:
+		o.(*ImmutableArray).Value = refs
+	case snapshotMap:
+		o.(*Map).Value, err = rawMap()
+	case snapshotImmutableMap:
+		o.(*ImmutableMap).Value, err = rawMap()
+	case snapshotRawMap:
+		if len(so.Keys) != len(refs) {
+			return errors.New("invalid snapshot")
+		}
+		m := s.rawMaps[id]
+		for i, k := range so.Keys {
+			m[k] = refs[i]
+		}
+	case snapshotError:
+		if len(refs) != 1 {
+			return errors.New("invalid snapshot")
+		}
+		o.(*Error).Value = refs[0]
+	case snapshotFunction:
+		fn := o.(*CompiledFunction)
+		if len(refs) == 0 || fn.Free != nil {
+			return nil
+		}
+		fn.Free = make([]*ObjectPtr, len(refs))
+		for i, ref := range refs {
+			p, ok := ref.(*ObjectPtr)
+			if !ok {
+				return errors.New("invalid snapshot")
+			}
+			fn.Free[i] = p
+		}
+	case snapshotPtr:
+		if len(refs) != 1 {
+			return errors.New("invalid snapshot")
+		}
+		value := refs[0]
+		o.(*ObjectPtr).Value = &value
+	case snapshotArrayIterator:
+		if err = ints(2); err == nil {
+			it := o.(*ArrayIterator)
+			it.v, it.i, it.l = refs, so.Ints[0], so.Ints[1]
+		}
+	case snapshotBytesIterator:
+		if err = ints(2); err == nil {
+			it := o.(*BytesIterator)
+			it.i, it.l = so.Ints[0], so.Ints[1]
+		}
+	case snapshotStringIterator:
+		if err = ints(2); err == nil {
+			it := o.(*StringIterator)
+			it.i, it.l = so.Ints[0], so.Ints[1]
+		}
+	case snapshotMapIterator:
+		if err = ints(2); err == nil {
+			it := o.(*MapIterator)
+			it.i, it.l = so.Ints[0], so.Ints[1]
+			it.v, err = rawMap()
+		}
+	case snapshotGenerator:
+		if err = ints(4); err != nil {
+			return err
+		}
+		g := o.(*Generator)
+		if len(refs) < 2 {
+			return errors.New("invalid snapshot")
+		}
+		fn, ok := refs[0].(*CompiledFunction)
+		if !ok {
+			return errors.New("invalid snapshot")
+		}
+		g.fn, g.value, g.stack = fn, refs[1], refs[2:]
+		if g.stack != nil && len(g.stack) == 0 {
+			g.stack = nil
+		}
+		g.ip = so.Ints[0]
+		g.running = so.Ints[1] != 0
+		g.done = so.Ints[2] != 0
+		g.i = so.Ints[3]
+		g.handlers = decodeHandlers(so.Handlers)
+		if !g.validState() {
+			return errors.New("invalid snapshot")
+		}
+	}
+	return err
+}
+
+// isHostObject returns true if the object is a Go function, which can only be
+// written as a reference.
+func isHostObject(o Object) bool {
+	switch o.(type) {
+	case *BuiltinFunction, *UserFunction, *ContextFunction, *StructProxy:
+		return true
+	}
+	return false
+}
+
+// isPointer returns true if the object can be used as a map key to look up
+// its identity.
+func isPointer(o Object) bool {
+	return o != nil && reflect.TypeOf(o).Kind() == reflect.Ptr
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
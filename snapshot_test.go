@@ -0,0 +1,377 @@
+package slim_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+
+	"github.com/snple/slim"
+	"github.com/snple/slim/parser"
+	"github.com/snple/slim/require"
+	"github.com/snple/slim/stdlib"
+)
+
+func TestVM_Snapshot(t *testing.T) {
+	var done slim.Object
+	modules := stdlib.GetModuleMap("math")
+	modules.AddSourceModule("util", []byte(`
+export { twice: func(f) { return func(x) { return f(f(x)) } } }`))
+	modules.AddBuiltinModule("host", map[string]slim.Object{
+		"await": &slim.ContextFunction{
+			Name: "await",
+			Value: func(
+				ctx *slim.CallContext,
+				args ...slim.Object,
+			) (slim.Object, error) {
+				return nil, ctx.Suspend()
+			},
+		},
+		"done": &slim.UserFunction{
+			Name: "done",
+			Value: func(args ...slim.Object) (slim.Object, error) {
+				done = args[0]
+				return nil, nil
+			},
+		},
+	})
+
+	src := []byte(`
+host := import("host")
+math := import("math")
+inc := import("util").twice(func(x) { return x + 1 })
+counter := func() { n := 0; return func() { n++; return n } }()
+gen := func(a) {
+	for x in a {
+		try {
+			yield math.abs(x)
+		} catch e {
+			yield "caught"
+		}
+	}
+}
+shared := [0]
+refs := [shared, shared]
+m := {a: 1}
+out := []
+for k, v in m {
+	for x in gen([-1, -2, -3]) {
+		r := host.await(x)
+		out = append(out, [x, r, counter(), inc(0), k])
+		refs[0][0] += 1
+	}
+}
+host.done([out, refs[1], len, format("%d", refs[0][0])])`)
+	fileSet := parser.NewFileSet()
+	file := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(file, src, nil)
+	f, err := p.ParseFile()
+	require.NoError(t, err)
+	c := slim.NewCompiler(file, nil, nil, modules, nil)
+	require.NoError(t, c.Compile(f))
+	b := c.Bytecode()
+	b.RemoveDuplicates()
+
+	var buf bytes.Buffer
+	require.NoError(t, b.Encode(&buf))
+	encoded := buf.Bytes()
+
+	// each step is resumed by a new VM restored from the snapshot of the
+	// previous one, and a newly decoded bytecode
+	v := slim.NewVM(b, nil, -1)
+	_, err = v.RunCompiled(nil)
+	resumed := []slim.Object{
+		&slim.Int{Value: 10},
+		&slim.Error{Value: &slim.Int{Value: 1}},
+		&slim.String{Value: "s"},
+	}
+	for _, value := range resumed {
+		require.Equal(t, slim.ErrSuspended, err)
+		var snapshot bytes.Buffer
+		require.NoError(t, v.Snapshot(&snapshot))
+
+		b := &slim.Bytecode{}
+		require.NoError(t, b.Decode(bytes.NewReader(encoded), modules))
+		v, err = slim.RestoreVM(&snapshot, b, modules)
+		require.NoError(t, err)
+		require.True(t, v.IsSuspended())
+		_, err = v.Resume(value)
+	}
+	require.NoError(t, err)
+	require.False(t, v.IsSuspended())
+	require.Equal(t, "[[[1, 10, 1, 2, \"a\"], [2, error: 1, 2, 2, \"a\"], "+
+		"[3, \"s\", 3, 2, \"a\"]], [3], <builtin-function>, \"3\"]",
+		done.String())
+
+	// snapshot of a VM that's not suspended
+	require.Equal(t, slim.ErrNotSuspended, v.Snapshot(&buf))
+}
+
+// snapshotState mirrors the state written by Snapshot, to corrupt it.
+type snapshotState struct {
+	Objects []struct {
+		Kind     uint8
+		Value    slim.Object
+		Index    int
+		Refs     []int
+		Keys     []string
+		Ints     []int
+		Bytes    []byte
+		Runes    []rune
+		Handlers []snapshotHandler
+	}
+	Stack   []int
+	Globals []int
+	Frames  []struct {
+		Fn          int
+		FreeVars    []int
+		IP          int
+		BasePointer int
+		Gen         int
+		NoResult    bool
+	}
+	Handlers    []snapshotHandler
+	IP          int
+	HandlerBase int
+	EntryFn     bool
+	MaxStack    int
+	MaxFrames   int
+	MaxAllocs   int64
+	Allocs      int64
+	MaxInsts    int64
+	Insts       int64
+	MaxMemory   int64
+	Memory      int64
+	MaxStrLen   int
+	MaxBytesLen int
+}
+
+// snapshotGenerator is the kind of the generator objects of snapshotState.
+const snapshotGenerator = 20
+
+type snapshotHandler struct {
+	FramesIndex int
+	SP          int
+	CatchIP     int
+}
+
+func TestVM_SnapshotErrors(t *testing.T) {
+	c := compile(t, `
+out := await(1)
+out = await(2)`, M{"await": &slim.ContextFunction{
+		Name: "await",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			return nil, ctx.Suspend()
+		},
+	}})
+	require.Equal(t, slim.ErrSuspended, c.Run())
+	var snapshot bytes.Buffer
+	require.NoError(t, c.Snapshot(&snapshot))
+
+	// snapshots are restored with the bytecode they were taken from
+	_, err := slim.RestoreVM(bytes.NewReader(snapshot.Bytes()),
+		compileBytecode(t, `out := 1`), nil)
+	require.Equal(t, slim.ErrSnapshotMismatch, err)
+
+	// other versions
+	var old bytes.Buffer
+	enc := gob.NewEncoder(&old)
+	require.NoError(t, enc.Encode(struct {
+		Version  int
+		Checksum uint32
+	}{Version: slim.SnapshotVersion + 1}))
+	require.Error(t, c.Restore(&old))
+
+	// corrupted indexes
+	for _, tamper := range []func(s *snapshotState){
+		func(s *snapshotState) { s.IP = 1 << 20 },
+		func(s *snapshotState) { s.IP = -2 },
+		func(s *snapshotState) { s.Frames[0].BasePointer = len(s.Stack) + 1 },
+		func(s *snapshotState) { s.HandlerBase = 1 },
+		func(s *snapshotState) {
+			s.Handlers = []snapshotHandler{{FramesIndex: 2, CatchIP: 1}}
+		},
+		func(s *snapshotState) {
+			s.Handlers = []snapshotHandler{{FramesIndex: 1, CatchIP: 1 << 20}}
+		},
+		func(s *snapshotState) {
+			s.Handlers = []snapshotHandler{{FramesIndex: 1, SP: 1 << 20}}
+		},
+	} {
+		expectInvalidSnapshot(t, c, snapshot.Bytes(), tamper)
+	}
+
+	// corrupted suspended generator
+	await := &slim.ContextFunction{
+		Name: "await",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			return nil, ctx.Suspend()
+		},
+	}
+	c = compile(t, `
+out := 0
+g := func() { try { yield 1 } catch e {}; yield 2 }()
+for x in g { out = await(x) }`, M{"await": await})
+	require.Equal(t, slim.ErrSuspended, c.Run())
+	snapshot.Reset()
+	require.NoError(t, c.Snapshot(&snapshot))
+	generator := func(s *snapshotState) int {
+		for i, o := range s.Objects {
+			if o.Kind == snapshotGenerator {
+				return i
+			}
+		}
+		panic("no generator")
+	}
+	for _, tamper := range []func(s *snapshotState){
+		func(s *snapshotState) { s.Objects[generator(s)].Ints[0] = 1 << 20 },
+		func(s *snapshotState) {
+			g := &s.Objects[generator(s)]
+			g.Refs = g.Refs[:2]
+		},
+		func(s *snapshotState) {
+			g := &s.Objects[generator(s)]
+			g.Handlers = []snapshotHandler{{SP: 1 << 20, CatchIP: 1}}
+		},
+		func(s *snapshotState) {
+			g := &s.Objects[generator(s)]
+			g.Handlers = []snapshotHandler{{CatchIP: -1}}
+		},
+	} {
+		expectInvalidSnapshot(t, c, snapshot.Bytes(), tamper)
+	}
+
+	// objects that cannot be written
+	c = compile(t, `out := [counter, await(1)]`, M{
+		"counter": &Counter{value: 1},
+		"await": &slim.ContextFunction{
+			Name: "await",
+			Value: func(
+				ctx *slim.CallContext,
+				args ...slim.Object,
+			) (slim.Object, error) {
+				return nil, ctx.Suspend()
+			},
+		},
+	})
+	require.Equal(t, slim.ErrSuspended, c.Run())
+	err = c.Snapshot(&snapshot)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "Counter"))
+}
+
+// expectInvalidSnapshot restores the snapshot after tampering with its state,
+// which must be rejected.
+func expectInvalidSnapshot(
+	t *testing.T,
+	c *slim.Compiled,
+	snapshot []byte,
+	tamper func(s *snapshotState),
+) {
+	dec := gob.NewDecoder(bytes.NewReader(snapshot))
+	var header struct {
+		Version  int
+		Checksum uint32
+	}
+	var state snapshotState
+	var name string
+	require.NoError(t, dec.Decode(&header))
+	require.NoError(t, dec.Decode(&state))
+	require.NoError(t, dec.Decode(&name))
+	tamper(&state)
+
+	var tampered bytes.Buffer
+	enc := gob.NewEncoder(&tampered)
+	require.NoError(t, enc.Encode(header))
+	require.NoError(t, enc.Encode(state))
+	require.NoError(t, enc.Encode(name))
+	err := c.Restore(&tampered)
+	require.Error(t, err)
+	require.Equal(t, "invalid snapshot", err.Error())
+}
+
+func TestCompiled_Snapshot(t *testing.T) {
+	var requested []int
+	await := &slim.ContextFunction{
+		Name: "await_approval",
+		Value: func(
+			ctx *slim.CallContext,
+			args ...slim.Object,
+		) (slim.Object, error) {
+			id, _ := slim.ToInt(args[0])
+			requested = append(requested, id)
+			return nil, ctx.Suspend()
+		},
+	}
+	src := `
+approved := []
+approve := func(ids) {
+	n := 0
+	for id in ids {
+		if await_approval(id) {
+			approved = append(approved, id)
+			n++
+		}
+	}
+	return n
+}`
+
+	// every step runs in a newly compiled script, like after a restart
+	var snapshot []byte
+	step := func(run func(c *slim.Compiled) (*slim.Variable, error)) (
+		*slim.Compiled,
+		*slim.Variable,
+		error,
+	) {
+		c := compile(t, src, M{"await_approval": await})
+		compiledRun(t, c)
+		if snapshot != nil {
+			require.NoError(t, c.Restore(bytes.NewReader(snapshot)))
+			require.True(t, c.Suspended())
+		}
+		v, err := run(c)
+		if err == slim.ErrSuspended {
+			var buf bytes.Buffer
+			require.NoError(t, c.Snapshot(&buf))
+			snapshot = buf.Bytes()
+		}
+		return c, v, err
+	}
+
+	_, _, err := step(func(c *slim.Compiled) (*slim.Variable, error) {
+		return c.Call("approve", []interface{}{1, 2, 3})
+	})
+	require.Equal(t, slim.ErrSuspended, err)
+	for _, approved := range []bool{true, false} {
+		_, _, err = step(func(c *slim.Compiled) (*slim.Variable, error) {
+			return c.Resume(approved)
+		})
+		require.Equal(t, slim.ErrSuspended, err)
+	}
+	c, v, err := step(func(c *slim.Compiled) (*slim.Variable, error) {
+		return c.Resume(true)
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v.Value())
+	require.Equal(t, "approve", v.Name())
+	require.Equal(t, "[1, 3]", c.Get("approved").String())
+	require.Equal(t, []int{1, 2, 3}, requested)
+}
+
+func compileBytecode(t *testing.T, input string) *slim.Bytecode {
+	src := []byte(input)
+	fileSet := parser.NewFileSet()
+	file := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(file, src, nil)
+	f, err := p.ParseFile()
+	require.NoError(t, err)
+	c := slim.NewCompiler(file, nil, nil, nil, nil)
+	require.NoError(t, c.Compile(f))
+	return c.Bytecode()
+}
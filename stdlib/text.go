@@ -19,7 +19,7 @@ var textModule = map[string]slim.Object{
 		Name:  "re_find",
 		Value: textREFind,
 	}, // re_find(pattern, text, count) => [[{text:,begin:,end:}]]/undefined
-	"re_replace": &slim.UserFunction{
+	"re_replace": &slim.ContextFunction{
 		Name:  "re_replace",
 		Value: textREReplace,
 	}, // re_replace(pattern, text, repl) => string/error
@@ -71,7 +71,7 @@ var textModule = map[string]slim.Object{
 		Name:  "index_any",
 		Value: FuncASSRI(strings.IndexAny),
 	}, // index_any(s, chars) => int
-	"join": &slim.UserFunction{
+	"join": &slim.ContextFunction{
 		Name:  "join",
 		Value: textJoin,
 	}, // join(arr, sep) => string
@@ -83,11 +83,11 @@ var textModule = map[string]slim.Object{
 		Name:  "last_index_any",
 		Value: FuncASSRI(strings.LastIndexAny),
 	}, // last_index_any(s, chars) => int
-	"repeat": &slim.UserFunction{
+	"repeat": &slim.ContextFunction{
 		Name:  "repeat",
 		Value: textRepeat,
 	}, // repeat(s, count) => string
-	"replace": &slim.UserFunction{
+	"replace": &slim.ContextFunction{
 		Name:  "replace",
 		Value: textReplace,
 	}, // replace(s, old, new, n) => string
@@ -127,11 +127,11 @@ var textModule = map[string]slim.Object{
 		Name:  "to_upper",
 		Value: FuncASRS(strings.ToUpper),
 	}, // to_upper(s) => string
-	"pad_left": &slim.UserFunction{
+	"pad_left": &slim.ContextFunction{
 		Name:  "pad_left",
 		Value: textPadLeft,
 	}, // pad_left(s, pad_len, pad_with) => string
-	"pad_right": &slim.UserFunction{
+	"pad_right": &slim.ContextFunction{
 		Name:  "pad_right",
 		Value: textPadRight,
 	}, // pad_right(s, pad_len, pad_with) => string
@@ -332,7 +332,10 @@ func textREFind(args ...slim.Object) (ret slim.Object, err error) {
 	return
 }
 
-func textREReplace(args ...slim.Object) (ret slim.Object, err error) {
+func textREReplace(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	if len(args) != 3 {
 		err = slim.ErrWrongNumArguments
 		return
@@ -358,29 +361,11 @@ func textREReplace(args ...slim.Object) (ret slim.Object, err error) {
 		return
 	}
 
-	s3, ok := slim.ToString(args[2])
-	if !ok {
-		err = slim.ErrInvalidArgumentType{
-			Name:     "third",
-			Expected: "string(compatible)",
-			Found:    args[2].TypeName(),
-		}
-		return
-	}
-
 	re, err := regexp.Compile(s1)
 	if err != nil {
-		ret = wrapError(err)
-	} else {
-		s, ok := doTextRegexpReplace(re, s2, s3)
-		if !ok {
-			return nil, slim.ErrStringLimit
-		}
-
-		ret = &slim.String{Value: s}
+		return wrapError(err), nil
 	}
-
-	return
+	return textRegexpReplace(ctx, re, s2, args[2], "third")
 }
 
 func textRESplit(args ...slim.Object) (ret slim.Object, err error) {
@@ -465,7 +450,10 @@ func textRECompile(args ...slim.Object) (ret slim.Object, err error) {
 	return
 }
 
-func textReplace(args ...slim.Object) (ret slim.Object, err error) {
+func textReplace(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	if len(args) != 4 {
 		err = slim.ErrWrongNumArguments
 		return
@@ -511,9 +499,8 @@ func textReplace(args ...slim.Object) (ret slim.Object, err error) {
 		return
 	}
 
-	s, ok := doTextReplace(s1, s2, s3, i4)
-	if !ok {
-		err = slim.ErrStringLimit
+	s, err := doTextReplace(ctx, s1, s2, s3, i4)
+	if err != nil {
 		return
 	}
 
@@ -585,7 +572,10 @@ func textSubstring(args ...slim.Object) (ret slim.Object, err error) {
 	return
 }
 
-func textPadLeft(args ...slim.Object) (ret slim.Object, err error) {
+func textPadLeft(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	argslen := len(args)
 	if argslen != 2 && argslen != 3 {
 		err = slim.ErrWrongNumArguments
@@ -612,9 +602,12 @@ func textPadLeft(args ...slim.Object) (ret slim.Object, err error) {
 		return
 	}
 
-	if i2 > slim.MaxStringLen {
+	if i2 > ctx.MaxStringLen() {
 		return nil, slim.ErrStringLimit
 	}
+	if err = ctx.CheckMemory(int64(i2)); err != nil {
+		return
+	}
 
 	sLen := len(s1)
 	if sLen >= i2 {
@@ -648,7 +641,10 @@ func textPadLeft(args ...slim.Object) (ret slim.Object, err error) {
 	return
 }
 
-func textPadRight(args ...slim.Object) (ret slim.Object, err error) {
+func textPadRight(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	argslen := len(args)
 	if argslen != 2 && argslen != 3 {
 		err = slim.ErrWrongNumArguments
@@ -675,9 +671,12 @@ func textPadRight(args ...slim.Object) (ret slim.Object, err error) {
 		return
 	}
 
-	if i2 > slim.MaxStringLen {
+	if i2 > ctx.MaxStringLen() {
 		return nil, slim.ErrStringLimit
 	}
+	if err = ctx.CheckMemory(int64(i2)); err != nil {
+		return
+	}
 
 	sLen := len(s1)
 	if sLen >= i2 {
@@ -711,7 +710,10 @@ func textPadRight(args ...slim.Object) (ret slim.Object, err error) {
 	return
 }
 
-func textRepeat(args ...slim.Object) (ret slim.Object, err error) {
+func textRepeat(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	if len(args) != 2 {
 		return nil, slim.ErrWrongNumArguments
 	}
@@ -734,14 +736,20 @@ func textRepeat(args ...slim.Object) (ret slim.Object, err error) {
 		}
 	}
 
-	if len(s1)*i2 > slim.MaxStringLen {
+	if len(s1)*i2 > ctx.MaxStringLen() {
 		return nil, slim.ErrStringLimit
 	}
+	if err = ctx.CheckMemory(int64(len(s1) * i2)); err != nil {
+		return
+	}
 
 	return &slim.String{Value: strings.Repeat(s1, i2)}, nil
 }
 
-func textJoin(args ...slim.Object) (ret slim.Object, err error) {
+func textJoin(
+	ctx *slim.CallContext,
+	args ...slim.Object,
+) (ret slim.Object, err error) {
 	if len(args) != 2 {
 		return nil, slim.ErrWrongNumArguments
 	}
@@ -793,9 +801,12 @@ func textJoin(args ...slim.Object) (ret slim.Object, err error) {
 	}
 
 	// make sure output length does not exceed the limit
-	if slen+len(s2)*(len(ss1)-1) > slim.MaxStringLen {
+	if slen+len(s2)*(len(ss1)-1) > ctx.MaxStringLen() {
 		return nil, slim.ErrStringLimit
 	}
+	if err = ctx.CheckMemory(int64(slen + len(s2)*(len(ss1)-1))); err != nil {
+		return
+	}
 
 	return &slim.String{Value: strings.Join(ss1, s2)}, nil
 }
@@ -1024,20 +1035,32 @@ func textParseInt(args ...slim.Object) (ret slim.Object, err error) {
 
 // Modified implementation of strings.Replace
 // to limit the maximum length of output string.
-func doTextReplace(s, old, new string, n int) (string, bool) {
+func doTextReplace(
+	ctx *slim.CallContext,
+	s, old, new string,
+	n int,
+) (string, error) {
 	if old == new || n == 0 {
-		return s, true // avoid allocation
+		return s, nil // avoid allocation
 	}
 
 	// Compute number of replacements.
 	if m := strings.Count(s, old); m == 0 {
-		return s, true // avoid allocation
+		return s, nil // avoid allocation
 	} else if n < 0 || m < n {
 		n = m
 	}
 
 	// Apply replacements to buffer.
-	t := make([]byte, len(s)+n*(len(new)-len(old)))
+	maxLen := ctx.MaxStringLen()
+	size := len(s) + n*(len(new)-len(old))
+	if size > maxLen {
+		return "", slim.ErrStringLimit
+	}
+	if err := ctx.CheckMemory(int64(size)); err != nil {
+		return "", err
+	}
+	t := make([]byte, size)
 	w := 0
 	start := 0
 	for i := 0; i < n; i++ {
@@ -1052,8 +1075,8 @@ func doTextReplace(s, old, new string, n int) (string, bool) {
 		}
 
 		ssj := s[start:j]
-		if w+len(ssj)+len(new) > slim.MaxStringLen {
-			return "", false
+		if w+len(ssj)+len(new) > maxLen {
+			return "", slim.ErrStringLimit
 		}
 
 		w += copy(t[w:], ssj)
@@ -1062,11 +1085,11 @@ func doTextReplace(s, old, new string, n int) (string, bool) {
 	}
 
 	ss := s[start:]
-	if w+len(ss) > slim.MaxStringLen {
-		return "", false
+	if w+len(ss) > maxLen {
+		return "", slim.ErrStringLimit
 	}
 
 	w += copy(t[w:], ss)
 
-	return string(t[0:w]), true
+	return string(t[0:w]), nil
 }
@@ -1,7 +1,9 @@
 package stdlib
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/snple/slim"
 )
@@ -136,8 +138,8 @@ func makeTextRegexp(re *regexp.Regexp) *slim.ImmutableMap {
 			},
 
 			// replace(src, repl) => string
-			"replace": &slim.UserFunction{
-				Value: func(args ...slim.Object) (
+			"replace": &slim.ContextFunction{
+				Value: func(ctx *slim.CallContext, args ...slim.Object) (
 					ret slim.Object,
 					err error,
 				) {
@@ -156,24 +158,7 @@ func makeTextRegexp(re *regexp.Regexp) *slim.ImmutableMap {
 						return
 					}
 
-					s2, ok := slim.ToString(args[1])
-					if !ok {
-						err = slim.ErrInvalidArgumentType{
-							Name:     "second",
-							Expected: "string(compatible)",
-							Found:    args[1].TypeName(),
-						}
-						return
-					}
-
-					s, ok := doTextRegexpReplace(re, s1, s2)
-					if !ok {
-						return nil, slim.ErrStringLimit
-					}
-
-					ret = &slim.String{Value: s}
-
-					return
+					return textRegexpReplace(ctx, re, s1, args[1], "second")
 				},
 			},
 
@@ -228,24 +213,99 @@ func makeTextRegexp(re *regexp.Regexp) *slim.ImmutableMap {
 	}
 }
 
+// textRegexpReplace replaces matches of re in src with repl, which is either
+// a replacement string or a function called with each matched text.
+func textRegexpReplace(
+	ctx *slim.CallContext,
+	re *regexp.Regexp,
+	src string,
+	repl slim.Object,
+	argName string,
+) (slim.Object, error) {
+	if repl.CanCall() {
+		s, err := doTextRegexpReplaceFunc(ctx, re, src, repl)
+		if err != nil {
+			return nil, err
+		}
+		return &slim.String{Value: s}, nil
+	}
+
+	s, ok := slim.ToString(repl)
+	if !ok {
+		return nil, slim.ErrInvalidArgumentType{
+			Name:     argName,
+			Expected: "string(compatible)/function",
+			Found:    repl.TypeName(),
+		}
+	}
+	s, err := doTextRegexpReplace(ctx, re, src, s)
+	if err != nil {
+		return nil, err
+	}
+	return &slim.String{Value: s}, nil
+}
+
+// Size-limit checking implementation of regexp.ReplaceAllStringFunc. The
+// replacement for each match is the string value returned by fn.
+func doTextRegexpReplaceFunc(
+	ctx *slim.CallContext,
+	re *regexp.Regexp,
+	src string,
+	fn slim.Object,
+) (string, error) {
+	maxLen := ctx.MaxStringLen()
+	idx := 0
+	var out strings.Builder
+	for _, m := range re.FindAllStringIndex(src, -1) {
+		res, err := ctx.Invoke(fn, &slim.String{Value: src[m[0]:m[1]]})
+		if err != nil {
+			return "", err
+		}
+		repl, ok := slim.ToString(res)
+		if !ok {
+			return "", fmt.Errorf("invalid replacement type: %s",
+				res.TypeName())
+		}
+		if out.Len()+m[0]-idx+len(repl) > maxLen {
+			return "", slim.ErrStringLimit
+		}
+		out.WriteString(src[idx:m[0]])
+		out.WriteString(repl)
+		idx = m[1]
+	}
+	if out.Len()+len(src)-idx > maxLen {
+		return "", slim.ErrStringLimit
+	}
+	out.WriteString(src[idx:])
+	if err := ctx.CheckMemory(int64(out.Len())); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
 // Size-limit checking implementation of regexp.ReplaceAllString.
-func doTextRegexpReplace(re *regexp.Regexp, src, repl string) (string, bool) {
+func doTextRegexpReplace(
+	ctx *slim.CallContext,
+	re *regexp.Regexp,
+	src, repl string,
+) (string, error) {
+	maxLen := ctx.MaxStringLen()
 	idx := 0
-	out := ""
+	var out []byte
 	for _, m := range re.FindAllStringSubmatchIndex(src, -1) {
-		var exp []byte
-		exp = re.ExpandString(exp, repl, src, m)
-		if len(out)+m[0]-idx+len(exp) > slim.MaxStringLen {
-			return "", false
+		out = append(out, src[idx:m[0]]...)
+		out = re.ExpandString(out, repl, src, m)
+		if len(out) > maxLen {
+			return "", slim.ErrStringLimit
 		}
-		out += src[idx:m[0]] + string(exp)
 		idx = m[1]
 	}
-	if idx < len(src) {
-		if len(out)+len(src)-idx > slim.MaxStringLen {
-			return "", false
-		}
-		out += src[idx:]
+	if len(out)+len(src)-idx > maxLen {
+		return "", slim.ErrStringLimit
+	}
+	out = append(out, src[idx:]...)
+	if err := ctx.CheckMemory(int64(len(out))); err != nil {
+		return "", err
 	}
-	return out, true
+	return string(out), nil
 }
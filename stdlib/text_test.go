@@ -1,10 +1,13 @@
 package stdlib_test
 
 import (
+	"errors"
 	"regexp"
 	"testing"
 
 	"github.com/snple/slim"
+	"github.com/snple/slim/require"
+	"github.com/snple/slim/stdlib"
 )
 
 func TestTextRE(t *testing.T) {
@@ -193,6 +196,56 @@ func TestTextRE(t *testing.T) {
 	}
 }
 
+func TestTextREReplaceFunc(t *testing.T) {
+	expect(t, `
+text := import("text")
+out := text.re_replace("[0-9]+", "a1b22c333", func(m) {
+	return string(len(m))
+})`, "a1b2c3")
+
+	expect(t, `
+text := import("text")
+n := 0
+out := text.re_compile("o").replace("foo boo", func(m) {
+	n++
+	return string(n)
+})`, "f12 b34")
+
+	s := slim.NewScript([]byte(`
+text := import("text")
+out := text.re_replace("o", "foo", func(m) { return undefined })`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	_, err := s.Run()
+	require.Error(t, err)
+
+	s = slim.NewScript([]byte(`
+text := import("text")
+out := text.re_replace("o", "foo", func() { return "x" })`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	_, err = s.Run()
+	require.Error(t, err)
+
+	// limits of the script
+	s = slim.NewScript([]byte(`
+text := import("text")
+out := text.re_replace("o", "foo", func(m) { return "xx" })`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	s.SetMaxStringLen(5)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, "fxxxx", c.Get("out").String())
+	s.SetMaxStringLen(4)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrStringLimit))
+	s = slim.NewScript([]byte(`
+text := import("text")
+out := text.re_replace("o", text.repeat("o", 10000), func(m) { return "xx" })`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	s.SetMaxMemory(15000)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, slim.ErrMemoryLimit))
+}
+
 func TestText(t *testing.T) {
 	module(t, "text").call("compare", "", "").expect(0)
 	module(t, "text").call("compare", "", "a").expect(-1)
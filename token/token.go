@@ -67,6 +67,8 @@ const (
 	Semicolon    // ;
 	Colon        // :
 	Question     // ?
+	Coalesce     // ??
+	QuestionDot  // ?.
 	_operatorEnd
 	_keywordBeg
 	Break
@@ -84,6 +86,12 @@ const (
 	In
 	Undefined
 	Import
+	Try
+	Catch
+	Switch
+	Case
+	Default
+	Yield
 	_keywordEnd
 )
 
@@ -143,6 +151,8 @@ var tokens = [...]string{
 	Semicolon:    ";",
 	Colon:        ":",
 	Question:     "?",
+	Coalesce:     "??",
+	QuestionDot:  "?.",
 	Break:        "break",
 	Continue:     "continue",
 	Else:         "else",
@@ -158,6 +168,12 @@ var tokens = [...]string{
 	In:           "in",
 	Undefined:    "undefined",
 	Import:       "import",
+	Try:          "try",
+	Catch:        "catch",
+	Switch:       "switch",
+	Case:         "case",
+	Default:      "default",
+	Yield:        "yield",
 }
 
 func (tok Token) String() string {
@@ -180,16 +196,18 @@ const LowestPrec = 0
 // Precedence returns the precedence for the operator token.
 func (tok Token) Precedence() int {
 	switch tok {
-	case LOr:
+	case Coalesce:
 		return 1
-	case LAnd:
+	case LOr:
 		return 2
-	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq:
+	case LAnd:
 		return 3
-	case Add, Sub, Or, Xor:
+	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq:
 		return 4
-	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+	case Add, Sub, Or, Xor:
 		return 5
+	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+		return 6
 	}
 	return LowestPrec
 }
@@ -130,6 +130,12 @@ func (v *Variable) Object() Object {
 	return v.value
 }
 
+// Decode decodes the variable value into the Go value out points to. See
+// Decode for the conversion rules.
+func (v *Variable) Decode(out interface{}) error {
+	return Decode(v.value, out)
+}
+
 // IsUndefined returns true if the underlying value is undefined.
 func (v *Variable) IsUndefined() bool {
 	return v.value == UndefinedValue
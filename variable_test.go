@@ -1,7 +1,9 @@
 package slim_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/snple/slim"
 	"github.com/snple/slim/require"
@@ -79,3 +81,98 @@ func TestVariable(t *testing.T) {
 		require.Equal(t, tc.IsUndefined, v.IsUndefined(), "Name: %s", tc.Name)
 	}
 }
+
+func TestVariable_Decode(t *testing.T) {
+	type Item struct {
+		Name  string  `slim:"name"`
+		Price float64 `json:"price"`
+		Qty   uint8   `slim:"qty"`
+	}
+	type Order struct {
+		ID      int              `slim:"id"`
+		Items   []Item           `slim:"items"`
+		Tags    map[string]int   `slim:"tags"`
+		Ref     *Item            `slim:"ref"`
+		At      time.Time        `slim:"at"`
+		Timeout time.Duration    `slim:"timeout"`
+		Codes   [2]int           `slim:"codes"`
+		Raw     []byte           `slim:"raw"`
+		Extra   interface{}      `slim:"extra"`
+		Obj     slim.Object      `slim:"obj"`
+		ByID    map[int64]string `slim:"by_id"`
+		Skip    string           `slim:"-"`
+		Opt     *string          `slim:"opt"`
+	}
+
+	at := time.Unix(1700000000, 0)
+	script := slim.NewScript([]byte(`
+out := {
+	id: 7,
+	items: [{name: "a", price: 1.5, qty: 2}, {name: "b", price: 2}],
+	tags: {x: 1},
+	ref: {name: "r"},
+	at: at,
+	timeout: 1000,
+	codes: [1, 2],
+	raw: "raw",
+	extra: [1, "s"],
+	obj: "o",
+	by_id: {"3": "c"},
+	opt: undefined,
+	unknown: true
+}`))
+	require.NoError(t, script.Add("at", at))
+	c, err := script.Run()
+	require.NoError(t, err)
+
+	opt := "set"
+	order := Order{Skip: "kept", Opt: &opt}
+	require.NoError(t, c.Get("out").Decode(&order))
+	require.Equal(t, 7, order.ID)
+	require.Equal(t, 2, len(order.Items))
+	require.True(t, order.Items[0] == Item{Name: "a", Price: 1.5, Qty: 2})
+	require.True(t, order.Items[1] == Item{Name: "b", Price: 2})
+	require.Equal(t, 1, order.Tags["x"])
+	require.Equal(t, "r", order.Ref.Name)
+	require.True(t, order.At.Equal(at))
+	require.Equal(t, int64(1000), int64(order.Timeout))
+	require.True(t, order.Codes == [2]int{1, 2})
+	require.Equal(t, []byte("raw"), order.Raw)
+	require.Equal(t, int64(1), order.Extra.([]interface{})[0])
+	require.Equal(t, "s", order.Extra.([]interface{})[1])
+	require.Equal(t, &slim.String{Value: "o"}, order.Obj)
+	require.Equal(t, "c", order.ByID[3])
+	require.Equal(t, "kept", order.Skip)
+	require.Nil(t, order.Opt)
+
+	// errors with the paths of the objects
+	var de slim.DecodeError
+	err = slim.Decode(&slim.Map{Value: map[string]slim.Object{
+		"items": &slim.Array{Value: []slim.Object{
+			&slim.Map{Value: map[string]slim.Object{}},
+			&slim.Map{Value: map[string]slim.Object{
+				"price": &slim.String{Value: "1"},
+			}},
+		}},
+	}}, &order)
+	require.True(t, errors.As(err, &de))
+	require.Equal(t, "items[1].price: expected float, found string",
+		err.Error())
+	err = slim.Decode(&slim.Map{Value: map[string]slim.Object{
+		"items": &slim.Array{Value: []slim.Object{
+			&slim.Map{Value: map[string]slim.Object{
+				"qty": &slim.Int{Value: 300},
+			}},
+		}},
+	}}, &order)
+	require.Equal(t, "items[0].qty: expected uint8, found 300", err.Error())
+	err = slim.Decode(&slim.Map{Value: map[string]slim.Object{
+		"codes": &slim.Array{},
+	}}, &order)
+	require.Equal(t, "codes: expected array of length 2, "+
+		"found array of length 0", err.Error())
+	var n int
+	require.Equal(t, "expected int, found string",
+		slim.Decode(&slim.String{Value: "1"}, &n).Error())
+	require.Error(t, slim.Decode(&slim.Int{Value: 1}, n))
+}
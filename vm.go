@@ -1,6 +1,7 @@
 package slim
 
 import (
+	"errors"
 	"fmt"
 	"sync/atomic"
 
@@ -8,29 +9,73 @@ import (
 	"github.com/snple/slim/token"
 )
 
+const (
+	// initial sizes of the stack and the frames, which grow as needed
+	initialStackSize = 64
+	initialFrames    = 16
+
+	// stackHeadroom is the number of free stack slots guaranteed to each
+	// instruction.
+	stackHeadroom = 8
+)
+
 // frame represents a function call frame.
 type frame struct {
 	fn          *CompiledFunction
 	freeVars    []*ObjectPtr
 	ip          int
 	basePointer int
+	gen         *Generator // generator resumed in the frame; or nil
+	noResult    bool       // returns undefined; set by tail calls not returned
+}
+
+// selectorCache is the inline cache of an OpSelector instruction. It holds
+// the member of the last builtin module selected by the instruction.
+type selectorCache struct {
+	m   *ImmutableMap
+	key Object
+	val Object
+}
+
+// handler represents an error handler pushed by a try statement.
+type handler struct {
+	framesIndex int
+	sp          int
+	catchIP     int
 }
 
 // VM is a virtual machine that executes the bytecode compiled by Compiler.
 type VM struct {
 	constants   []Object
-	stack       [StackSize]Object
+	mainFunc    *CompiledFunction
+	stack       []Object
 	sp          int
 	globals     []Object
 	fileSet     *parser.SourceFileSet
-	frames      [MaxFrames]frame
+	frames      []frame
 	framesIndex int
 	curFrame    *frame
 	curInsts    []byte
 	ip          int
+	handlers    []handler
+	handlerBase int
+	caches      []selectorCache
 	aborting    int64
+	suspending  bool // requested by the function being called
+	suspended   bool
+	invoking    int  // number of nested invoke calls
+	entryFn     bool // RunCompiled was called with a function
+	callCtx     *CallContext
+	maxStack    int
+	maxFrames   int
 	maxAllocs   int64
 	allocs      int64
+	maxInsts    int64
+	insts       int64
+	maxMemory   int64
+	memory      int64 // approximate bytes allocated by the run
+	maxStrLen   int
+	maxBytesLen int
 	err         error
 }
 
@@ -45,12 +90,21 @@ func NewVM(
 	}
 	v := &VM{
 		constants:   bytecode.Constants,
+		mainFunc:    bytecode.MainFunction,
+		stack:       make([]Object, initialStackSize),
 		sp:          0,
 		globals:     globals,
 		fileSet:     bytecode.FileSet,
+		frames:      make([]frame, initialFrames),
 		framesIndex: 1,
 		ip:          -1,
+		maxStack:    StackSize,
+		maxFrames:   MaxFrames,
 		maxAllocs:   maxAllocs,
+		maxInsts:    -1,
+		maxMemory:   -1,
+		maxStrLen:   MaxStringLen,
+		maxBytesLen: MaxBytesLen,
 	}
 	v.frames[0].fn = bytecode.MainFunction
 	v.frames[0].ip = -1
@@ -64,38 +118,493 @@ func (v *VM) Abort() {
 	atomic.StoreInt64(&v.aborting, 1)
 }
 
+// SetMaxStackSize sets the maximum number of objects on the stack. The stack
+// starts small and grows as needed up to this size, and the VM returns
+// ErrStackOverflow if it would exceed it. Zero or a negative number sets the
+// default, StackSize.
+func (v *VM) SetMaxStackSize(n int) {
+	if n <= 0 {
+		n = StackSize
+	}
+	v.maxStack = n
+	if len(v.stack) > n+stackHeadroom {
+		v.stack = v.stack[:n+stackHeadroom]
+	}
+}
+
+// SetMaxFrames sets the maximum number of function frames, that is the
+// maximum depth of the function calls. The VM returns ErrStackOverflow if it
+// would exceed it. Zero or a negative number sets the default, MaxFrames.
+func (v *VM) SetMaxFrames(n int) {
+	if n <= 0 {
+		n = MaxFrames
+	}
+	v.maxFrames = n
+	if len(v.frames) > n {
+		v.frames = v.frames[:n]
+	}
+}
+
+// SetMaxInstructions sets the maximum number of instructions executed by a
+// run, including the instructions executed after resuming it. The run
+// returns ErrInstructionLimit if it exceeds this limit.
+func (v *VM) SetMaxInstructions(n int64) {
+	v.maxInsts = n
+}
+
+// SetMaxMemory sets the maximum number of bytes allocated by a run. The
+// sizes of the strings, bytes, arrays and maps created by the run are
+// approximated, and the run returns ErrMemoryLimit if they exceed this limit.
+func (v *VM) SetMaxMemory(n int64) {
+	v.maxMemory = n
+}
+
+// SetMaxStringLen sets the maximum byte-length of the strings created by a
+// run. It defaults to MaxStringLen.
+func (v *VM) SetMaxStringLen(n int) {
+	v.maxStrLen = n
+}
+
+// SetMaxBytesLen sets the maximum length of the bytes created by a run. It
+// defaults to MaxBytesLen.
+func (v *VM) SetMaxBytesLen(n int) {
+	v.maxBytesLen = n
+}
+
+// IsSuspended returns true if the execution was suspended by a function
+// calling CallContext.Suspend. Use Resume to continue it.
+func (v *VM) IsSuspended() bool {
+	return v.suspended
+}
+
 // Run starts the execution.
 func (v *VM) Run() (err error) {
+	_, err = v.RunCompiled(nil)
+	return
+}
+
+// RunCompiled runs the VM with the user supplied compiled function fn and
+// returns the value fn returned. If fn is nil, the main function of the
+// bytecode is executed instead and the returned value is always nil.
+func (v *VM) RunCompiled(
+	fn *CompiledFunction,
+	args ...Object,
+) (retVal Object, err error) {
 	// reset VM states
 	v.sp = 0
+	if fn == nil {
+		v.frames[0].fn = v.mainFunc
+	} else {
+		if len(args) > 255 {
+			return nil, fmt.Errorf("too many arguments: %d", len(args))
+		}
+		if err := v.growStack(1 + len(args)); err != nil {
+			return nil, err
+		}
+
+		// entry function calls fn and suspends the VM right after the call
+		// so the returned value is left on top of the stack.
+		v.stack[0] = fn
+		for i, arg := range args {
+			v.stack[i+1] = arg
+		}
+		v.sp = 1 + len(args)
+		v.frames[0].fn = newEntryFunc(len(args))
+	}
 	v.curFrame = &(v.frames[0])
 	v.curInsts = v.curFrame.fn.Instructions
 	v.framesIndex = 1
 	v.ip = -1
 	v.allocs = v.maxAllocs + 1
+	v.insts = v.maxInsts + 1
+	v.memory = 0
+	v.handlers = v.handlers[:0]
+	v.handlerBase = 0
+	v.suspended = false
+	v.entryFn = fn != nil
+	return v.execute()
+}
 
-	v.run()
-	atomic.StoreInt64(&v.aborting, 0)
-	err = v.err
-	if err != nil {
-		filePos := v.fileSet.Position(
-			v.curFrame.fn.SourcePos(v.ip - 1))
-		err = fmt.Errorf("Runtime Error: %w\n\tat %s",
-			err, filePos)
-		for v.framesIndex > 1 {
-			v.framesIndex--
-			v.curFrame = &v.frames[v.framesIndex-1]
-			filePos = v.fileSet.Position(
-				v.curFrame.fn.SourcePos(v.curFrame.ip - 1))
-			err = fmt.Errorf("%w\n\tat %s", err, filePos)
+// execWide executes the instruction prefixed by OpWide, whose operands are
+// twice as wide as the operands of the same instruction without the prefix.
+func (v *VM) execWide() error {
+	op, operands, read, _ := parser.ReadInstruction(v.curInsts[v.ip:])
+	v.ip += read - 1
+
+	switch op {
+	case parser.OpConstant:
+		v.stack[v.sp] = v.constants[operands[0]]
+		v.sp++
+	case parser.OpSwitch:
+		pos := v.constants[operands[0]].(*switchTable).lookup(v.stack[v.sp-1])
+		v.sp--
+		v.ip = pos - 1
+	case parser.OpGetGlobal:
+		v.stack[v.sp] = v.globals[operands[0]]
+		v.sp++
+	case parser.OpSetGlobal:
+		v.sp--
+		v.globals[operands[0]] = v.stack[v.sp]
+	case parser.OpSetSelGlobal:
+		return v.assignSelectors(v.globals[operands[0]], operands[1])
+	case parser.OpGetLocal:
+		val := v.stack[v.curFrame.basePointer+operands[0]]
+		if obj, ok := val.(*ObjectPtr); ok {
+			val = *obj.Value
+		}
+		v.stack[v.sp] = val
+		v.sp++
+	case parser.OpDefineLocal:
+		val := v.stack[v.sp-1]
+		v.sp--
+		v.stack[v.curFrame.basePointer+operands[0]] = val
+	case parser.OpSetLocal:
+		sp := v.curFrame.basePointer + operands[0]
+		val := v.stack[v.sp-1]
+		v.sp--
+		if obj, ok := v.stack[sp].(*ObjectPtr); ok {
+			*obj.Value = val
+			val = obj
+		}
+		v.stack[sp] = val
+	case parser.OpSetSelLocal:
+		dst := v.stack[v.curFrame.basePointer+operands[0]]
+		if obj, ok := dst.(*ObjectPtr); ok {
+			dst = *obj.Value
 		}
+		return v.assignSelectors(dst, operands[1])
+	case parser.OpGetLocalPtr:
+		sp := v.curFrame.basePointer + operands[0]
+		val := v.stack[sp]
+		freeVar, ok := val.(*ObjectPtr)
+		if !ok {
+			freeVar = &ObjectPtr{Value: &val}
+			v.stack[sp] = freeVar
+		}
+		v.stack[v.sp] = freeVar
+		v.sp++
+	case parser.OpGetFreePtr:
+		v.stack[v.sp] = v.curFrame.freeVars[operands[0]]
+		v.sp++
+	case parser.OpGetFree:
+		v.stack[v.sp] = *v.curFrame.freeVars[operands[0]].Value
+		v.sp++
+	case parser.OpSetFree:
+		*v.curFrame.freeVars[operands[0]].Value = v.stack[v.sp-1]
+		v.sp--
+	case parser.OpSetSelFree:
+		return v.assignSelectors(*v.curFrame.freeVars[operands[0]].Value,
+			operands[1])
+	case parser.OpClosure:
+		return v.pushClosure(operands[0], operands[1])
+	default:
+		return fmt.Errorf("invalid wide instruction: %s",
+			parser.OpcodeNames[op])
+	}
+	return nil
+}
+
+// assignSelectors pops the selectors and the value of a selector assignment
+// from the stack, and assigns the value to dst.
+func (v *VM) assignSelectors(dst Object, numSelectors int) error {
+	selectors := make([]Object, numSelectors)
+	for i := 0; i < numSelectors; i++ {
+		selectors[i] = v.stack[v.sp-numSelectors+i]
+	}
+	val := v.stack[v.sp-numSelectors-1]
+	v.sp -= numSelectors + 1
+	return v.indexAssign(dst, val, selectors)
+}
+
+// pushClosure pops the free variables and pushes a closure of the compiled
+// function at constIndex.
+func (v *VM) pushClosure(constIndex, numFree int) error {
+	fn, ok := v.constants[constIndex].(*CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not function: %s",
+			v.constants[constIndex].TypeName())
+	}
+	free := make([]*ObjectPtr, numFree)
+	for i := 0; i < numFree; i++ {
+		switch freeVar := (v.stack[v.sp-numFree+i]).(type) {
+		case *ObjectPtr:
+			free[i] = freeVar
+		default:
+			// the stack can be reallocated when it grows
+			val := freeVar
+			free[i] = &ObjectPtr{Value: &val}
+		}
+	}
+	v.sp -= numFree
+	cl := &CompiledFunction{
+		Instructions:  fn.Instructions,
+		NumLocals:     fn.NumLocals,
+		NumParameters: fn.NumParameters,
+		VarArgs:       fn.VarArgs,
+		Generator:     fn.Generator,
+		SourceMap:     fn.SourceMap,
+		Free:          free,
+	}
+	if err := v.alloc(cl); err != nil {
 		return err
 	}
+	v.stack[v.sp] = cl
+	v.sp++
+	return nil
+}
+
+// growStack grows the stack so that it holds at least n objects and leaves
+// stackHeadroom free slots after them. It returns ErrStackOverflow if n
+// exceeds the maximum stack size; the headroom does not count against it.
+func (v *VM) growStack(n int) error {
+	if n+stackHeadroom <= len(v.stack) {
+		return nil
+	}
+	if n > v.maxStack {
+		return ErrStackOverflow
+	}
+	size := 2 * len(v.stack)
+	if size < n+stackHeadroom {
+		size = n + stackHeadroom
+	}
+	if size > v.maxStack+stackHeadroom {
+		size = v.maxStack + stackHeadroom
+	}
+	stack := make([]Object, size)
+	copy(stack, v.stack)
+	v.stack = stack
+	return nil
+}
+
+// growFrames grows the frames so that another function frame can be pushed.
+// It returns ErrStackOverflow if the maximum number of frames is reached.
+func (v *VM) growFrames() error {
+	if v.framesIndex < len(v.frames) {
+		return nil
+	}
+	if v.framesIndex >= v.maxFrames {
+		return ErrStackOverflow
+	}
+	size := 2 * len(v.frames)
+	if size > v.maxFrames {
+		size = v.maxFrames
+	}
+	frames := make([]frame, size)
+	copy(frames, v.frames)
+	v.frames = frames
+	v.curFrame = &v.frames[v.framesIndex-1]
 	return nil
 }
 
+// newEntryFunc returns the entry function of RunCompiled that calls the
+// function with numArgs arguments.
+func newEntryFunc(numArgs int) *CompiledFunction {
+	return &CompiledFunction{
+		Instructions: []byte{
+			parser.OpCall, byte(numArgs), 0,
+			parser.OpSuspend,
+		},
+	}
+}
+
+// Resume continues the execution suspended by a function calling
+// CallContext.Suspend, with value as the result of that call. Like
+// RunCompiled, it returns the value returned by the function the VM was run
+// with, if any.
+func (v *VM) Resume(value Object) (retVal Object, err error) {
+	if !v.suspended {
+		return nil, ErrNotSuspended
+	}
+	if value == nil {
+		value = UndefinedValue
+	}
+	if err := v.growStack(v.sp + 1); err != nil {
+		return nil, err
+	}
+	v.suspended = false
+	v.stack[v.sp] = value
+	v.sp++
+	return v.execute()
+}
+
+// execute runs the VM from its current state until it ends or suspends.
+func (v *VM) execute() (retVal Object, err error) {
+	v.runHandled()
+	if v.suspended {
+		return nil, ErrSuspended
+	}
+	aborted := atomic.SwapInt64(&v.aborting, 0) == 1
+	err = v.err
+	if err != nil || aborted {
+		v.closeGenerators(1)
+	}
+	if aborted && errors.Is(err, ErrVMAborted) {
+		// a callback was interrupted by Abort
+		err = nil
+	}
+	if err != nil {
+		// the entry frame of fn has no source position
+		lastFrame := 0
+		if v.entryFn {
+			lastFrame = 1
+		}
+		return nil, fmt.Errorf("Runtime Error: %w",
+			v.traceError(err, lastFrame))
+	}
+	if v.entryFn && !aborted {
+		retVal = v.stack[v.sp-1]
+		v.sp--
+	}
+	return retVal, nil
+}
+
+// traceError appends the source positions of the call frames, from the
+// current frame down to the frame at lastFrame, to err.
+func (v *VM) traceError(err error, lastFrame int) error {
+	ip := v.ip
+	for i := v.framesIndex - 1; i >= lastFrame; i-- {
+		filePos := v.fileSet.Position(v.frames[i].fn.SourcePos(ip - 1))
+		err = fmt.Errorf("%w\n\tat %s", err, filePos)
+		if i > 0 {
+			ip = v.frames[i-1].ip
+		}
+	}
+	return err
+}
+
+// invoke calls fn re-entrantly on top of the current stack and frames and
+// returns the value fn returned. It is used by CallContext while the VM is
+// executing a call to a ContextCallable object.
+func (v *VM) invoke(fn *CompiledFunction, args ...Object) (Object, error) {
+	if len(args) > 255 {
+		return nil, fmt.Errorf("too many arguments: %d", len(args))
+	}
+	if err := v.growStack(v.sp + 1 + len(args)); err != nil {
+		return nil, err
+	}
+	if err := v.growFrames(); err != nil {
+		return nil, err
+	}
+
+	// save current states
+	curInsts, ip, sp := v.curInsts, v.ip, v.sp
+	framesIndex := v.framesIndex
+	v.curFrame.ip = ip
+
+	// entry frame calls fn and suspends the VM right after the call
+	v.stack[v.sp] = fn
+	for i, arg := range args {
+		v.stack[v.sp+1+i] = arg
+	}
+	v.sp += 1 + len(args)
+	v.curFrame = &v.frames[v.framesIndex]
+	v.curFrame.fn = &CompiledFunction{
+		Instructions: []byte{
+			parser.OpCall, byte(len(args)), 0,
+			parser.OpSuspend,
+		},
+	}
+	v.curFrame.freeVars = nil
+	v.curFrame.basePointer = v.sp
+	v.curFrame.gen = nil
+	v.curFrame.noResult = false
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = -1
+	v.framesIndex++
+
+	// errors raised by fn can only be caught by the handlers fn pushed
+	handlerBase := v.handlerBase
+	v.handlerBase = len(v.handlers)
+	v.invoking++
+
+	v.runHandled()
+
+	v.invoking--
+	v.handlers = v.handlers[:v.handlerBase]
+	v.handlerBase = handlerBase
+
+	var retVal Object
+	err := v.err
+	if err != nil {
+		err = v.traceError(err, framesIndex+1)
+		v.err = nil
+		v.closeGenerators(framesIndex)
+	} else if atomic.LoadInt64(&v.aborting) == 1 {
+		err = ErrVMAborted
+		v.closeGenerators(framesIndex)
+	} else {
+		retVal = v.stack[v.sp-1]
+	}
+
+	// restore states
+	for i := sp; i < v.sp; i++ {
+		v.stack[i] = nil
+	}
+	v.curInsts, v.ip, v.sp = curInsts, ip, sp
+	v.framesIndex = framesIndex
+	v.curFrame = &v.frames[framesIndex-1]
+	return retVal, err
+}
+
+// runHandled runs the VM and resumes the execution at the catch block of the
+// innermost error handler whenever a catchable error occurs.
+func (v *VM) runHandled() {
+	for {
+		v.run()
+		if v.err == nil || !v.handleError() {
+			return
+		}
+	}
+}
+
+// handleError unwinds the frames and the stack to the innermost error handler
+// and pushes the error value for its catch block. It returns false if the
+// error cannot be caught.
+func (v *VM) handleError() bool {
+	if len(v.handlers) <= v.handlerBase ||
+		atomic.LoadInt64(&v.aborting) == 1 ||
+		errors.Is(v.err, ErrObjectAllocLimit) ||
+		errors.Is(v.err, ErrInstructionLimit) ||
+		errors.Is(v.err, ErrMemoryLimit) ||
+		errors.Is(v.err, ErrVMAborted) {
+		return false
+	}
+
+	filePos := v.fileSet.Position(v.curFrame.fn.SourcePos(v.ip - 1))
+	errVal := &Error{
+		Value: &ImmutableMap{
+			Value: map[string]Object{
+				"message":  &String{Value: v.err.Error()},
+				"position": &String{Value: filePos.String()},
+			},
+		},
+	}
+	v.err = nil
+
+	h := v.handlers[len(v.handlers)-1]
+	v.handlers = v.handlers[:len(v.handlers)-1]
+	v.closeGenerators(h.framesIndex)
+	v.framesIndex = h.framesIndex
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = h.catchIP - 1
+	v.stack[h.sp] = errVal
+	v.sp = h.sp + 1
+	return true
+}
+
 func (v *VM) run() {
 	for atomic.LoadInt64(&v.aborting) == 0 {
+		v.insts--
+		if v.insts == 0 {
+			v.err = ErrInstructionLimit
+			return
+		}
+		if v.sp+stackHeadroom > len(v.stack) {
+			if v.err = v.growStack(v.sp); v.err != nil {
+				return
+			}
+		}
 		v.ip++
 
 		switch v.curInsts[v.ip] {
@@ -113,26 +622,46 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			tok := token.Token(v.curInsts[v.ip])
-			res, e := left.BinaryOp(tok, right)
-			if e != nil {
-				v.sp -= 2
-				if e == ErrInvalidOperator {
-					v.err = fmt.Errorf("invalid operation: %s %s %s",
-						left.TypeName(), tok.String(), right.TypeName())
-					return
-				}
-				v.err = e
-				return
+			res, ok := intBinaryOp(tok, left, right)
+			if ok {
+				v.err = v.allocInt()
+			} else {
+				res, v.err = v.binaryOp(tok, left, right)
 			}
-
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err != nil {
+				v.sp -= 2
 				return
 			}
 
 			v.stack[v.sp-2] = res
 			v.sp--
+		case parser.OpBinaryOpConst:
+			tok := token.Token(v.curInsts[v.ip+1])
+			constIndex := int(v.curInsts[v.ip+3]) | int(v.curInsts[v.ip+2])<<8
+			v.ip += 3
+			left := v.stack[v.sp-1]
+			right := v.constants[constIndex]
+
+			var res Object
+			switch tok {
+			case token.Equal:
+				res = boolObject(left.Equals(right))
+			case token.NotEqual:
+				res = boolObject(!left.Equals(right))
+			default:
+				var ok bool
+				res, ok = intBinaryOp(tok, left, right)
+				if ok {
+					v.err = v.allocInt()
+				} else {
+					res, v.err = v.binaryOp(tok, left, right)
+				}
+				if v.err != nil {
+					v.sp--
+					return
+				}
+			}
+			v.stack[v.sp-1] = res
 		case parser.OpEqual:
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
@@ -177,9 +706,7 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: ^x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.alloc(res); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = res
@@ -196,18 +723,14 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.alloc(res); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = res
 				v.sp++
 			case *Float:
 				var res Object = &Float{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.alloc(res); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = res
@@ -240,6 +763,32 @@ func (v *VM) run() {
 				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8 | int(v.curInsts[v.ip-2])<<16 | int(v.curInsts[v.ip-3])<<24
 				v.ip = pos - 1
 			}
+		case parser.OpJumpNotError:
+			v.ip += 4
+			if _, isError := v.stack[v.sp-1].(*Error); !isError {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8 | int(v.curInsts[v.ip-2])<<16 | int(v.curInsts[v.ip-3])<<24
+				v.ip = pos - 1
+			}
+		case parser.OpCoalesceJump:
+			v.ip += 4
+			if v.stack[v.sp-1] == UndefinedValue {
+				v.sp--
+			} else {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8 | int(v.curInsts[v.ip-2])<<16 | int(v.curInsts[v.ip-3])<<24
+				v.ip = pos - 1
+			}
+		case parser.OpJumpUndefined:
+			v.ip += 4
+			if v.stack[v.sp-1] == UndefinedValue {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8 | int(v.curInsts[v.ip-2])<<16 | int(v.curInsts[v.ip-3])<<24
+				v.ip = pos - 1
+			}
+		case parser.OpSwitch:
+			v.ip += 2
+			cidx := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+			pos := v.constants[cidx].(*switchTable).lookup(v.stack[v.sp-1])
+			v.sp--
+			v.ip = pos - 1
 		case parser.OpJump:
 			pos := int(v.curInsts[v.ip+4]) | int(v.curInsts[v.ip+3])<<8 | int(v.curInsts[v.ip+2])<<16 | int(v.curInsts[v.ip+1])<<24
 			v.ip = pos - 1
@@ -252,17 +801,8 @@ func (v *VM) run() {
 			v.ip += 3
 			globalIndex := int(v.curInsts[v.ip-1]) | int(v.curInsts[v.ip-2])<<8
 			numSelectors := int(v.curInsts[v.ip])
-
-			// selectors and RHS value
-			selectors := make([]Object, numSelectors)
-			for i := 0; i < numSelectors; i++ {
-				selectors[i] = v.stack[v.sp-numSelectors+i]
-			}
-			val := v.stack[v.sp-numSelectors-1]
-			v.sp -= numSelectors + 1
-			e := indexAssign(v.globals[globalIndex], val, selectors)
-			if e != nil {
-				v.err = e
+			v.err = v.assignSelectors(v.globals[globalIndex], numSelectors)
+			if v.err != nil {
 				return
 			}
 		case parser.OpGetGlobal:
@@ -282,9 +822,7 @@ func (v *VM) run() {
 			v.sp -= numElements
 
 			var arr Object = &Array{Value: elements}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err = v.alloc(arr); v.err != nil {
 				return
 			}
 
@@ -302,9 +840,7 @@ func (v *VM) run() {
 			v.sp -= numElements
 
 			var m Object = &Map{Value: kv}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err = v.alloc(m); v.err != nil {
 				return
 			}
 			v.stack[v.sp] = m
@@ -314,9 +850,7 @@ func (v *VM) run() {
 			var e Object = &Error{
 				Value: value,
 			}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err = v.alloc(e); v.err != nil {
 				return
 			}
 			v.stack[v.sp-1] = e
@@ -327,9 +861,7 @@ func (v *VM) run() {
 				var immutableArray Object = &ImmutableArray{
 					Value: value.Value,
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(immutableArray); v.err != nil {
 					return
 				}
 				v.stack[v.sp-1] = immutableArray
@@ -337,9 +869,7 @@ func (v *VM) run() {
 				var immutableMap Object = &ImmutableMap{
 					Value: value.Value,
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(immutableMap); v.err != nil {
 					return
 				}
 				v.stack[v.sp-1] = immutableMap
@@ -349,25 +879,79 @@ func (v *VM) run() {
 			left := v.stack[v.sp-2]
 			v.sp -= 2
 
-			val, err := left.IndexGet(index)
+			val, err := indexGet(left, index)
 			if err != nil {
-				if err == ErrNotIndexable {
-					v.err = fmt.Errorf("not indexable: %s", index.TypeName())
-					return
-				}
-				if err == ErrInvalidIndexType {
-					v.err = fmt.Errorf("invalid index type: %s",
-						index.TypeName())
-					return
-				}
 				v.err = err
 				return
 			}
-			if val == nil {
-				val = UndefinedValue
+			v.stack[v.sp] = val
+			v.sp++
+		case parser.OpSelector:
+			constIndex := int(v.curInsts[v.ip+2]) | int(v.curInsts[v.ip+1])<<8
+			cacheIndex := int(v.curInsts[v.ip+4]) | int(v.curInsts[v.ip+3])<<8
+			v.ip += 4
+			left := v.stack[v.sp-1]
+			key := v.constants[constIndex]
+
+			// builtin modules can't change their members so the member is
+			// looked up again only if the module is another one; other
+			// immutable maps may share their values with mutable maps
+			var val Object
+			if m, ok := left.(*ImmutableMap); ok && m.module {
+				val = v.selectCached(cacheIndex, m, key)
+			} else if val, v.err = indexGet(left, key); v.err != nil {
+				v.sp--
+				return
+			}
+			v.stack[v.sp-1] = val
+		case parser.OpIndexLocal:
+			v.ip++
+			localIndex := int(v.curInsts[v.ip])
+			index := v.stack[v.curFrame.basePointer+localIndex]
+			if obj, ok := index.(*ObjectPtr); ok {
+				index = *obj.Value
+			}
+			left := v.stack[v.sp-1]
+			v.sp--
+
+			val, err := indexGet(left, index)
+			if err != nil {
+				v.err = err
+				return
 			}
 			v.stack[v.sp] = val
 			v.sp++
+		case parser.OpUnpack:
+			v.ip++
+			numKeys := int(v.curInsts[v.ip])
+			base := v.sp - numKeys - 1
+			val := v.stack[base]
+
+			// values replace the unpacked value and the keys on the stack
+			for i := 0; i < numKeys; i++ {
+				key := v.stack[base+1+i]
+				elem, err := val.IndexGet(key)
+				if err != nil {
+					v.sp = base
+					if err == ErrNotIndexable {
+						v.err = fmt.Errorf("not indexable: %s",
+							val.TypeName())
+						return
+					}
+					if err == ErrInvalidIndexType {
+						v.err = fmt.Errorf("invalid index type: %s",
+							key.TypeName())
+						return
+					}
+					v.err = err
+					return
+				}
+				if elem == nil {
+					elem = UndefinedValue
+				}
+				v.stack[base+i] = elem
+			}
+			v.sp--
 		case parser.OpSliceIndex:
 			high := v.stack[v.sp-1]
 			low := v.stack[v.sp-2]
@@ -416,9 +1000,7 @@ func (v *VM) run() {
 				var val Object = &Array{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(val); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = val
@@ -453,9 +1035,7 @@ func (v *VM) run() {
 				var val Object = &Array{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(val); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = val
@@ -490,9 +1070,7 @@ func (v *VM) run() {
 				var val Object = &String{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(val); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = val
@@ -527,9 +1105,7 @@ func (v *VM) run() {
 				var val Object = &Bytes{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocShared(val); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = val
@@ -551,23 +1127,24 @@ func (v *VM) run() {
 
 			if spread == 1 {
 				v.sp--
+				var items []Object
 				switch arr := v.stack[v.sp].(type) {
 				case *Array:
-					for _, item := range arr.Value {
-						v.stack[v.sp] = item
-						v.sp++
-					}
-					numArgs += len(arr.Value) - 1
+					items = arr.Value
 				case *ImmutableArray:
-					for _, item := range arr.Value {
-						v.stack[v.sp] = item
-						v.sp++
-					}
-					numArgs += len(arr.Value) - 1
+					items = arr.Value
 				default:
 					v.err = fmt.Errorf("not an array: %s", arr.TypeName())
 					return
 				}
+				if v.err = v.growStack(v.sp + len(items)); v.err != nil {
+					return
+				}
+				for _, item := range items {
+					v.stack[v.sp] = item
+					v.sp++
+				}
+				numArgs += len(items) - 1
 			}
 
 			if callee, ok := value.(*CompiledFunction); ok {
@@ -600,23 +1177,43 @@ func (v *VM) run() {
 					return
 				}
 
-				// test if it's tail-call
-				if callee == v.curFrame.fn { // recursion
-					nextOp := v.curInsts[v.ip+1]
-					if nextOp == parser.OpReturn ||
-						(nextOp == parser.OpPop &&
-							parser.OpReturn == v.curInsts[v.ip+2]) {
-						for p := 0; p < numArgs; p++ {
-							v.stack[v.curFrame.basePointer+p] =
-								v.stack[v.sp-numArgs+p]
-						}
-						v.sp -= numArgs + 1
-						v.ip = -1 // reset IP to beginning of the frame
-						continue
+				// generator functions are not executed until iterated
+				if callee.Generator {
+					gen := newGenerator(callee, v.stack[v.sp-numArgs:v.sp])
+					v.sp -= numArgs + 1
+					if v.err = v.alloc(gen); v.err != nil {
+						return
+					}
+					v.stack[v.sp] = gen
+					v.sp++
+					continue
+				}
+
+				// test if it's tail-call: the frame of the caller is
+				// reused by the callee
+				if v.isTailCall(callee) {
+					bp := v.curFrame.basePointer
+					v.err = v.growStack(bp + callee.NumLocals)
+					if v.err != nil {
+						return
+					}
+					copy(v.stack[bp:], v.stack[v.sp-numArgs:v.sp])
+					if v.curInsts[v.ip+1] == parser.OpPop {
+						// the caller discards the result of the callee
+						v.curFrame.noResult = true
 					}
+					v.sp = bp + callee.NumLocals
+					v.curFrame.fn = callee
+					v.curFrame.freeVars = callee.Free
+					v.curInsts = callee.Instructions
+					v.ip = -1 // reset IP to beginning of the frame
+					continue
+				}
+				if v.err = v.growFrames(); v.err != nil {
+					return
 				}
-				if v.framesIndex >= MaxFrames {
-					v.err = ErrStackOverflow
+				v.err = v.growStack(v.sp - numArgs + callee.NumLocals)
+				if v.err != nil {
 					return
 				}
 
@@ -626,6 +1223,8 @@ func (v *VM) run() {
 				v.curFrame.fn = callee
 				v.curFrame.freeVars = callee.Free
 				v.curFrame.basePointer = v.sp - numArgs
+				v.curFrame.gen = nil
+				v.curFrame.noResult = false
 				v.curInsts = callee.Instructions
 				v.ip = -1
 				v.framesIndex++
@@ -633,8 +1232,19 @@ func (v *VM) run() {
 			} else {
 				var args []Object
 				args = append(args, v.stack[v.sp-numArgs:v.sp]...)
-				ret, e := value.Call(args...)
+				var ret Object
+				var e error
+				if callee, ok := value.(ContextCallable); ok {
+					if v.callCtx == nil {
+						v.callCtx = &CallContext{vm: v}
+					}
+					ret, e = callee.CallWithContext(v.callCtx, args...)
+				} else {
+					ret, e = value.Call(args...)
+				}
 				v.sp -= numArgs + 1
+				suspend := v.suspending
+				v.suspending = false
 
 				// runtime error
 				if e != nil {
@@ -655,13 +1265,17 @@ func (v *VM) run() {
 					return
 				}
 
+				// the result is pushed by Resume
+				if suspend {
+					v.suspended = true
+					return
+				}
+
 				// nil return -> undefined
 				if ret == nil {
 					ret = UndefinedValue
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.err = v.allocResult(ret, args); v.err != nil {
 					return
 				}
 				v.stack[v.sp] = ret
@@ -670,11 +1284,16 @@ func (v *VM) run() {
 		case parser.OpReturn:
 			v.ip++
 			var retVal Object
-			if int(v.curInsts[v.ip]) == 1 {
+			if int(v.curInsts[v.ip]) == 1 && !v.curFrame.noResult {
 				retVal = v.stack[v.sp-1]
 			} else {
 				retVal = UndefinedValue
 			}
+			if gen := v.curFrame.gen; gen != nil {
+				// ends the iteration of the generator
+				gen.close()
+				retVal = FalseValue
+			}
 			//v.sp--
 			v.framesIndex--
 			v.curFrame = &v.frames[v.framesIndex-1]
@@ -684,6 +1303,11 @@ func (v *VM) run() {
 			v.sp = v.frames[v.framesIndex].basePointer
 			// skip stack overflow check because (newSP) <= (oldSP)
 			v.stack[v.sp-1] = retVal
+			// discard the handlers of the returned frame
+			for n := len(v.handlers); n > v.handlerBase &&
+				v.handlers[n-1].framesIndex > v.framesIndex; n-- {
+				v.handlers = v.handlers[:n-1]
+			}
 			//v.sp++
 		case parser.OpDefineLocal:
 			v.ip++
@@ -710,24 +1334,47 @@ func (v *VM) run() {
 				val = obj
 			}
 			v.stack[sp] = val // also use a copy of popped value
-		case parser.OpSetSelLocal:
+		case parser.OpIncLocal:
 			localIndex := int(v.curInsts[v.ip+1])
-			numSelectors := int(v.curInsts[v.ip+2])
+			delta := int64(int8(v.curInsts[v.ip+2]))
 			v.ip += 2
+			sp := v.curFrame.basePointer + localIndex
+			val := v.stack[sp]
+			obj, isPtr := val.(*ObjectPtr)
+			if isPtr {
+				val = *obj.Value
+			}
 
-			// selectors and RHS value
-			selectors := make([]Object, numSelectors)
-			for i := 0; i < numSelectors; i++ {
-				selectors[i] = v.stack[v.sp-numSelectors+i]
+			var res Object
+			if i, ok := val.(*Int); ok {
+				res = &Int{Value: i.Value + delta}
+				v.err = v.allocInt()
+			} else {
+				// the operation of the original assignment, e.g. "s -= 1"
+				// is not "s += -1" for other types
+				tok := token.Add
+				if delta < 0 {
+					tok, delta = token.Sub, -delta
+				}
+				res, v.err = v.binaryOp(tok, val, &Int{Value: delta})
+			}
+			if v.err != nil {
+				return
+			}
+			if isPtr {
+				*obj.Value = res
+			} else {
+				v.stack[sp] = res
 			}
-			val := v.stack[v.sp-numSelectors-1]
-			v.sp -= numSelectors + 1
+		case parser.OpSetSelLocal:
+			localIndex := int(v.curInsts[v.ip+1])
+			numSelectors := int(v.curInsts[v.ip+2])
+			v.ip += 2
 			dst := v.stack[v.curFrame.basePointer+localIndex]
 			if obj, ok := dst.(*ObjectPtr); ok {
 				dst = *obj.Value
 			}
-			if e := indexAssign(dst, val, selectors); e != nil {
-				v.err = e
+			if v.err = v.assignSelectors(dst, numSelectors); v.err != nil {
 				return
 			}
 		case parser.OpGetLocal:
@@ -748,38 +1395,9 @@ func (v *VM) run() {
 			v.ip += 3
 			constIndex := int(v.curInsts[v.ip-1]) | int(v.curInsts[v.ip-2])<<8
 			numFree := int(v.curInsts[v.ip])
-			fn, ok := v.constants[constIndex].(*CompiledFunction)
-			if !ok {
-				v.err = fmt.Errorf("not function: %s", fn.TypeName())
-				return
-			}
-			free := make([]*ObjectPtr, numFree)
-			for i := 0; i < numFree; i++ {
-				switch freeVar := (v.stack[v.sp-numFree+i]).(type) {
-				case *ObjectPtr:
-					free[i] = freeVar
-				default:
-					free[i] = &ObjectPtr{
-						Value: &v.stack[v.sp-numFree+i],
-					}
-				}
-			}
-			v.sp -= numFree
-			cl := &CompiledFunction{
-				Instructions:  fn.Instructions,
-				NumLocals:     fn.NumLocals,
-				NumParameters: fn.NumParameters,
-				VarArgs:       fn.VarArgs,
-				SourceMap:     fn.SourceMap,
-				Free:          free,
-			}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err = v.pushClosure(constIndex, numFree); v.err != nil {
 				return
 			}
-			v.stack[v.sp] = cl
-			v.sp++
 		case parser.OpGetFreePtr:
 			v.ip++
 			freeIndex := int(v.curInsts[v.ip])
@@ -815,18 +1433,13 @@ func (v *VM) run() {
 			v.ip += 2
 			freeIndex := int(v.curInsts[v.ip-1])
 			numSelectors := int(v.curInsts[v.ip])
-
-			// selectors and RHS value
-			selectors := make([]Object, numSelectors)
-			for i := 0; i < numSelectors; i++ {
-				selectors[i] = v.stack[v.sp-numSelectors+i]
-			}
-			val := v.stack[v.sp-numSelectors-1]
-			v.sp -= numSelectors + 1
-			e := indexAssign(*v.curFrame.freeVars[freeIndex].Value,
-				val, selectors)
-			if e != nil {
-				v.err = e
+			v.err = v.assignSelectors(*v.curFrame.freeVars[freeIndex].Value,
+				numSelectors)
+			if v.err != nil {
+				return
+			}
+		case parser.OpWide:
+			if v.err = v.execWide(); v.err != nil {
 				return
 			}
 		case parser.OpIteratorInit:
@@ -838,9 +1451,7 @@ func (v *VM) run() {
 				return
 			}
 			iterator = dst.Iterate()
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.err = v.alloc(iterator); v.err != nil {
 				return
 			}
 			v.stack[v.sp] = iterator
@@ -848,6 +1459,12 @@ func (v *VM) run() {
 		case parser.OpIteratorNext:
 			iterator := v.stack[v.sp-1]
 			v.sp--
+			if gen, ok := iterator.(*Generator); ok {
+				if !v.resumeGenerator(gen) {
+					return
+				}
+				continue
+			}
 			hasMore := iterator.(Iterator).Next()
 			if hasMore {
 				v.stack[v.sp] = TrueValue
@@ -867,6 +1484,50 @@ func (v *VM) run() {
 			val := iterator.(Iterator).Value()
 			v.stack[v.sp] = val
 			v.sp++
+		case parser.OpTry:
+			v.ip += 4
+			pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8 |
+				int(v.curInsts[v.ip-2])<<16 | int(v.curInsts[v.ip-3])<<24
+			v.handlers = append(v.handlers, handler{
+				framesIndex: v.framesIndex,
+				sp:          v.sp,
+				catchIP:     pos,
+			})
+		case parser.OpTryEnd:
+			v.handlers = v.handlers[:len(v.handlers)-1]
+		case parser.OpYield:
+			gen := v.curFrame.gen
+			gen.value = v.stack[v.sp-1]
+			gen.i++
+			v.sp--
+
+			// save the frame, including its error handlers
+			bp := v.curFrame.basePointer
+			gen.stack = append(gen.stack[:0], v.stack[bp:v.sp]...)
+			gen.handlers = gen.handlers[:0]
+			n := len(v.handlers)
+			for n > v.handlerBase &&
+				v.handlers[n-1].framesIndex == v.framesIndex {
+				n--
+			}
+			for _, h := range v.handlers[n:] {
+				h.sp -= bp
+				gen.handlers = append(gen.handlers, h)
+			}
+			v.handlers = v.handlers[:n]
+			gen.ip = v.ip
+			gen.running = false
+
+			// return to the frame resuming the generator
+			for i := bp; i < v.sp; i++ {
+				v.stack[i] = nil
+			}
+			v.framesIndex--
+			v.curFrame = &v.frames[v.framesIndex-1]
+			v.curInsts = v.curFrame.fn.Instructions
+			v.ip = v.curFrame.ip
+			v.sp = bp
+			v.stack[v.sp-1] = TrueValue
 		case parser.OpSuspend:
 			return
 		default:
@@ -876,12 +1537,419 @@ func (v *VM) run() {
 	}
 }
 
+// CallContext gives functions called by the VM access to the running VM so
+// that they can call back into script callables.
+type CallContext struct {
+	vm *VM
+}
+
+// Invoke calls fn with the given arguments and returns its result. Compiled
+// functions, including closures, are executed re-entrantly by the VM that
+// called the current function; other callable objects are called directly.
+func (c *CallContext) Invoke(fn Object, args ...Object) (Object, error) {
+	if !fn.CanCall() {
+		return nil, fmt.Errorf("not callable: %s", fn.TypeName())
+	}
+
+	var ret Object
+	var err error
+	switch fn := fn.(type) {
+	case *CompiledFunction:
+		if c == nil || c.vm == nil {
+			return nil, ErrNoRunningVM
+		}
+		ret, err = c.vm.invoke(fn, args...)
+	case ContextCallable:
+		ret, err = fn.CallWithContext(c, args...)
+	default:
+		ret, err = fn.Call(args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		ret = UndefinedValue
+	}
+	return ret, nil
+}
+
+// resumeGenerator pushes the saved frame of gen so that the execution
+// continues after the last yield. When the generator yields or returns, the
+// frame is popped and true or false is pushed, like OpIteratorNext does.
+func (v *VM) resumeGenerator(gen *Generator) bool {
+	if gen.done {
+		v.stack[v.sp] = FalseValue
+		v.sp++
+		return true
+	}
+	if gen.running {
+		v.err = errors.New("generator already running")
+		return false
+	}
+	if v.err = v.growFrames(); v.err != nil {
+		return false
+	}
+	if v.err = v.growStack(v.sp + 1 + len(gen.stack)); v.err != nil {
+		return false
+	}
+
+	// the generator takes the slot of its result, as in a function call
+	v.stack[v.sp] = gen
+	bp := v.sp + 1
+	copy(v.stack[bp:], gen.stack)
+	v.sp = bp + len(gen.stack)
+	for _, h := range gen.handlers {
+		h.framesIndex = v.framesIndex + 1
+		h.sp += bp
+		v.handlers = append(v.handlers, h)
+	}
+
+	v.curFrame.ip = v.ip
+	v.curFrame = &v.frames[v.framesIndex]
+	v.curFrame.fn = gen.fn
+	v.curFrame.freeVars = gen.fn.Free
+	v.curFrame.basePointer = bp
+	v.curFrame.gen = gen
+	v.curFrame.noResult = false
+	v.curInsts = gen.fn.Instructions
+	v.ip = gen.ip
+	v.framesIndex++
+	gen.running = true
+	return true
+}
+
+// closeGenerators ends the generators running in the frames at index from
+// and above, which are discarded because of an error.
+func (v *VM) closeGenerators(from int) {
+	for i := from; i < v.framesIndex; i++ {
+		if gen := v.frames[i].gen; gen != nil {
+			gen.close()
+		}
+	}
+}
+
+// Suspend suspends the VM when the function being called returns, so that
+// the execution can be continued later by VM.Resume. The value returned by
+// the function is discarded; the value passed to Resume becomes the result of
+// the call instead. A VM cannot be suspended by a function called from a
+// callback run by Invoke.
+func (c *CallContext) Suspend() error {
+	if c == nil || c.vm == nil {
+		return ErrNoRunningVM
+	}
+	if c.vm.invoking > 0 {
+		return ErrCannotSuspend
+	}
+	c.vm.suspending = true
+	return nil
+}
+
+// MaxStringLen returns the maximum byte-length of the strings created by the
+// running VM, or MaxStringLen if no VM is running.
+func (c *CallContext) MaxStringLen() int {
+	if c == nil || c.vm == nil {
+		return MaxStringLen
+	}
+	return c.vm.maxStrLen
+}
+
+// MaxBytesLen returns the maximum length of the bytes created by the running
+// VM, or MaxBytesLen if no VM is running.
+func (c *CallContext) MaxBytesLen() int {
+	if c == nil || c.vm == nil {
+		return MaxBytesLen
+	}
+	return c.vm.maxBytesLen
+}
+
+// CheckMemory returns ErrMemoryLimit if allocating size more bytes would
+// exceed the memory limit of the running VM. Functions creating large values
+// should call it before allocating them; the VM accounts the returned value
+// itself.
+func (c *CallContext) CheckMemory(size int64) error {
+	if c == nil || c.vm == nil {
+		return nil
+	}
+	return c.vm.checkMemory(size)
+}
+
+// alloc counts the object created by an instruction against the limits of
+// the VM.
+func (v *VM) alloc(o Object) error {
+	return v.allocSize(o, objectSize(o))
+}
+
+// allocShared is like alloc for objects sharing their values with existing
+// objects, e.g. slices.
+func (v *VM) allocShared(o Object) error {
+	return v.allocSize(o, objectHeaderSize)
+}
+
+// allocResult is like alloc for the value returned by a Go function. The
+// elements shared with an array argument, e.g. by append, are not counted
+// again.
+func (v *VM) allocResult(ret Object, args []Object) error {
+	size := objectSize(ret)
+	for _, arg := range args {
+		if arg == ret {
+			size = 0
+			break
+		}
+		if a, ok := arg.(*Array); ok && len(a.Value) > 0 {
+			if r, ok := ret.(*Array); ok && len(r.Value) >= len(a.Value) &&
+				&r.Value[0] == &a.Value[0] {
+				size -= int64(len(a.Value)) * objectSlotSize
+				break
+			}
+		}
+	}
+	return v.allocSize(ret, size)
+}
+
+func (v *VM) allocSize(o Object, size int64) error {
+	v.allocs--
+	if v.allocs == 0 {
+		return ErrObjectAllocLimit
+	}
+	switch o := o.(type) {
+	case *String:
+		if len(o.Value) > v.maxStrLen {
+			return ErrStringLimit
+		}
+	case *Bytes:
+		if len(o.Value) > v.maxBytesLen {
+			return ErrBytesLimit
+		}
+	}
+	if v.maxMemory >= 0 {
+		v.memory += size
+		if v.memory > v.maxMemory {
+			return ErrMemoryLimit
+		}
+	}
+	return nil
+}
+
+// binaryOp returns the result of the binary operation dispatched through
+// Object.BinaryOp, and counts the allocation of the result.
+func (v *VM) binaryOp(tok token.Token, left, right Object) (Object, error) {
+	if tok == token.Add {
+		if err := v.checkConcat(left, right); err != nil {
+			return nil, err
+		}
+	}
+	res, err := left.BinaryOp(tok, right)
+	if err != nil {
+		if err == ErrInvalidOperator {
+			return nil, fmt.Errorf("invalid operation: %s %s %s",
+				left.TypeName(), tok.String(), right.TypeName())
+		}
+		return nil, err
+	}
+	if err := v.alloc(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// intBinaryOp is the fast path of binaryOp for the arithmetic and comparison
+// operations on two integers. It returns false if the operands are not
+// integers or if the operation has no fast path, e.g. the division which may
+// panic. The result is the same as the one of Int.BinaryOp; its allocation is
+// counted by the callers with v.allocInt.
+func intBinaryOp(tok token.Token, left, right Object) (Object, bool) {
+	l, ok := left.(*Int)
+	if !ok {
+		return nil, false
+	}
+	r, ok := right.(*Int)
+	if !ok {
+		return nil, false
+	}
+
+	var res int64
+	switch tok {
+	case token.Add:
+		res = l.Value + r.Value
+	case token.Sub:
+		res = l.Value - r.Value
+	case token.Mul:
+		res = l.Value * r.Value
+	case token.And:
+		res = l.Value & r.Value
+	case token.Or:
+		res = l.Value | r.Value
+	case token.Xor:
+		res = l.Value ^ r.Value
+	case token.AndNot:
+		res = l.Value &^ r.Value
+	case token.Less:
+		return boolObject(l.Value < r.Value), true
+	case token.LessEq:
+		return boolObject(l.Value <= r.Value), true
+	case token.Greater:
+		return boolObject(l.Value > r.Value), true
+	case token.GreaterEq:
+		return boolObject(l.Value >= r.Value), true
+	default:
+		return nil, false
+	}
+	if res == l.Value {
+		return l, true
+	}
+	return &Int{Value: res}, true
+}
+
+func boolObject(b bool) Object {
+	if b {
+		return TrueValue
+	}
+	return FalseValue
+}
+
+// allocInt is like alloc for the integers and the booleans of the fast
+// paths, which have no value to check against the limits.
+func (v *VM) allocInt() error {
+	v.allocs--
+	if v.allocs == 0 {
+		return ErrObjectAllocLimit
+	}
+	if v.maxMemory >= 0 {
+		v.memory += objectHeaderSize
+		if v.memory > v.maxMemory {
+			return ErrMemoryLimit
+		}
+	}
+	return nil
+}
+
+// growMemory counts size more bytes used by an existing object, e.g. by a new
+// key of a map, against the memory limit.
+func (v *VM) growMemory(size int64) error {
+	if v.maxMemory >= 0 {
+		v.memory += size
+		if v.memory > v.maxMemory {
+			return ErrMemoryLimit
+		}
+	}
+	return nil
+}
+
+// checkMemory returns ErrMemoryLimit if allocating size more bytes would
+// exceed the memory limit.
+func (v *VM) checkMemory(size int64) error {
+	if v.maxMemory >= 0 && v.memory+size > v.maxMemory {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// selectCached returns the member of the builtin module selected by the key,
+// using the inline cache at the index.
+func (v *VM) selectCached(index int, m *ImmutableMap, key Object) Object {
+	if index >= len(v.caches) {
+		caches := make([]selectorCache, index+1, 2*index+1)
+		copy(caches, v.caches)
+		v.caches = caches
+	}
+	c := &v.caches[index]
+	if c.m != m || c.key != key {
+		val, err := m.IndexGet(key)
+		if err != nil {
+			// the key is always a string
+			val = UndefinedValue
+		}
+		c.m, c.key, c.val = m, key, val
+	}
+	return c.val
+}
+
+// indexGet returns the value of left at the index, or UndefinedValue if it
+// has no such value.
+func indexGet(left, index Object) (Object, error) {
+	val, err := left.IndexGet(index)
+	if err != nil {
+		if err == ErrNotIndexable {
+			return nil, fmt.Errorf("not indexable: %s", index.TypeName())
+		}
+		if err == ErrInvalidIndexType {
+			return nil, fmt.Errorf("invalid index type: %s",
+				index.TypeName())
+		}
+		return nil, err
+	}
+	if val == nil {
+		val = UndefinedValue
+	}
+	return val, nil
+}
+
+// checkConcat checks the limits before concatenating the values, so that
+// large values are not allocated.
+func (v *VM) checkConcat(left, right Object) error {
+	switch left := left.(type) {
+	case *String:
+		if right, ok := right.(*String); ok {
+			n := len(left.Value) + len(right.Value)
+			if n > v.maxStrLen {
+				return ErrStringLimit
+			}
+			return v.checkMemory(objectHeaderSize + int64(n))
+		}
+	case *Bytes:
+		if right, ok := right.(*Bytes); ok {
+			n := len(left.Value) + len(right.Value)
+			if n > v.maxBytesLen {
+				return ErrBytesLimit
+			}
+			return v.checkMemory(objectHeaderSize + int64(n))
+		}
+	case *Array:
+		if right, ok := right.(*Array); ok {
+			n := len(left.Value) + len(right.Value)
+			return v.checkMemory(objectHeaderSize + int64(n)*objectSlotSize)
+		}
+	}
+	return nil
+}
+
+// isTailCall returns true if the call of the callee at the current
+// instruction is in tail position, i.e. its result is returned by the caller,
+// and the current frame can be reused by the callee. A recursive call followed
+// by a return without a value is a tail call too, and the frame is marked to
+// return undefined instead of the result of the callee. The frame must not be
+// reused while it has an active error handler, nor if it's the frame of a
+// generator, which must end when it returns, or the entry frame.
+func (v *VM) isTailCall(callee *CompiledFunction) bool {
+	switch v.curInsts[v.ip+1] {
+	case parser.OpReturn:
+		if v.curInsts[v.ip+2] != 1 {
+			return false
+		}
+	case parser.OpPop:
+		if v.curInsts[v.ip+2] != parser.OpReturn ||
+			callee != v.curFrame.fn {
+			return false
+		}
+	default:
+		return false
+	}
+	return v.framesIndex > 1 && v.curFrame.gen == nil && !v.frameHasHandler()
+}
+
+// frameHasHandler returns true if the current frame has an active error
+// handler.
+func (v *VM) frameHasHandler() bool {
+	n := len(v.handlers)
+	return n > v.handlerBase && v.handlers[n-1].framesIndex == v.framesIndex
+}
+
 // IsStackEmpty tests if the stack is empty or not.
 func (v *VM) IsStackEmpty() bool {
 	return v.sp == 0
 }
 
-func indexAssign(dst, src Object, selectors []Object) error {
+func (v *VM) indexAssign(dst, src Object, selectors []Object) error {
 	numSel := len(selectors)
 	for sidx := numSel - 1; sidx > 0; sidx-- {
 		next, err := dst.IndexGet(selectors[sidx])
@@ -898,6 +1966,18 @@ func indexAssign(dst, src Object, selectors []Object) error {
 		dst = next
 	}
 
+	// a new key grows the map like an element grows an array by append
+	if m, ok := dst.(*Map); ok && v.maxMemory >= 0 {
+		if key, ok := ToString(selectors[0]); ok {
+			if _, found := m.Value[key]; !found {
+				err := v.growMemory(2*objectSlotSize + int64(len(key)))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	if err := dst.IndexSet(selectors[0], src); err != nil {
 		if err == ErrNotIndexAssignable {
 			return fmt.Errorf("not index-assignable: %s", dst.TypeName())
@@ -938,6 +938,105 @@ c := func(a) {
 }
 b(a, c)
 `, nil, "Runtime Error: not callable: int\n\tat test:7:4\n\tat test:3:4\n\tat test:9:1")
+
+	// the frame of the caller is reused by a tail call
+	expectError(t, `a := 1
+b := func(a, c) {
+   return c(a)
+}
+
+c := func(a) {
+   a()
+}
+b(a, c)
+`, nil, "Runtime Error: not callable: int\n\tat test:7:4\n\tat test:9:1")
+}
+
+func TestCallContext(t *testing.T) {
+	fnModule := &slim.BuiltinModule{
+		Attrs: map[string]slim.Object{
+			"map": &slim.ContextFunction{
+				Name: "map",
+				Value: func(
+					ctx *slim.CallContext,
+					args ...slim.Object,
+				) (slim.Object, error) {
+					if len(args) != 2 {
+						return nil, slim.ErrWrongNumArguments
+					}
+					arr, ok := args[0].(*slim.Array)
+					if !ok {
+						return nil, slim.ErrInvalidArgumentType{
+							Name:     "first",
+							Expected: "array",
+							Found:    args[0].TypeName(),
+						}
+					}
+					res := &slim.Array{}
+					for _, elem := range arr.Value {
+						v, err := ctx.Invoke(args[1], elem)
+						if err != nil {
+							return nil, err
+						}
+						res.Value = append(res.Value, v)
+					}
+					return res, nil
+				},
+			},
+		},
+	}
+	opts := Opts().Module("fn", fnModule)
+
+	expectRun(t, `
+fn := import("fn")
+out = fn.map([1, 2, 3], func(x) { return x * 2 })`, opts, ARR{2, 4, 6})
+
+	// closures and builtin functions
+	expectRun(t, `
+fn := import("fn")
+n := 10
+f := func() {
+	m := 5
+	return fn.map([1, 2, 3], func(x) { m += x; return x + n + m })
+}
+out = f()`, opts, ARR{17, 20, 24})
+	expectRun(t, `
+fn := import("fn")
+out = fn.map(["a", "bc", [1, 2, 3]], len)`, opts, ARR{1, 2, 3})
+
+	// nested callbacks
+	expectRun(t, `
+fn := import("fn")
+out = fn.map([1, 2], func(x) {
+	return fn.map([10, 20], func(y) { return x + y })
+})`, opts, ARR{ARR{11, 21}, ARR{12, 22}})
+
+	// callbacks can recurse
+	expectRun(t, `
+fn := import("fn")
+fib := func(x) {
+	if x < 2 { return x }
+	return fn.map([x - 1, x - 2], fib)[0] + fib(x - 2)
+}
+out = fib(10)`, opts, 55)
+
+	// errors are reported with the positions of the callback frames
+	expectError(t, `fn := import("fn")
+fn.map([1, 2], func(x) {
+	return x + "foo"
+})`, opts.Skip2ndPass(), "Runtime Error: invalid operation: int + string\n"+
+		"\tat test:3:9\n\tat test:2:1")
+	expectError(t, `fn := import("fn")
+fn.map([1, 2], func(x, y) {})`, opts,
+		"Runtime Error: wrong number of arguments: want=2, got=1")
+	expectError(t, `fn := import("fn")
+fn.map([1, 2], 5)`, opts, "Runtime Error: not callable: int")
+
+	// compiled functions cannot be invoked outside VM
+	_, err := fnModule.Attrs["map"].Call(
+		&slim.Array{Value: []slim.Object{&slim.Int{Value: 1}}},
+		&slim.CompiledFunction{})
+	require.True(t, errors.Is(err, slim.ErrNoRunningVM))
 }
 
 func TestChar(t *testing.T) {
@@ -2839,20 +2938,22 @@ func TestBangOperator(t *testing.T) {
 
 func TestObjectsLimit(t *testing.T) {
 	testAllocsLimit(t, `5`, 0)
-	testAllocsLimit(t, `5 + 5`, 1)
+	testAllocsLimit(t, `a := 5; a + 5`, 1)
 	testAllocsLimit(t, `a := [1, 2, 3]`, 1)
 	testAllocsLimit(t, `a := 1; b := 2; c := 3; d := [a, b, c]`, 1)
 	testAllocsLimit(t, `a := {foo: 1, bar: 2}`, 1)
 	testAllocsLimit(t, `a := 1; b := 2; c := {foo: a, bar: b}`, 1)
 	testAllocsLimit(t, `
 f := func() {
-	return 5 + 5
+	a := 5
+	return a + 5
 }
 a := f() + 5
 `, 2)
 	testAllocsLimit(t, `
 f := func() {
-	return 5 + 5
+	a := 5
+	return a + 5
 }
 a := f()
 `, 1)
@@ -3469,6 +3570,36 @@ func TestString(t *testing.T) {
 	expectError(t, `"foo" - "bar"`, nil, "invalid operation")
 }
 
+func TestStringInterpolation(t *testing.T) {
+	expectRun(t, `n := 3; out = "${n}"`, nil, "3")
+	expectRun(t, `user := {name: "bob"}; n := 3
+out = "hello ${user.name}, you have ${n} items"`,
+		nil, "hello bob, you have 3 items")
+	expectRun(t, `a := [1, 2]; out = "${a[0] + a[1]}!"`, nil, "3!")
+	expectRun(t, `m := {k: "v"}; out = "<${m["k"]}>"`, nil, "<v>")
+	expectRun(t, `x := "in"; out = "a ${"b ${x} c"} d"`, nil, "a b in c d")
+	expectRun(t, `f := func(x) { return x * 2 }; out = "${f(2)}${f(3)}"`,
+		nil, "46")
+	expectRun(t, `out = "${'x'} ${1.5} ${true} ${undefined}"`,
+		nil, "x 1.5 true <undefined>")
+	expectRun(t, `out = "${[1, "a"]}"`, nil, `[1, "a"]`)
+	expectRun(t, `x := {}; out = "${len(x) == 0 ? "empty" : "full"}"`,
+		nil, "empty")
+
+	// escapes
+	expectRun(t, `n := 1; out = "\${n}"`, nil, "${n}")
+	expectRun(t, `n := 1; out = "\\${n}"`, nil, `\1`)
+	expectRun(t, `out = "$ {n} $"`, nil, "$ {n} $")
+	expectRun(t, "n := 1; out = `${n}`", nil, "${n}")
+	expectRun(t, `out = "\t\$"`, nil, "\t$")
+
+	expectError(t, `a := "${b}"`, nil,
+		"Compile Error: unresolved reference 'b'\n\tat test:1:9")
+	expectError(t, `x := 1
+a := "a ${x} ${x + {}}"`, nil,
+		"Runtime Error: invalid operation: int + map\n\tat test:2:16")
+}
+
 func TestTailCall(t *testing.T) {
 	expectRun(t, `
 	fac := func(n, a) {
@@ -3561,6 +3692,104 @@ out = c
 `, nil, 9999)
 }
 
+func TestTailCallMutual(t *testing.T) {
+	// a call followed by a return without a value is not a tail call, so
+	// the caller returns undefined instead of the result of the callee
+	expectRun(t, `
+f := func() { return 5 }
+g := func() { f() }
+out = g()`, nil, slim.UndefinedValue)
+	expectRun(t, `
+f := func(x) { return x }
+g := func(x) { if x > 0 { f(x) } }
+out = [g(1), g(0)]`, nil, ARR{slim.UndefinedValue, slim.UndefinedValue})
+	expectRun(t, `
+g := func(n) { if n > 0 { return 5 }; g(n+1) }
+out = g(0)`, nil, slim.UndefinedValue)
+
+	// mutual recursion beyond the maximum number of frames
+	expectRun(t, `
+even := undefined
+odd := func(n) {
+	if n == 0 { return false }
+	return even(n-1)
+}
+even = func(n) {
+	if n == 0 { return true }
+	return odd(n-1)
+}
+out = [even(10000), odd(10001), even(9999)]`, nil, ARR{true, true, false})
+
+	// state machine of closures with free variables and different numbers
+	// of locals
+	expectRun(t, `
+run := func(input) {
+	count := 0
+	stateA := undefined
+	stateB := func(i) {
+		if i == len(input) { return "B" }
+		count++
+		x := input[i]; y := x + 1; z := y + 1
+		if z == 3 { return stateA(i+1) }
+		return stateB(i+1)
+	}
+	stateA = func(i) {
+		if i == len(input) { return "A" }
+		if input[i] == 0 { return stateB(i+1) }
+		return stateA(i+1)
+	}
+	return [stateA(0), count]
+}
+input := []
+for i := 0; i < 5000; i++ { input = append(input, i % 2) }
+out = run(input)`, nil, ARR{"A", 2500})
+
+	// variadic callee and spread arguments
+	expectRun(t, `
+sum := undefined
+f := func(n, s) {
+	if n == 0 { return s }
+	return sum(n, s, 1, 1)
+}
+sum = func(n, s, ...a) {
+	return f(n-1, s+len(a))
+}
+out = f(5000, 0)`, nil, 10000)
+	expectRun(t, `
+g := undefined
+f := func(n) {
+	if n == 0 { return "done" }
+	return g([n, 1]...)
+}
+g = func(n, m) { return f(n-m) }
+out = f(5000)`, nil, "done")
+
+	// calls in a try block are not tail calls
+	expectRun(t, `
+f := func(n) {
+	if n == 0 { return 1 + {} }
+	return n
+}
+g := func() {
+	try {
+		return f(0)
+	} catch e {
+		return "caught"
+	}
+}
+out = g()`, nil, "caught")
+
+	// generators are not replaced by the callee
+	expectRun(t, `
+f := func(n) { return n * 2 }
+gen := func(n) {
+	yield n
+	return f(n)
+}
+out = []
+for v in gen(3) { out = append(out, v) }`, nil, ARR{3})
+}
+
 // tail call with free vars
 func TestTailCallFreeVars(t *testing.T) {
 	expectRun(t, `
@@ -3577,6 +3806,655 @@ func() {
 }()`, nil, 25)
 }
 
+func TestTryCatch(t *testing.T) {
+	expectRun(t, `
+try {
+	out = 1 + "a"
+} catch e {
+	out = e.value.message
+}`, nil, "invalid operation: int + string")
+	expectRun(t, `
+try {
+	out = 1
+} catch e {
+	out = 2
+}`, nil, 1)
+	expectRun(t, `
+out = 0
+try {
+	out = 1
+	a := {} - 1
+	out = 2
+} catch {
+	out += 10
+}`, nil, 11)
+	expectRun(t, `
+try {
+	x := 1 + {}
+} catch e {
+	out = [is_error(e), e.value.position]
+}`, Opts().Skip2ndPass(), ARR{true, "test:3:7"})
+
+	// errors raised by nested calls unwind the frames
+	expectRun(t, `
+f := func(x) { return x + {} }
+g := func(x) { return f(x) + 1 }
+try {
+	out = g(1)
+} catch e {
+	out = e.value.message
+}`, nil, "invalid operation: int + map")
+	expectRun(t, `
+f := func(x) {
+	try {
+		return x + {}
+	} catch e {
+		return -x
+	}
+}
+out = f(1) + f(2)`, nil, -3)
+
+	// handlers of returned frames are discarded
+	expectRun(t, `
+f := func() {
+	try {
+		return 1
+	} catch {}
+}
+out = 0
+try {
+	f()
+	out = 1 + {}
+} catch {
+	out = 5
+}`, nil, 5)
+
+	// nested try statements
+	expectRun(t, `
+out = []
+try {
+	try {
+		a := 1 + {}
+	} catch {
+		out = append(out, "inner")
+		b := 1 + {}
+	}
+} catch {
+	out = append(out, "outer")
+}`, nil, ARR{"inner", "outer"})
+
+	// break and continue out of try blocks
+	expectRun(t, `
+out = 0
+for i := 0; i < 5; i++ {
+	try {
+		if i == 1 { continue }
+		if i == 3 { break }
+		out += i
+	} catch {}
+}
+try {
+	a := 1 + {}
+} catch {
+	out += 10
+}`, nil, 12)
+	expectRun(t, `
+out = []
+for x in [1, "a", 3] {
+	try {
+		out = append(out, x * 2)
+	} catch e {
+		out = append(out, -1)
+	}
+}`, nil, ARR{2, -1, 6})
+
+	// tail-calls inside try blocks
+	expectRun(t, `
+f := func(n) {
+	try {
+		if n == 0 { return 1 + {} }
+		return f(n - 1)
+	} catch {
+		return n
+	}
+}
+out = f(3)`, nil, 0)
+
+	// host function errors
+	expectRun(t, `
+try {
+	out = len(1, 2)
+} catch e {
+	out = e.value.message
+}`, nil, "wrong number of arguments in call to 'builtin-function:len'")
+
+	// uncaught errors
+	expectError(t, `
+try {} catch {}
+a := 1 + {}`, nil, "invalid operation: int + map")
+	expectError(t, `
+try {
+	a := 1 + {}
+} catch e {
+	b := e + 1
+}`, nil, "invalid operation: error + int")
+
+	// allocation limit cannot be caught
+	expectErrorIs(t, `
+try {
+	for {
+		a := [1, 2]
+	}
+} catch {}`, Opts().MaxAllocs(100).Skip2ndPass(), slim.ErrObjectAllocLimit)
+}
+
+func TestErrorPropagation(t *testing.T) {
+	expectRun(t, `
+f := func(x) { return x > 0 ? x : error("negative") }
+g := func(x) { return f(x)? * 2 }
+out = [g(2), g(-1)]`, nil, ARR{4, &slim.Error{
+		Value: &slim.String{Value: "negative"}}})
+	expectRun(t, `
+f := func(x) {
+	a := x?
+	out = "ok"
+	return a
+}
+out = f(1)`, nil, 1)
+	expectRun(t, `
+f := func(x) {
+	a := x?
+	out = "ok"
+	return a
+}
+out = "x"
+f(error(1))`, nil, "x")
+
+	// propagates from loops, nested blocks and try blocks
+	expectRun(t, `
+f := func(arr) {
+	s := 0
+	for x in arr {
+		if true {
+			s += x?
+		}
+	}
+	return s
+}
+out = [f([1, 2, 3]), is_error(f([1, error(2), 3]))]`, nil, ARR{6, true})
+	expectRun(t, `
+f := func() {
+	try {
+		error("oops")?
+	} catch {
+		return "caught"
+	}
+	return "not propagated"
+}
+out = is_error(f()) ? "propagated" : "?"
+try {
+	a := 1 + {}
+} catch {
+	out += "!"
+}`, nil, "propagated!")
+
+	// stdlib functions returning errors
+	expectRun(t, `
+text := import("text")
+f := func(s) {
+	n := text.atoi(s)?
+	return n + 1
+}
+out = [f("1"), is_error(f("x"))]`, Opts().Stdlib(), ARR{2, true})
+
+	expectError(t, `a := error(1)?`, nil,
+		"error propagation not allowed outside function")
+}
+
+func TestSwitch(t *testing.T) {
+	expectRun(t, `
+f := func(x) {
+	switch x {
+	case 1:
+		return "one"
+	case 2, 3:
+		return "two or three"
+	case "a":
+		return "string"
+	case 'c':
+		return "char"
+	default:
+		return "other"
+	}
+}
+out = [f(1), f(2), f(3), f("a"), f('c'), f(4), f(1.0), f("b")]`, nil,
+		ARR{"one", "two or three", "two or three", "string", "char",
+			"other", "other", "other"})
+
+	// non-constant cases
+	expectRun(t, `
+a := 5
+f := func(x) {
+	switch x {
+	case a:
+		return "a"
+	case a + 1, -1:
+		return "a+1 or -1"
+	case 1.5:
+		return "float"
+	}
+	return "none"
+}
+out = [f(5), f(6), f(-1), f(1.5), f(0)]`, nil,
+		ARR{"a", "a+1 or -1", "a+1 or -1", "float", "none"})
+
+	// tagless and init statements
+	expectRun(t, `
+f := func(x) {
+	switch {
+	case x < 0:
+		return "negative"
+	case x == 0, x == 1:
+		return "small"
+	default:
+		return "large"
+	}
+}
+out = [f(-3), f(0), f(1), f(10)]`, nil,
+		ARR{"negative", "small", "small", "large"})
+	expectRun(t, `
+switch x := 3; x * 2 {
+case 6:
+	out = x
+}`, nil, 3)
+	expectRun(t, `
+switch x := 3; {
+case x > 2:
+	out = "yes"
+}`, nil, "yes")
+
+	// default can be placed anywhere
+	expectRun(t, `
+switch 5 {
+default:
+	out = "default"
+case 4:
+	out = 4
+}`, nil, "default")
+	expectRun(t, `
+switch 4 {
+default:
+	out = "default"
+case 4:
+	out = 4
+}`, nil, 4)
+	expectRun(t, `out = 1; switch 2 { case 3: out = 3 }`, nil, 1)
+	expectRun(t, `out = 1; switch 2 {}`, nil, 1)
+
+	// case scopes
+	expectRun(t, `
+x := 1
+switch 1 {
+case 1:
+	x := 2
+	out = x
+case 2:
+	x := 3
+}
+out += x`, nil, 3)
+
+	// break leaves the switch, continue applies to the loop
+	expectRun(t, `
+out = []
+for i := 0; i < 6; i++ {
+	switch i % 3 {
+	case 0:
+		if i > 2 { break }
+		out = append(out, "zero")
+	case 1:
+		continue
+	default:
+		out = append(out, i)
+	}
+	out = append(out, "-")
+}`, nil, ARR{"zero", "-", 2, "-", "-", 5, "-"})
+	expectRun(t, `
+out = 0
+for x in [1, 2, 3] {
+	switch {
+	case x == 2:
+		try {
+			break
+		} catch {}
+	}
+	out += x
+}
+try {
+	a := 1 + {}
+} catch {
+	out += 10
+}`, nil, 16)
+
+	// type switch
+	expectRun(t, `
+f := func(x) {
+	switch x.(type) {
+	case int, float:
+		return "number"
+	case string, char:
+		return "text"
+	case error:
+		return "error"
+	case undefined:
+		return "undefined"
+	case "immutable-array", array:
+		return "array"
+	default:
+		return type_name(x)
+	}
+}
+out = [f(1), f(1.5), f("s"), f('c'), f(error(1)), f(undefined),
+	f([1]), f(immutable([1])), f({})]`, nil,
+		ARR{"number", "number", "text", "text", "error", "undefined",
+			"array", "array", "map"})
+
+	expectError(t, `switch 1 { default: a := 1; default: b := 2 }`, nil,
+		"multiple defaults in switch")
+	expectError(t, `switch 1 { case 1, 2: a := 1; case 2: b := 2 }`, nil,
+		"duplicate case 2 in switch")
+	expectError(t, `switch 1 { case 1: continue }`, nil,
+		"continue not allowed outside loop")
+	expectError(t, `switch x := 1; x.(type) { case 1 + 2: }`, nil,
+		"invalid type in type switch case")
+	expectError(t, `a := 1; b := a.(type)`, nil,
+		"use of .(type) outside type switch")
+}
+
+func TestDestructuring(t *testing.T) {
+	// multiple values
+	expectRun(t, `a, b := 1, 2; out = [a, b]`, nil, ARR{1, 2})
+	expectRun(t, `a, b := 1, 2; a, b = b, a; out = [a, b]`, nil, ARR{2, 1})
+	expectRun(t, `
+a := 1
+func() {
+	a, b := 2, 3
+	out = [a, b]
+}()
+out = append(out, a)`, nil, ARR{2, 3, 1})
+	expectRun(t, `
+m := {x: 1}
+arr := [1, 2]
+m.x, arr[1], _ = "a", "b", "c"
+out = [m, arr]`, nil, ARR{MAP{"x": "a"}, ARR{1, "b"}})
+
+	// multiple return values
+	expectRun(t, `
+f := func(x) {
+	if x < 0 {
+		return undefined, error("negative")
+	}
+	return x * 2, undefined
+}
+a, err := f(2)
+out = [a, err]
+b, err := f(-1)
+out = append(out, b, is_error(err))`, nil,
+		ARR{4, slim.UndefinedValue, slim.UndefinedValue, true})
+	expectRun(t, `
+f := func() { return 1, 2, 3 }
+out = f()`, nil, ARR{1, 2, 3})
+	expectRun(t, `
+func() {
+	f := func() { return 1, 2 }
+	a, b := f()
+	c, _, d := f()
+	out = [a, b, c, d]
+}()`, nil, ARR{1, 2, 1, slim.UndefinedValue})
+
+	// array and map patterns
+	expectRun(t, `[x, y] := [1, 2, 3]; out = x + y`, nil, 3)
+	expectRun(t, `[x, _, y] := immutable([1, 2, 3]); out = x + y`, nil, 4)
+	expectRun(t, `x := 0; [x, y] := [1]; out = [x, y]`, nil,
+		ARR{1, slim.UndefinedValue})
+	expectRun(t, `
+{name, age} := {name: "kim", age: 20, extra: true}
+out = name + ":" + string(age)`, nil, "kim:20")
+	expectRun(t, `
+func() {
+	{name: n, missing} := immutable({name: "kim"})
+	out = [n, missing]
+}()`, nil, ARR{"kim", slim.UndefinedValue})
+	expectRun(t, `
+a := 0; b := 0
+[a, b] = [b + 1, a + 2]
+{a} = {a: a * 10}
+out = [a, b]`, nil, ARR{10, 2})
+
+	// map literal shorthand
+	expectRun(t, `name := "kim"; out = {name, age: 20}`, nil,
+		MAP{"name": "kim", "age": 20})
+
+	expectError(t, `[a, b] := 1`, nil, "not indexable: int")
+	expectError(t, `{a} := [1]`, nil, "invalid index type: string")
+	expectError(t, `a, b := 1`, nil, "not indexable: int")
+	expectError(t, `a, a := 1, 2`, nil, "'a' repeated on left side of :=")
+	expectError(t, `a := 1; b := 2; [a, b] += [1, 2]`, nil,
+		"operator '+=' not allowed with multiple assignment")
+	expectError(t, `[a, 1] := [1, 2]`, nil, "cannot assign to 1")
+	expectError(t, `a := 1; a, b = 1, 2`, nil, "unresolved reference 'b'")
+}
+
+func TestOptionalChaining(t *testing.T) {
+	expectRun(t, `m := {a: {b: 5}}; out = m?.a?.b`, nil, 5)
+	expectRun(t, `m := undefined; out = m?.a`, nil, slim.UndefinedValue)
+	expectRun(t, `m := {}; out = m.a?.b.c`, nil, slim.UndefinedValue)
+	expectRun(t, `m := {a: [1, 2, 3]}; out = m.a?.[1]`, nil, 2)
+	expectRun(t, `m := {}; out = m.a?.[1]`, nil, slim.UndefinedValue)
+	expectRun(t, `m := {a: [1, 2, 3]}; out = m.a?.[1:]`, nil, ARR{2, 3})
+	expectRun(t, `m := {}; out = m.a?.[1:]`, nil, slim.UndefinedValue)
+
+	// calls are skipped, and so are their arguments
+	expectRun(t, `m := {f: func(x) { return x + 1 }}; out = m.f?.(1)`, nil, 2)
+	expectRun(t, `
+n := 0
+inc := func() { n++; return n }
+m := {}
+m.f?.(inc())
+out = n`, nil, 0)
+	expectRun(t, `m := {}; out = m.f?.().g.h()`, nil, slim.UndefinedValue)
+	expectRun(t, `m := {}; out = (m.a?.b)?.c`, nil, slim.UndefinedValue)
+
+	// only undefined short-circuits
+	expectError(t, `m := {a: false}; m.a?.b`, nil, "not indexable")
+	expectRun(t, `m := {a: 0}; out = m?.a`, nil, 0)
+	expectError(t, `m := {a: 1}; m.a?.()`, nil, "not callable: int")
+
+	// nested chains in arguments
+	expectRun(t, `m := {a: {f: func(x) { return x }}}; out = m.a?.f(m.b?.c)`,
+		nil, slim.UndefinedValue)
+	expectRun(t, `
+f := func(m) { return m?.a ?? "none" }
+out = [f({a: 1}), f(undefined), f({})]`, nil, ARR{1, "none", "none"})
+
+	expectError(t, `m := {}; m?.a = 1`, nil, "cannot assign to m?.a")
+	expectError(t, `m := {a: {}}; m.a?.b += 1`, nil, "cannot assign to m.a?.b")
+}
+
+func TestCoalesce(t *testing.T) {
+	expectRun(t, `out = undefined ?? 1`, nil, 1)
+	expectRun(t, `out = 2 ?? 1`, nil, 2)
+	expectRun(t, `out = false ?? 1`, nil, false)
+	expectRun(t, `out = 0 ?? 1`, nil, 0)
+	expectRun(t, `out = "" ?? 1`, nil, "")
+	expectRun(t, `out = undefined ?? undefined ?? 3`, nil, 3)
+	expectRun(t, `m := {}; out = m.a ?? m.b ?? "c"`, nil, "c")
+	expectRun(t, `out = undefined ?? 1 + 2`, nil, 3)
+	expectRun(t, `out = undefined ?? false || true`, nil, true)
+	expectRun(t, `out = 1 ?? 2 ? "y" : "n"`, nil, "y")
+
+	// right side is not evaluated
+	expectRun(t, `
+n := 0
+inc := func() { n++; return n }
+a := 5 ?? inc()
+out = n`, nil, 0)
+}
+
+func TestGenerator(t *testing.T) {
+	expectRun(t, `
+count := func(n) {
+	for i := 0; i < n; i++ {
+		yield i
+	}
+}
+out = []
+for x in count(3) { out = append(out, x) }`, nil, ARR{0, 1, 2})
+
+	// keys are the indexes of the yielded values
+	expectRun(t, `
+letters := func() { yield "a"; yield "b" }
+out = {}
+for i, x in letters() { out[x] = i }`, nil, MAP{"a": 0, "b": 1})
+
+	// the body does not run until iterated
+	expectRun(t, `
+n := 0
+gen := func() { n++; yield n }
+g := gen()
+out = [n, type_name(g)]`, nil, ARR{0, "generator"})
+
+	// lazy pipelines
+	expectRun(t, `
+naturals := func() {
+	i := 0
+	for { yield i; i++ }
+}
+filter := func(seq, fn) {
+	for x in seq { if fn(x) { yield x } }
+}
+take := func(seq, n) {
+	if n <= 0 { return }
+	for x in seq {
+		yield x
+		n--
+		if n == 0 { return }
+	}
+}
+out = []
+evens := filter(naturals(), func(x) { return x % 2 == 0 })
+for x in take(evens, 4) { out = append(out, x) }`, nil, ARR{0, 2, 4, 6})
+
+	// closures, free variables and variadic parameters
+	expectRun(t, `
+make := func(step) {
+	return func(...xs) {
+		for x in xs { yield x * step }
+	}
+}
+out = []
+for x in make(10)(1, 2, 3) { out = append(out, x) }`, nil, ARR{10, 20, 30})
+	expectRun(t, `
+gen := func() {
+	a := 1
+	inc := func() { a++ }
+	yield a
+	inc()
+	yield a
+}
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{1, 2})
+
+	// breaking a loop keeps the generator state
+	expectRun(t, `
+gen := func() { yield 1; yield 2; yield 3 }
+g := gen()
+out = []
+for x in g { out = append(out, x); break }
+for x in g { out = append(out, x) }
+for x in g { out = append(out, x) }`, nil, ARR{1, 2, 3})
+
+	// yield without value, return ends the generator
+	expectRun(t, `
+gen := func() { yield; return 5; yield 1 }
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{slim.UndefinedValue})
+	expectRun(t, `
+gen := func() {}
+out = 0
+for x in gen() { out++ }`, nil, 0)
+	expectRun(t, `
+gen := func() { if false { yield 1 } }
+out = 0
+for x in gen() { out++ }`, nil, 0)
+
+	// nested generators and recursion
+	expectRun(t, `
+walk := func(node) {
+	if is_array(node) {
+		for child in node {
+			for x in walk(child) { yield x }
+		}
+	} else {
+		yield node
+	}
+}
+out = []
+for x in walk([1, [2, [3, 4]], 5]) { out = append(out, x) }`,
+		nil, ARR{1, 2, 3, 4, 5})
+
+	// try statements across yields
+	expectRun(t, `
+gen := func() {
+	try {
+		yield 1
+		yield 1 + {}
+	} catch e {
+		yield "caught"
+	}
+}
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{1, "caught"})
+	expectRun(t, `
+gen := func() { yield 1; yield 1 + {} }
+out = []
+try {
+	for x in gen() { out = append(out, x) }
+} catch e {
+	out = append(out, "caught")
+}`, nil, ARR{1, "caught"})
+	expectRun(t, `
+gen := func() { yield 1; yield 1 + {}; yield 2 }
+g := gen()
+out = []
+try {
+	for x in g { out = append(out, x) }
+} catch e {}
+for x in g { out = append(out, x) }`, nil, ARR{1})
+
+	// generators run in callbacks and called functions
+	expectRun(t, `
+gen := func() { yield 1; yield 2 }
+sum := func(seq) {
+	s := 0
+	for x in seq { s += x }
+	return s
+}
+out = sum(gen())`, nil, 3)
+
+	expectError(t, `
+var := undefined
+gen := func() { for x in var { yield x } }
+var = gen()
+for x in var {}`, nil, "generator already running")
+	expectError(t, `
+gen := func() { yield 1; yield 1 + {} }
+for x in gen() {}`, nil,
+		"Runtime Error: invalid operation: int + map\n\tat test:2:32\n\tat test:3:1")
+	expectError(t, `yield 1`, nil, "yield not allowed outside function")
+	expectError(t, `if true { yield 1 }`, nil,
+		"yield not allowed outside function")
+}
+
 func TestSpread(t *testing.T) {
 	expectRun(t, `
 	f := func(...a) {
@@ -3628,6 +4506,15 @@ func TestSpread(t *testing.T) {
 	out = f(1, immutable([2, 3])...)
 	`, nil, ARR{1, 2, 3, 2, 3, 4})
 
+	expectRun(t, `
+	a := []
+	for i := 0; i < 200; i++ { a = append(a, i) }
+	out = len(append([], a...))`, nil, 200)
+	expectRun(t, `
+	a := []
+	for i := 0; i < 200; i++ { a = append(a, i) }
+	out = func(...b) { return len(b) }(a...)`, nil, 200)
+
 	expectError(t, `func(a) {}([1, 2]...)`, nil,
 		"Runtime Error: wrong number of arguments: want=1, got=2")
 	expectError(t, `func(a, b, c) {}([1, 2]...)`, nil,
@@ -3996,3 +4883,160 @@ func objectZeroCopy(o slim.Object) slim.Object {
 		panic(fmt.Errorf("unknown object type: %s", o.TypeName()))
 	}
 }
+
+func TestWideOperands(t *testing.T) {
+	var defs, all, last []string
+	for i := 0; i < 300; i++ {
+		defs = append(defs, fmt.Sprintf("l%d := %d", i, i))
+		all = append(all, fmt.Sprintf("l%d", i))
+	}
+	last = all[290:]
+
+	// locals and free variables beyond 256
+	expectRun(t, `
+f := func() {
+	`+strings.Join(defs, "\n\t")+`
+	l299 += 1
+	m := {}
+	m.a = 5
+	g := func() { l299 += 10; return `+strings.Join(last, " + ")+` }
+	h := func() { x := `+strings.Join(all, " + ")+`; m.b = 6; return x }
+	return [g(), h(), l299, m]
+}
+out = f()`, nil, ARR{2956, 44861, 310, MAP{"a": 5, "b": 6}})
+
+	// constants beyond 65536
+	var stmts []string
+	for i := 0; i < 66000; i++ {
+		stmts = append(stmts, fmt.Sprintf("s += %d", i))
+	}
+	c, err := slim.NewScript([]byte(`
+s := 0
+` + strings.Join(stmts, "\n") + `
+out := [s, func(x) { return x + 1 }(1)]`)).Run()
+	require.NoError(t, err)
+	require.Equal(t, "[2177967000, 2]", c.Get("out").String())
+}
+
+func TestSpecialisedOps(t *testing.T) {
+	// integer fast paths
+	expectRun(t, `
+f := func(a, b) {
+	return [a + b, a - b, a * b, a & b, a | b, a ^ b, a &^ b,
+		a < b, a <= b, a > b, a >= b, a + 0]
+}
+out = f(6, 3)`, nil, ARR{9, 3, 18, 2, 7, 5, 4,
+		false, false, true, true, 6})
+	expectRun(t, `
+f := func(a) {
+	return [a + 1, a - 1, a * 2, a / 2, a % 4, a << 1, a >> 1,
+		a < 10, a <= 6, a > 10, a >= 7, a == 6, a != 6]
+}
+out = f(6)`, nil, ARR{7, 5, 12, 3, 2, 12, 3,
+		true, true, false, false, true, false})
+
+	// other types use their binary operations
+	expectRun(t, `
+f := func(a) { return [a + 1, a < 2, a == 1, a != 1] }
+out = f(1.5)`, nil, ARR{2.5, true, false, true})
+	expectRun(t, `
+f := func(a) { return [a + 1, a == 1, a != 1] }
+out = f("a")`, nil, ARR{"a1", false, true})
+	expectError(t, `func(a) { return a < 1 }("a")`, nil,
+		"invalid operation: string < int")
+
+	// increments of local variables
+	expectRun(t, `
+f := func() {
+	i := 0; i++; i += 10; i -= 3; i--
+	f := 1.5; f++; f -= 2
+	s := "a"; s += 1
+	return [i, f, s]
+}
+out = f()`, nil, ARR{7, 0.5, "a1"})
+	expectRun(t, `
+f := func() {
+	i := 0
+	g := func() { return i }
+	i++
+	i += 5
+	return g()
+}
+out = f()`, nil, 6)
+	expectError(t, `func() { s := "a"; s -= 1 }()`, nil,
+		"invalid operation: string - int")
+
+	// index by local variables
+	expectRun(t, `
+f := func(a, i) {
+	j := i + 1
+	g := func() { return j }
+	return [a[i], a[j], g()]
+}
+out = f([1, 2, 3], 1)`, nil, ARR{2, 3, 2})
+	expectRun(t, `
+f := func(m, k) { return m[k] }
+out = [f({a: 1}, "a"), f({a: 1}, "b")]`, nil, ARR{1, slim.UndefinedValue})
+	expectError(t, `func(a, i) { return a[i] }([1], "a")`, nil,
+		"invalid index type: string")
+
+	// the fast paths count the allocations
+	testAllocsLimit(t, `
+f := func(a) {
+	a++
+	b := a + 1
+	return b < 10
+}
+c := f(1)`, 3)
+}
+
+func TestSelectorCache(t *testing.T) {
+	mod := &slim.BuiltinModule{Attrs: map[string]slim.Object{
+		"pi":   &slim.Float{Value: 3.14},
+		"name": &slim.String{Value: "mod"},
+		"ok":   slim.TrueValue,
+		"double": &slim.UserFunction{
+			Value: func(args ...slim.Object) (slim.Object, error) {
+				return &slim.Int{Value: args[0].(*slim.Int).Value * 2}, nil
+			},
+		},
+	}}
+
+	// members of builtin modules
+	expectRun(t, `
+out = [import("mod").pi, import("mod").name, import("mod").ok,
+	import("mod").missing, import("mod").double(2)]`,
+		Opts().Module("mod", mod),
+		ARR{3.14, "mod", true, slim.UndefinedValue, 4})
+	expectRun(t, `
+m := import("mod")
+out = 0
+for i := 0; i < 10; i++ { out += m.double(i) }`,
+		Opts().Module("mod", mod), 90)
+
+	// the same instruction selecting from different maps
+	expectRun(t, `
+f := func(m) { return m.a }
+a := immutable({a: 1})
+out = [f(a), f(a), f(immutable({a: 2})), f({a: 3}), f(immutable({b: 1})),
+	f(a)]`, nil, ARR{1, 1, 2, 3, slim.UndefinedValue, 1})
+	expectRun(t, `
+f := func(m) { return [m.a, m.b] }
+out = f(immutable({a: 1, b: 2}))`, nil, ARR{1, 2})
+	expectRun(t, `
+m := {a: 1}
+f := func() { return m.a }
+x := f()
+m.a = 2
+out = [x, f()]`, nil, ARR{1, 2})
+
+	// immutable maps sharing their values with mutable maps
+	expectRun(t, `
+m := {a: 1}
+im := immutable(m)
+out = []
+for i := 0; i < 2; i++ { out = append(out, im.a); m.a = 2 }`,
+		nil, ARR{1, 2})
+	expectError(t, `func(m) { return m.a }(1)`, nil,
+		"not indexable: string")
+}